@@ -0,0 +1,96 @@
+package pprofetheus
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// AdminHandler returns an http.Handler that controls the collector c at
+// runtime, e.g. with curl from a runbook during an incident. It serves
+//
+//	POST /start[?duration=10m]            starts profiling, for duration if given
+//	POST /stop                            stops profiling
+//	POST /sample_rate?hz=250              changes the sampling rate
+//	POST /filter?include=...&exclude=...  replaces the function filter
+//	GET  /status                          reports whether c is healthy
+//
+// The handler doesn't authenticate requests, so it must only be exposed to
+// operators. To mount it below a path, wrap it in http.StripPrefix.
+func AdminHandler(c ProfileCollector) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", adminPost(func(r *http.Request) error {
+		if duration := r.FormValue("duration"); duration != "" {
+			d, err := time.ParseDuration(duration)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid duration %q", duration)
+			}
+			c.StartFor(d)
+			return nil
+		}
+		c.Start()
+		return nil
+	}))
+	mux.HandleFunc("/stop", adminPost(func(r *http.Request) error {
+		c.Stop()
+		return nil
+	}))
+	mux.HandleFunc("/sample_rate", adminPost(func(r *http.Request) error {
+		hz, err := strconv.Atoi(r.FormValue("hz"))
+		if err != nil {
+			return fmt.Errorf("invalid sampling rate %q", r.FormValue("hz"))
+		}
+		return c.SetSampleRate(hz)
+	}))
+	mux.HandleFunc("/filter", adminPost(func(r *http.Request) error {
+		include, err := compileFilter(r.FormValue("include"))
+		if err != nil {
+			return err
+		}
+		exclude, err := compileFilter(r.FormValue("exclude"))
+		if err != nil {
+			return err
+		}
+		c.SetFunctionFilter(include, exclude)
+		return nil
+	}))
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if healthy, err := c.Healthy(); !healthy {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// adminPost returns a handler that runs f for POST requests and reports its
+// error as bad request.
+func adminPost(f func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := f(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// compileFilter compiles the regular expression expr of a function filter,
+// which is nil if expr is empty.
+func compileFilter(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
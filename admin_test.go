@@ -0,0 +1,52 @@
+package pprofetheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+	c, prof := newFakeCollector(t, symbols, nil, &fakeClock{now: time.Unix(0, 0)})
+	h := AdminHandler(c)
+
+	testData := []struct {
+		Method         string
+		Target         string
+		ExpectedStatus int
+	}{
+		{http.MethodGet, "/status", http.StatusServiceUnavailable},
+		{http.MethodGet, "/start", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/start?duration=soon", http.StatusBadRequest},
+		{http.MethodPost, "/start", http.StatusOK},
+		{http.MethodGet, "/status", http.StatusOK},
+		{http.MethodPost, "/sample_rate?hz=0", http.StatusBadRequest},
+		{http.MethodPost, "/sample_rate?hz=250", http.StatusOK},
+		{http.MethodPost, "/filter?include=(", http.StatusBadRequest},
+		{http.MethodPost, "/filter?include=^main%5C.&exclude=main%5C.init", http.StatusOK},
+		{http.MethodPost, "/stop", http.StatusOK},
+		{http.MethodPost, "/unknown", http.StatusNotFound},
+	}
+
+	for idx, testEntry := range testData {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(testEntry.Method, testEntry.Target, nil))
+		if w.Code != testEntry.ExpectedStatus {
+			t.Errorf("%d. %s %s: status %d, expected %d: %s", idx, testEntry.Method, testEntry.Target, w.Code, testEntry.ExpectedStatus, w.Body)
+		}
+	}
+
+	if prof.hz != 250 {
+		t.Errorf("sampling rate = %d, expected 250", prof.hz)
+	}
+	if c.opts.include.String() != `^main\.` || c.opts.exclude.String() != `main\.init` {
+		t.Errorf("filter = %v, %v", c.opts.include, c.opts.exclude)
+	}
+	if c.running {
+		t.Errorf("collector is still running")
+	}
+}
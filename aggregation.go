@@ -0,0 +1,83 @@
+package pprofetheus
+
+import (
+	"strings"
+)
+
+// Aggregation determines what the metrics of a collector are labeled by.
+type Aggregation int
+
+const (
+	// ByFunction labels metrics by function, e.g. "net/http.(*conn).serve".
+	// It is the default.
+	ByFunction Aggregation = iota
+	// ByPackage sums up metrics per Go package, e.g. "net/http", and labels
+	// them "package" instead of "function". It keeps the number of series
+	// low for large programs.
+	ByPackage
+)
+
+// WithAggregation sets what the metrics are labeled by, i.e. whether they are
+// aggregated per function or per package. With ByPackage, a sample is
+// accounted once per package in the cumulated metrics, regardless of how many
+// of its functions are on the stack.
+func WithAggregation(a Aggregation) Option {
+	return func(o *options) {
+		o.aggregation = a
+	}
+}
+
+// seenSet is the set of label values that a sample has been accounted to in
+// the cumulated metrics. A nil seenSet accounts to every value.
+type seenSet map[string]bool
+
+// newSeenSet returns the seenSet for accounting a sample in the cumulated
+// metrics. With ByFunction aggregation and no LabelMapper, a sample is
+// accounted for each of its locations.
+func (o *options) newSeenSet() seenSet {
+	if o.aggregation == ByPackage || o.labelMapper != nil {
+		return make(seenSet)
+	}
+	return nil
+}
+
+// add adds the value to the set and returns true if it hasn't been in it
+// before.
+func (s seenSet) add(value string) bool {
+	if s == nil {
+		return true
+	}
+	if s[value] {
+		return false
+	}
+	s[value] = true
+	return true
+}
+
+// labelName returns the default name of the label that metrics are aggregated
+// by.
+func (a Aggregation) labelName() string {
+	if a == ByPackage {
+		return "package"
+	}
+	return "function"
+}
+
+// packageName returns the import path of the package of the function symbol
+// name, e.g. "gopkg.in/yaml.v2" for "gopkg.in/yaml%2ev2.(*decoder).unmarshal".
+// Names that don't belong to a Go package, e.g. of C functions, are returned
+// unchanged.
+func packageName(name string) string {
+	// type parameters may contain import paths themselves.
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i]
+	}
+	// the package ends at the first dot after the last slash, as the linker
+	// escapes dots in the last element of import paths.
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.IndexByte(name[slash+1:], '.')
+	if dot < 0 {
+		return name
+	}
+	return strings.Replace(name[:slash+1+dot], "%2e", ".", -1)
+}
@@ -0,0 +1,66 @@
+package pprofetheus
+
+import (
+	"testing"
+)
+
+func TestPackageName(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		pkg  string
+	}{
+		{"main.main", "main"},
+		{"runtime.goexit", "runtime"},
+		{"net/http.(*conn).serve", "net/http"},
+		{"github.com/example/project/pkg.compute.func1", "github.com/example/project/pkg"},
+		{"gopkg.in/yaml%2ev2.(*decoder).unmarshal", "gopkg.in/yaml.v2"},
+		{"github.com/example/project/pkg.Map[go.shape.string,github.com/example/project/other.T]", "github.com/example/project/pkg"},
+		{"malloc", "malloc"},
+		{"", ""},
+	} {
+		if pkg := packageName(tt.name); pkg != tt.pkg {
+			t.Errorf("packageName(%q) = %q, expected %q", tt.name, pkg, tt.pkg)
+		}
+	}
+}
+
+func TestCPUProfileCollectorAggregationByPackage(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "net/http.(*conn).serve", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "net/http.HandlerFunc.ServeHTTP", Addr: 0x1200, Size: 0x100, Code: 'T'},
+		{Name: "encoding/json.Marshal", Addr: 0x1300, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithAggregation(ByPackage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.addProfile(testProfile(t, symbols,
+		[]string{"encoding/json.Marshal", "net/http.HandlerFunc.ServeHTTP", "net/http.(*conn).serve"},
+		[]string{"net/http.HandlerFunc.ServeHTTP", "net/http.(*conn).serve", "main.main"},
+	))
+
+	for _, tt := range []struct {
+		pkg       string
+		flat, cum float64
+	}{
+		{"encoding/json", 10, 10},
+		{"net/http", 10, 20},
+		{"main", 0, 10},
+	} {
+		if value := counterValue(t, c.timeUsed.WithLabelValues(tt.pkg)); value != tt.flat {
+			t.Errorf("time used by %s = %f, expected %f", tt.pkg, value, tt.flat)
+		}
+		if value := counterValue(t, c.timeUsedCum.WithLabelValues(tt.pkg)); value != tt.cum {
+			t.Errorf("cumulated time used by %s = %f, expected %f", tt.pkg, value, tt.cum)
+		}
+	}
+
+	for _, m := range collectMetrics(c.timeUsed) {
+		if _, ok := functionLabel(t, m); ok {
+			t.Error("metric is labeled by function instead of package")
+		}
+	}
+}
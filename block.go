@@ -0,0 +1,27 @@
+package pprofetheus
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewBlockProfileCollector creates a collector that exports the time that
+// goroutines spent blocked, e.g. on channel operations or in select
+// statements, in milliseconds by function, as recorded by the runtime's
+// block profile. It enables the block profile with
+// runtime.SetBlockProfileRate(rate), so that on average one blocking event
+// per rate nanoseconds spent blocked is sampled.
+func NewBlockProfileCollector(rate int, opts ...Option) prometheus.Collector {
+	runtime.SetBlockProfileRate(rate)
+
+	return newRuntimeProfileCollector(lookupProfile("block"), blockProfileMetric, newOptions(opts))
+}
+
+var blockProfileMetric = runtimeProfileMetric{
+	subsystem: "block",
+	name:      "time_ms",
+	help:      "time spent blocked in milliseconds",
+	valueType: "delay",
+	divisor:   nanoToMilliDivisor,
+}
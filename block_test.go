@@ -0,0 +1,98 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func TestRuntimeProfileCollector(t *testing.T) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "contentions", Unit: "count"},
+			{Type: "delay", Unit: "nanoseconds"},
+		},
+		PeriodType: &profile.ValueType{Type: "contentions", Unit: "count"},
+		Period:     1,
+		Function: []*profile.Function{
+			{ID: 1, Name: "main.main"},
+			{ID: 2, Name: "main.recurse"},
+			{ID: 3, Name: "runtime.chanrecv1"},
+		},
+	}
+	for i, f := range p.Function {
+		p.Location = append(p.Location, &profile.Location{ID: uint64(i + 1), Address: uint64(0x1000 * (i + 1)), Line: []profile.Line{{Function: f}}})
+	}
+	main, recurse, chanrecv := p.Location[0], p.Location[1], p.Location[2]
+	p.Sample = []*profile.Sample{
+		{Location: []*profile.Location{chanrecv, recurse, recurse, main}, Value: []int64{1, 20000000}},
+		{Location: []*profile.Location{recurse, main}, Value: []int64{2, 5000000}},
+	}
+
+	var data bytes.Buffer
+	if err := p.Write(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newRuntimeProfileCollector(func() ([]byte, error) {
+		return data.Bytes(), nil
+	}, runtimeProfileMetric{
+		subsystem: "block",
+		name:      "time_ms",
+		help:      "time spent blocked in milliseconds",
+		valueType: "delay",
+		divisor:   nanoToMilliDivisor,
+	}, newOptions(nil))
+
+	expected := map[string]map[string]float64{
+		c.value.String(): {
+			"runtime.chanrecv1": 20,
+			"main.recurse":      5,
+		},
+		c.valueCum.String(): {
+			"runtime.chanrecv1": 20,
+			"main.recurse":      25,
+			"main.main":         25,
+		},
+	}
+	for _, m := range collectMetrics(c) {
+		fn, _ := functionLabel(t, m)
+		values := expected[m.Desc().String()]
+		if value, ok := values[fn]; !ok || counterValue(t, m) != value {
+			t.Errorf("unexpected value %f of %s for %q", counterValue(t, m), m.Desc(), fn)
+		}
+		delete(values, fn)
+	}
+	for desc, values := range expected {
+		if len(values) != 0 {
+			t.Errorf("missing series of %s: %v", desc, values)
+		}
+	}
+}
+
+func TestBlockProfileCollector(t *testing.T) {
+	c := NewBlockProfileCollector(1)
+	defer runtime.SetBlockProfileRate(0)
+
+	c1 := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(c1)
+	}()
+	blockOn(c1)
+
+	for _, m := range collectMetrics(c) {
+		if fn, _ := functionLabel(t, m); fn == "github.com/travelaudience/pprofetheus.blockOn" && counterValue(t, m) > 0 {
+			return
+		}
+	}
+	t.Errorf("no blocked time recorded for blockOn")
+}
+
+//go:noinline
+func blockOn(c chan struct{}) {
+	<-c
+}
@@ -0,0 +1,216 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// defaultBrokerProfileSeconds is the duration of profiles downloaded from the
+// broker's handler if the request doesn't specify one, as in net/http/pprof.
+const defaultBrokerProfileSeconds = 30
+
+// Broker owns the CPU profiler of the process and shares the profile data it
+// records between its consumers, such as CPU profile collectors created with
+// WithBroker and the pprof downloads served by its ProfileHandler. The runtime
+// only supports a single CPU profile at a time, so without a broker, a profile
+// started by other code, e.g. net/http/pprof, breaks the collector and vice
+// versa.
+//
+// The profiler runs at the sampling rate of the consumer that started it,
+// until all consumers have stopped.
+type Broker struct {
+	sync.Mutex
+	profiler      profiler
+	clock         clock
+	hz            int
+	running       bool
+	subscriptions map[*brokerSubscription]bool
+}
+
+// NewBroker creates a Broker. There should only be one in a process, and no
+// other code should start a CPU profile on its own, e.g. with
+// runtime/pprof.StartCPUProfile.
+func NewBroker() *Broker {
+	return &Broker{
+		profiler:      newRuntimeProfiler(),
+		clock:         realClock{},
+		subscriptions: make(map[*brokerSubscription]bool),
+	}
+}
+
+// brokerSubscription collects the profile data that a consumer receives
+// from a Broker, one profile per read of the profiler.
+type brokerSubscription struct {
+	data [][]byte
+}
+
+// subscribe starts a subscription to the profile data recorded from now on,
+// starting the profiler with hz samples per second if it isn't running yet.
+func (b *Broker) subscribe(hz int) (*brokerSubscription, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.running {
+		// the data recorded so far belongs to the existing subscriptions.
+		b.read()
+	} else {
+		b.hz = hz
+	}
+	if err := b.start(); err != nil {
+		return nil, err
+	}
+
+	s := &brokerSubscription{}
+	b.subscriptions[s] = true
+	return s, nil
+}
+
+// unsubscribe ends the subscription s and returns the profile data it
+// received. The profiler is stopped if there are no subscriptions left.
+func (b *Broker) unsubscribe(s *brokerSubscription) ([]byte, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.running {
+		b.read()
+	}
+	delete(b.subscriptions, s)
+	if len(b.subscriptions) > 0 {
+		// if the profiler can't be restarted, e.g. because other code has
+		// started a CPU profile in the meantime, the next subscription
+		// retries.
+		b.start()
+	}
+
+	return mergeProfileData(s.data)
+}
+
+// start starts the profiler.
+func (b *Broker) start() error {
+	err := b.profiler.Start(b.hz)
+	b.running = err == nil
+	return err
+}
+
+// read stops the profiler and hands the data recorded since it was started to
+// all subscriptions.
+func (b *Broker) read() {
+	data := b.profiler.Stop()
+	b.running = false
+	if len(data) == 0 {
+		return
+	}
+	for s := range b.subscriptions {
+		s.data = append(s.data, data)
+	}
+}
+
+// mergeProfileData merges the profiles in data into a single one.
+func mergeProfileData(data [][]byte) ([]byte, error) {
+	switch len(data) {
+	case 0:
+		return nil, nil
+	case 1:
+		return data[0], nil
+	}
+
+	var merged *profile.Profile
+	for _, d := range data {
+		p, err := profile.ParseData(d)
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = p
+			continue
+		}
+		if err := merged.Merge(p, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := merged.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// brokerProfiler is the profiler of a collector that receives its profile data
+// from a Broker.
+type brokerProfiler struct {
+	broker       *Broker
+	subscription *brokerSubscription
+	opts         *options
+}
+
+func (p *brokerProfiler) Start(hz int) error {
+	s, err := p.broker.subscribe(hz)
+	if err != nil {
+		return err
+	}
+	p.subscription = s
+	return nil
+}
+
+func (p *brokerProfiler) Stop() []byte {
+	if p.subscription == nil {
+		return nil
+	}
+	data, err := p.broker.unsubscribe(p.subscription)
+	p.subscription = nil
+	if err != nil {
+		p.opts.log(LevelError, "reading profile from broker failed", "err", err)
+	}
+	return data
+}
+
+// ProfileHandler returns an http.Handler that serves CPU profiles in pprof
+// format like net/http/pprof.Profile, but records them through the broker, so
+// that collectors keep running meanwhile. It takes the duration in seconds
+// from the parameter "seconds", e.g. "/debug/pprof/profile?seconds=10".
+// Register it in place of net/http/pprof's handler:
+//
+//	http.Handle("/debug/pprof/profile", broker.ProfileHandler())
+func (b *Broker) ProfileHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seconds := defaultBrokerProfileSeconds
+		if s := r.FormValue("seconds"); s != "" {
+			var err error
+			if seconds, err = strconv.Atoi(s); err != nil || seconds <= 0 {
+				http.Error(w, fmt.Sprintf("invalid duration %q", s), http.StatusBadRequest)
+				return
+			}
+		}
+
+		s, err := b.subscribe(cpuProfileRate)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not enable CPU profiling: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		t := b.clock.NewTicker(time.Duration(seconds) * time.Second)
+		select {
+		case <-t.Chan():
+		case <-r.Context().Done():
+		}
+		t.Stop()
+
+		data, err := b.unsubscribe(s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not read CPU profile: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+		w.Write(data)
+	})
+}
@@ -0,0 +1,87 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func TestBroker(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+	var data bytes.Buffer
+	if err := testProfile(t, symbols, []string{"main.main"}).Write(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	clk := &fakeClock{now: time.Unix(0, 0), ticker: newFakeTicker()}
+	prof := &fakeProfiler{data: data.Bytes()}
+	broker := NewBroker()
+	broker.profiler = prof
+	broker.clock = clk
+
+	o := newOptions([]Option{WithSymbols(symbols), WithBroker(broker)})
+	o.clock = &fakeClock{now: time.Unix(0, 0)}
+	c := newCPUProfileCollector(newSymbolTable(symbols), o)
+	c.Start()
+
+	subscriptions := func() int {
+		broker.Lock()
+		defer broker.Unlock()
+		return len(broker.subscriptions)
+	}
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		broker.ProfileHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/profile?seconds=5", nil))
+		close(done)
+	}()
+	waitFor(t, func() bool { return subscriptions() == 2 })
+
+	// the collector keeps accumulating metrics while the download is in
+	// progress.
+	c.Flush()
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != 20 {
+		t.Errorf("time used by main.main = %f, expected 20", value)
+	}
+
+	clk.ticker.c <- time.Time{}
+	<-done
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	p, err := profile.Parse(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, s := range p.Sample {
+		total += s.Value[1]
+	}
+	if total != 30000000 {
+		t.Errorf("downloaded profile contains %d ns, expected 30000000", total)
+	}
+
+	c.Stop()
+	if n := subscriptions(); n != 0 || prof.running {
+		t.Errorf("%d subscriptions left, profiler running: %t", n, prof.running)
+	}
+}
+
+func TestBrokerProfileHandlerInvalidDuration(t *testing.T) {
+	broker := NewBroker()
+	broker.profiler = &fakeProfiler{}
+
+	w := httptest.NewRecorder()
+	broker.ProfileHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/profile?seconds=forever", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,130 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultCloudProfilerURL is the endpoint of the Cloud Profiler API.
+const defaultCloudProfilerURL = "https://cloudprofiler.googleapis.com"
+
+// CloudProfilerConfig configures uploading profiles to Google Cloud Profiler
+// with WithCloudProfiler.
+type CloudProfilerConfig struct {
+	// ProjectID is the Google Cloud project that the profiles belong to.
+	ProjectID string
+	// Service is the name of the service, e.g. "checkout", and
+	// ServiceVersion its version, e.g. "1.4.2", if known.
+	Service        string
+	ServiceVersion string
+	// Zone is the zone that the service runs in, e.g. "europe-west1-b", if
+	// known.
+	Zone string
+	// Labels are added to the profiles, e.g. {"instance": "checkout-7d9f"}.
+	Labels map[string]string
+	// Client sends the uploads. It has to authorize them, e.g. a client
+	// created by golang.org/x/oauth2/google.DefaultClient with the scope
+	// "https://www.googleapis.com/auth/monitoring.write".
+	Client *http.Client
+	// URL is the endpoint of the API, which is defaultCloudProfilerURL
+	// unless set.
+	URL string
+}
+
+// WithCloudProfiler makes the collector upload every profile that it reads to
+// Google Cloud Profiler as configured by cfg, in addition to exporting the
+// metrics, so that a service doesn't need the Cloud Profiler agent, which
+// starts CPU profiles of its own that would break the collector. Profiles are
+// uploaded in the background as offline profiles, like WithPyroscope pushes
+// them, and counted in pprof_cpu_cloud_profiler_pushes_total and
+// pprof_cpu_cloud_profiler_push_errors_total.
+func WithCloudProfiler(cfg CloudProfilerConfig) Option {
+	return func(o *options) {
+		o.cloudProfiler = &cfg
+	}
+}
+
+// cloudProfilerProfile is a profile in the format of the Cloud Profiler API.
+type cloudProfilerProfile struct {
+	ProfileType  string                  `json:"profileType"`
+	Deployment   cloudProfilerDeployment `json:"deployment"`
+	Duration     string                  `json:"duration"`
+	ProfileBytes []byte                  `json:"profileBytes"`
+	Labels       map[string]string       `json:"labels,omitempty"`
+}
+
+type cloudProfilerDeployment struct {
+	ProjectID string            `json:"projectId"`
+	Target    string            `json:"target"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// cloudProfilerSender uploads profiles to Cloud Profiler.
+type cloudProfilerSender struct {
+	cfg CloudProfilerConfig
+}
+
+func newCloudProfilerPusher(o *options) *profilePusher {
+	s := &cloudProfilerSender{cfg: *o.cloudProfiler}
+	if s.cfg.URL == "" {
+		s.cfg.URL = defaultCloudProfilerURL
+	}
+	return newProfilePusher(o, "cloud_profiler", "Cloud Profiler", s.send)
+}
+
+// validateCloudProfilerConfig returns an error if cfg lacks required fields.
+func validateCloudProfilerConfig(cfg *CloudProfilerConfig) error {
+	if cfg.ProjectID == "" || cfg.Service == "" {
+		return fmt.Errorf("invalid Cloud Profiler config: project ID and service are required")
+	}
+	if cfg.Client == nil {
+		return fmt.Errorf("invalid Cloud Profiler config: an authorized client is required")
+	}
+	return nil
+}
+
+// send uploads the profile data, see profilePusher.send.
+func (s *cloudProfilerSender) send(from, until time.Time, hz int, data []byte) (string, error) {
+	labels := map[string]string{"language": "go"}
+	if s.cfg.ServiceVersion != "" {
+		labels["version"] = s.cfg.ServiceVersion
+	}
+	if s.cfg.Zone != "" {
+		labels["zone"] = s.cfg.Zone
+	}
+
+	body, err := json.Marshal(cloudProfilerProfile{
+		ProfileType: "CPU",
+		Deployment: cloudProfilerDeployment{
+			ProjectID: s.cfg.ProjectID,
+			Target:    s.cfg.Service,
+			Labels:    labels,
+		},
+		Duration:     fmt.Sprintf("%.3fs", until.Sub(from).Seconds()),
+		ProfileBytes: data,
+		Labels:       s.cfg.Labels,
+	})
+	if err != nil {
+		return reasonEncode, err
+	}
+
+	endpoint := strings.TrimSuffix(s.cfg.URL, "/") + "/v2/projects/" + url.PathEscape(s.cfg.ProjectID) + "/profiles:createOffline"
+	resp, err := s.cfg.Client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return reasonRequest, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return reasonStatus, fmt.Errorf("uploading to %s failed: %s", endpoint, resp.Status)
+	}
+	return "", nil
+}
@@ -0,0 +1,68 @@
+package pprofetheus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCPUProfileCollectorCloudProfiler(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	type upload struct {
+		path    string
+		profile cloudProfilerProfile
+	}
+	uploads := make(chan upload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var u upload
+		u.path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&u.profile); err != nil {
+			t.Errorf("decoding upload failed: %v", err)
+		}
+		uploads <- u
+	}))
+	defer server.Close()
+
+	c, _ := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Unix(1500000000, 0)}, WithCloudProfiler(CloudProfilerConfig{
+		ProjectID:      "acme",
+		Service:        "checkout",
+		ServiceVersion: "1.4.2",
+		Client:         server.Client(),
+		URL:            server.URL,
+	}))
+
+	c.Start()
+	defer c.Stop()
+	c.Flush()
+
+	u := <-uploads
+	if u.path != "/v2/projects/acme/profiles:createOffline" {
+		t.Errorf("path = %s, expected /v2/projects/acme/profiles:createOffline", u.path)
+	}
+	d := u.profile.Deployment
+	if u.profile.ProfileType != "CPU" || d.ProjectID != "acme" || d.Target != "checkout" || d.Labels["version"] != "1.4.2" || d.Labels["language"] != "go" {
+		t.Errorf("unexpected profile %+v", u.profile)
+	}
+	if len(u.profile.ProfileBytes) == 0 {
+		t.Error("upload contains no profile data")
+	}
+	waitFor(t, func() bool { return counterValue(t, c.pushers[0].pushes) == 1 })
+}
+
+func TestNewCPUProfileCollectorCloudProfilerInvalid(t *testing.T) {
+	testData := []CloudProfilerConfig{
+		{Service: "checkout", Client: http.DefaultClient},
+		{ProjectID: "acme", Client: http.DefaultClient},
+		{ProjectID: "acme", Service: "checkout"},
+	}
+	for idx, cfg := range testData {
+		if _, err := NewCPUProfileCollector(WithCloudProfiler(cfg)); err == nil {
+			t.Errorf("%d. NewCPUProfileCollector succeeded with %+v", idx, cfg)
+		}
+	}
+}
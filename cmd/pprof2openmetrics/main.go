@@ -0,0 +1,185 @@
+// Command pprof2openmetrics converts CPU profiles in pprof format to the
+// metrics of pprofetheus in the OpenMetrics text format, with a timestamp at
+// the end of each profile, e.g. to backfill the profiles captured during an
+// incident into Prometheus:
+//
+//	pprof2openmetrics cpu-*.pprof > cpu.om
+//	promtool tsdb create-blocks-from openmetrics cpu.om data/
+//
+// The profiles are accounted in the order of their start time, so the metrics
+// accumulate like the ones of a collector that has read them one after the
+// other. As OpenMetrics requires counters to be suffixed with _total, the
+// metrics are emitted with type unknown, so that their names match the ones
+// scraped from a collector.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/travelaudience/pprofetheus"
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func main() {
+	namespace := flag.String("namespace", "pprof", "namespace of the metrics")
+	binaryPath := flag.String("binary", "", "binary of the profiled program, to resolve profiles without function information")
+	byPackage := flag.Bool("by-package", false, "aggregate the metrics per package instead of per function")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] profile...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := []pprofetheus.Option{pprofetheus.WithNamespace(*namespace)}
+	if *binaryPath != "" {
+		opts = append(opts, pprofetheus.WithBinaryPath(*binaryPath))
+	} else {
+		// the symbols of this binary are of no use for the profiles.
+		opts = append(opts, pprofetheus.WithSymbols([]pprofetheus.Symbol{}))
+	}
+	if *byPackage {
+		opts = append(opts, pprofetheus.WithAggregation(pprofetheus.ByPackage))
+	}
+
+	if err := convert(os.Stdout, flag.Args(), opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// timedProfile is the data of a profile file along with the time span it
+// covers.
+type timedProfile struct {
+	path  string
+	data  []byte
+	start time.Time
+	end   time.Time
+}
+
+// convert writes the metrics of the profile files paths to w in the
+// OpenMetrics text format.
+func convert(w io.Writer, paths []string, opts []pprofetheus.Option) error {
+	var profiles []timedProfile
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		p, err := profile.ParseData(data)
+		if err != nil {
+			return fmt.Errorf("parsing %s failed: %v", path, err)
+		}
+		if p.TimeNanos == 0 {
+			return fmt.Errorf("%s has no timestamp", path)
+		}
+		start := time.Unix(0, p.TimeNanos)
+		profiles = append(profiles, timedProfile{path, data, start, start.Add(time.Duration(p.DurationNanos))})
+	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].start.Before(profiles[j].start)
+	})
+
+	collector, err := pprofetheus.NewCPUProfileCollector(opts...)
+	if err != nil {
+		return err
+	}
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(collector); err != nil {
+		return err
+	}
+
+	families := make(map[string]*dto.MetricFamily)
+	for _, p := range profiles {
+		if err := collector.Ingest(bytes.NewReader(p.data)); err != nil {
+			return fmt.Errorf("ingesting %s failed: %v", p.path, err)
+		}
+		gathered, err := reg.Gather()
+		if err != nil {
+			return err
+		}
+		ts := p.end.UnixNano() / int64(time.Millisecond)
+		for _, f := range gathered {
+			if !aggregated(f) {
+				continue
+			}
+			if _, ok := families[f.GetName()]; !ok {
+				families[f.GetName()] = &dto.MetricFamily{Name: f.Name, Help: f.Help, Type: dto.MetricType_UNTYPED.Enum()}
+			}
+			for _, m := range f.Metric {
+				families[f.GetName()].Metric = append(families[f.GetName()].Metric, untyped(m, ts))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := families[name]
+		// the samples of a series have to be consecutive, in the order of
+		// their timestamps.
+		sort.SliceStable(f.Metric, func(i, j int) bool {
+			return seriesKey(f.Metric[i]) < seriesKey(f.Metric[j])
+		})
+		if _, err := expfmt.MetricFamilyToOpenMetrics(w, f); err != nil {
+			return err
+		}
+	}
+	_, err = expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+// aggregated returns true if the metric family f is labeled by function or
+// package, i.e. it is one of the metrics of the CPU time of functions rather
+// than a metric about the collector.
+func aggregated(f *dto.MetricFamily) bool {
+	for _, m := range f.Metric {
+		for _, l := range m.Label {
+			if l.GetName() == "function" || l.GetName() == "package" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// untyped returns the value of the counter or gauge m as metric of unknown
+// type at the timestamp ts in milliseconds.
+func untyped(m *dto.Metric, ts int64) *dto.Metric {
+	value := m.GetCounter().GetValue()
+	if m.Gauge != nil {
+		value = m.GetGauge().GetValue()
+	}
+	return &dto.Metric{
+		Label:       m.Label,
+		Untyped:     &dto.Untyped{Value: &value},
+		TimestampMs: &ts,
+	}
+}
+
+// seriesKey returns the labels of the metric m, which identify its series.
+func seriesKey(m *dto.Metric) string {
+	pairs := make([]string, 0, len(m.Label))
+	for _, l := range m.Label {
+		pairs = append(pairs, l.GetName()+"\x00"+l.GetValue())
+	}
+	return strings.Join(pairs, "\x00")
+}
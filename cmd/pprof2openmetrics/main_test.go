@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/travelaudience/pprofetheus"
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// writeProfile writes a CPU profile that started at start, with a sample of
+// 10ms in each of the stacks, to a file in dir.
+func writeProfile(t *testing.T, dir string, start time.Time, stacks ...[]string) string {
+	functions := make(map[string]*profile.Function)
+	locations := make(map[string]*profile.Location)
+	p := &profile.Profile{
+		SampleType:    []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		TimeNanos:     start.UnixNano(),
+		DurationNanos: int64(10 * time.Second),
+	}
+	for _, stack := range stacks {
+		s := &profile.Sample{Value: []int64{1, int64(10 * time.Millisecond)}}
+		for _, name := range stack {
+			l, ok := locations[name]
+			if !ok {
+				f := &profile.Function{ID: uint64(len(functions) + 1), Name: name, SystemName: name}
+				functions[name] = f
+				p.Function = append(p.Function, f)
+				l = &profile.Location{ID: uint64(len(locations) + 1), Address: 0x1000 * uint64(len(locations)+1), Line: []profile.Line{{Function: f}}}
+				locations[name] = l
+				p.Location = append(p.Location, l)
+			}
+			s.Location = append(s.Location, l)
+		}
+		p.Sample = append(p.Sample, s)
+	}
+
+	path := filepath.Join(dir, start.UTC().Format("20060102T150405Z")+".pprof")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := p.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof2openmetrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1500000000, 0)
+	later := writeProfile(t, dir, start.Add(time.Minute), []string{"main.main"})
+	earlier := writeProfile(t, dir, start, []string{"main.compute", "main.main"})
+
+	var out bytes.Buffer
+	if err := convert(&out, []string{later, earlier}, []pprofetheus.Option{pprofetheus.WithSymbols([]pprofetheus.Symbol{})}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"# TYPE pprof_cpu_time_used_ms unknown\n",
+		`pprof_cpu_time_used_ms{function="main.compute"} 10.0 1.50000001e+09` + "\n",
+		// main.main only appears in the second profile.
+		`pprof_cpu_time_used_ms{function="main.compute"} 10.0 1.50000007e+09` + "\n" +
+			`pprof_cpu_time_used_ms{function="main.main"} 10.0 1.50000007e+09` + "\n",
+		`pprof_cpu_time_used_cum_ms{function="main.main"} 20.0 1.50000007e+09` + "\n",
+		"# EOF\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output doesn't contain %q:\n%s", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "collect_duration") {
+		t.Errorf("output contains metrics about the collector:\n%s", out.String())
+	}
+}
+
+func TestConvertInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof2openmetrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "garbage.pprof")
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := convert(ioutil.Discard, []string{path}, nil); err == nil {
+		t.Error("convert succeeded with an invalid profile")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/travelaudience/pprofetheus"
+)
+
+const (
+	// defaultAnnotation marks the pods to profile if set to "true".
+	defaultAnnotation = "pprofetheus.io/scrape"
+	// portAnnotation sets the port of the pod's net/http/pprof endpoints.
+	portAnnotation = "pprofetheus.io/port"
+	// defaultPort is the port of the pods without portAnnotation.
+	defaultPort = "6060"
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// podList is the part of the Kubernetes API's list of pods that the
+// discovery uses.
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name        string            `json:"name"`
+			Namespace   string            `json:"namespace"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Status struct {
+			Phase string `json:"phase"`
+			PodIP string `json:"podIP"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// podTarget is a pod to profile.
+type podTarget struct {
+	namespace string
+	name      string
+	url       string
+}
+
+// discovery keeps the collectors registered with a registry in line with the
+// annotated pods of a Kubernetes cluster.
+type discovery struct {
+	reg        prometheus.Registerer
+	client     *http.Client
+	apiURL     string
+	token      string
+	annotation string
+	namespace  string
+	profiles   []string
+	duration   time.Duration
+	opts       []pprofetheus.Option
+
+	mu         sync.Mutex
+	collectors map[podTarget][]prometheus.Collector
+}
+
+// newInClusterDiscovery returns a discovery that accesses the Kubernetes API
+// with the service account of the pod the agent runs in.
+func newInClusterDiscovery(reg prometheus.Registerer, annotation, namespace string, profiles []string, duration time.Duration, opts []pprofetheus.Option) (*discovery, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("reading service account token failed: %v", err)
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading service account CA certificate failed: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in service account CA certificate")
+	}
+	client := &http.Client{
+		Timeout:   fetchTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	apiURL := "https://" + net.JoinHostPort(host, port)
+	return newDiscovery(reg, client, apiURL, strings.TrimSpace(string(token)), annotation, namespace, profiles, duration, opts), nil
+}
+
+func newDiscovery(reg prometheus.Registerer, client *http.Client, apiURL, token, annotation, namespace string, profiles []string, duration time.Duration, opts []pprofetheus.Option) *discovery {
+	return &discovery{
+		reg:        reg,
+		client:     client,
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		token:      token,
+		annotation: annotation,
+		namespace:  namespace,
+		profiles:   profiles,
+		duration:   duration,
+		opts:       opts,
+		collectors: make(map[podTarget][]prometheus.Collector),
+	}
+}
+
+// run updates the collectors every interval. It never returns.
+func (d *discovery) run(interval time.Duration) {
+	for {
+		if err := d.refresh(); err != nil {
+			log.Printf("discovering pods failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// refresh registers collectors for the newly annotated pods and unregisters
+// the collectors of the pods that are gone. The collectors of vanished targets
+// are unregistered first, as a pod that is recreated with a new IP has the
+// same labels and couldn't be registered while its old target is.
+func (d *discovery) refresh() error {
+	targets, err := d.targets()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := make(map[podTarget]bool, len(targets))
+	for _, t := range targets {
+		current[t] = true
+	}
+	for t, collectors := range d.collectors {
+		if current[t] {
+			continue
+		}
+		for _, c := range collectors {
+			d.reg.Unregister(c)
+		}
+		delete(d.collectors, t)
+	}
+	for _, t := range targets {
+		if _, ok := d.collectors[t]; ok {
+			continue
+		}
+		opts := append(d.opts[:len(d.opts):len(d.opts)], pprofetheus.WithConstLabels(prometheus.Labels{
+			"namespace": t.namespace,
+			"pod":       t.name,
+		}))
+		collectors, err := registerCollectors(d.reg, t.url, d.profiles, d.duration, "", opts)
+		if err != nil {
+			log.Printf("profiling pod %s/%s failed: %v", t.namespace, t.name, err)
+			continue
+		}
+		d.collectors[t] = collectors
+	}
+	return nil
+}
+
+// targets lists the running pods with the annotation set to "true".
+func (d *discovery) targets() ([]podTarget, error) {
+	path := "/api/v1/pods"
+	if d.namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(d.namespace) + "/pods"
+	}
+	req, err := http.NewRequest(http.MethodGet, d.apiURL+path+"?fieldSelector="+url.QueryEscape("status.phase=Running"), nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("listing pods failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing pods failed: %s", resp.Status)
+	}
+	var list podList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decoding pods failed: %v", err)
+	}
+
+	var targets []podTarget
+	for _, pod := range list.Items {
+		if pod.Metadata.Annotations[d.annotation] != "true" || pod.Status.Phase != "Running" || pod.Status.PodIP == "" {
+			continue
+		}
+		port := pod.Metadata.Annotations[portAnnotation]
+		if port == "" {
+			port = defaultPort
+		}
+		targets = append(targets, podTarget{
+			namespace: pod.Metadata.Namespace,
+			name:      pod.Metadata.Name,
+			url:       "http://" + net.JoinHostPort(pod.Status.PodIP, port),
+		})
+	}
+	return targets, nil
+}
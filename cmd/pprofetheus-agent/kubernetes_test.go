@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/travelaudience/pprofetheus"
+)
+
+func TestDiscovery(t *testing.T) {
+	block := testProfile(t, "contentions", "delay")
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/pprof/block" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(block)
+	}))
+	defer target.Close()
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const podTemplate = `{"metadata": {"name": %q, "namespace": %q, "annotations": {"pprofetheus.io/scrape": %q, "pprofetheus.io/port": %q}}, "status": {"phase": "Running", "podIP": %q}}`
+	var mu sync.Mutex
+	pods := []string{
+		fmt.Sprintf(podTemplate, "api-1", "default", "true", port, host),
+		fmt.Sprintf(podTemplate, "worker-1", "jobs", "true", port, host),
+		fmt.Sprintf(podTemplate, "db-1", "default", "false", port, host),
+	}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Authorization = %q, expected the service account token", auth)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprint(w, `{"items": [`)
+		for i, pod := range pods {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprint(w, pod)
+		}
+		fmt.Fprint(w, `]}`)
+	}))
+	defer api.Close()
+
+	reg := prometheus.NewRegistry()
+	opts := []pprofetheus.Option{pprofetheus.WithSymbols([]pprofetheus.Symbol{})}
+	d := newDiscovery(reg, api.Client(), api.URL, "secret", defaultAnnotation, "", []string{"block"}, time.Second, opts)
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := profiledPods(t, reg), []string{"default/api-1", "jobs/worker-1"}; fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("profiled pods = %v, expected %v", got, expected)
+	}
+
+	mu.Lock()
+	pods = pods[1:]
+	mu.Unlock()
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := profiledPods(t, reg), []string{"jobs/worker-1"}; fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("profiled pods after the removal of a pod = %v, expected %v", got, expected)
+	}
+}
+
+func TestDiscoveryPodIPChange(t *testing.T) {
+	block := testProfile(t, "contentions", "delay")
+	newTarget := func() (*httptest.Server, string, string) {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(block)
+		}))
+		u, err := url.Parse(target.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		host, port, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return target, host, port
+	}
+	oldTarget, oldHost, oldPort := newTarget()
+	defer oldTarget.Close()
+	recreatedTarget, newHost, newPort := newTarget()
+	defer recreatedTarget.Close()
+
+	const podTemplate = `{"items": [{"metadata": {"name": "api-1", "namespace": "default", "annotations": {"pprofetheus.io/scrape": "true", "pprofetheus.io/port": %q}}, "status": {"phase": "Running", "podIP": %q}}]}`
+	var mu sync.Mutex
+	pods := fmt.Sprintf(podTemplate, oldPort, oldHost)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprint(w, pods)
+	}))
+	defer api.Close()
+
+	reg := prometheus.NewRegistry()
+	opts := []pprofetheus.Option{pprofetheus.WithSymbols([]pprofetheus.Symbol{})}
+	d := newDiscovery(reg, api.Client(), api.URL, "", defaultAnnotation, "", []string{"block"}, time.Second, opts)
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pod is recreated with another address but the same name, so the
+	// collectors of its new target have the same labels as the old ones.
+	mu.Lock()
+	pods = fmt.Sprintf(podTemplate, newPort, newHost)
+	mu.Unlock()
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := profiledPods(t, reg), []string{"default/api-1"}; fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("profiled pods after the IP change = %v, expected %v", got, expected)
+	}
+	if len(d.collectors) != 1 {
+		t.Fatalf("got %d targets, expected 1", len(d.collectors))
+	}
+	for target := range d.collectors {
+		if expected := "http://" + net.JoinHostPort(newHost, newPort); target.url != expected {
+			t.Errorf("target URL = %s, expected %s", target.url, expected)
+		}
+	}
+}
+
+func TestDiscoveryNamespace(t *testing.T) {
+	var path string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, `{"items": []}`)
+	}))
+	defer api.Close()
+
+	d := newDiscovery(prometheus.NewRegistry(), api.Client(), api.URL, "", defaultAnnotation, "jobs", []string{"block"}, time.Second, nil)
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/api/v1/namespaces/jobs/pods" {
+		t.Errorf("listed pods at %s, expected /api/v1/namespaces/jobs/pods", path)
+	}
+}
+
+// profiledPods returns the sorted namespace/pod pairs of the block profile
+// metrics gathered from reg.
+func profiledPods(t *testing.T, reg *prometheus.Registry) []string {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pods []string
+	for _, f := range families {
+		if f.GetName() != "pprof_block_time_ms" {
+			continue
+		}
+		for _, m := range f.Metric {
+			labels := make(map[string]string)
+			for _, l := range m.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			pods = append(pods, labels["namespace"]+"/"+labels["pod"])
+		}
+	}
+	sort.Strings(pods)
+	return pods
+}
@@ -0,0 +1,125 @@
+// Command pprofetheus-agent exports the metrics of pprofetheus for another
+// process that serves net/http/pprof, e.g. as a sidecar of a service that
+// can't import the library:
+//
+//	pprofetheus-agent -target http://localhost:6060 -profiles cpu,allocs,mutex
+//
+// -labels adds constant labels to all metrics, e.g. to tell apart the
+// binaries of several services with -labels service=api,version=1.2.3.
+//
+// With -kubernetes, the agent profiles the pods in its cluster that have the
+// annotation "pprofetheus.io/scrape" set to "true" instead, on the port of the
+// annotation "pprofetheus.io/port" or 6060, and labels their metrics by pod
+// and namespace:
+//
+//	pprofetheus-agent -kubernetes -profiles cpu,mutex
+//
+// On every scrape of its /metrics endpoint, the agent fetches the profiles
+// from the target and resolves their functions using the function
+// information that the Go runtime writes into them. Fetching the CPU profile
+// takes the duration set with -duration, so the scrape timeout must exceed it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/travelaudience/pprofetheus"
+)
+
+// fetchTimeout is how long fetching a profile may take in addition to the
+// duration of the CPU profile.
+const fetchTimeout = 30 * time.Second
+
+func main() {
+	target := flag.String("target", "http://localhost:6060", "base URL of the target's net/http/pprof endpoints")
+	listen := flag.String("listen", ":9183", "address to serve the metrics on")
+	profiles := flag.String("profiles", "cpu", "comma-separated profiles to export: cpu, allocs, block, mutex and threadcreate")
+	duration := flag.Duration("duration", 10*time.Second, "duration of the CPU profile fetched on every scrape")
+	binaryPath := flag.String("binary", "", "binary of the target, to resolve profiles without function information")
+	namespace := flag.String("namespace", "pprof", "namespace of the metrics")
+	labels := flag.String("labels", "", "comma-separated constant labels of the metrics, e.g. service=api,version=1.2.3")
+	kubernetes := flag.Bool("kubernetes", false, "discover the targets among the Kubernetes pods instead of using -target")
+	annotation := flag.String("annotation", defaultAnnotation, "annotation that marks the pods to profile with -kubernetes")
+	kubernetesNamespace := flag.String("kubernetes-namespace", "", "Kubernetes namespace to discover pods in with -kubernetes, all by default")
+	refreshInterval := flag.Duration("refresh", time.Minute, "interval of updating the discovered pods")
+	flag.Parse()
+
+	constLabels, err := parseLabels(*labels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []pprofetheus.Option{
+		pprofetheus.WithNamespace(*namespace),
+		pprofetheus.WithConstLabels(constLabels),
+		pprofetheus.WithHTTPClient(&http.Client{Timeout: *duration + fetchTimeout}),
+	}
+	if *binaryPath == "" {
+		// the symbols of the agent are of no use for the target's profiles.
+		opts = append(opts, pprofetheus.WithSymbols([]pprofetheus.Symbol{}))
+	}
+
+	reg := prometheus.NewRegistry()
+	if *kubernetes {
+		d, err := newInClusterDiscovery(reg, *annotation, *kubernetesNamespace, strings.Split(*profiles, ","), *duration, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go d.run(*refreshInterval)
+	} else if _, err := registerCollectors(reg, *target, strings.Split(*profiles, ","), *duration, *binaryPath, opts); err != nil {
+		log.Fatal(err)
+	}
+
+	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+// parseLabels parses labels of the form "name=value,name=value".
+func parseLabels(s string) (prometheus.Labels, error) {
+	labels := make(prometheus.Labels)
+	if s == "" {
+		return labels, nil
+	}
+	for _, label := range strings.Split(s, ",") {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid label %q, expected name=value", label)
+		}
+		labels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return labels, nil
+}
+
+// registerCollectors registers the collectors of the profiles of the target
+// with reg and returns them.
+func registerCollectors(reg prometheus.Registerer, target string, profiles []string, duration time.Duration, binaryPath string, opts []pprofetheus.Option) ([]prometheus.Collector, error) {
+	target = strings.TrimSuffix(target, "/")
+	var collectors []prometheus.Collector
+	for _, name := range profiles {
+		var c prometheus.Collector
+		var err error
+		if name = strings.TrimSpace(name); name == "cpu" {
+			profileURL := fmt.Sprintf("%s/debug/pprof/profile?seconds=%d", target, int(duration.Seconds()))
+			c, err = pprofetheus.NewRemoteCPUProfileCollector(profileURL, binaryPath, opts...)
+		} else {
+			c, err = pprofetheus.NewRemoteProfileCollector(target+"/debug/pprof/"+name, name, opts...)
+		}
+		if err == nil {
+			err = reg.Register(c)
+		}
+		if err != nil {
+			for _, c := range collectors {
+				reg.Unregister(c)
+			}
+			return nil, fmt.Errorf("creating collector for profile %s failed: %v", name, err)
+		}
+		collectors = append(collectors, c)
+	}
+	return collectors, nil
+}
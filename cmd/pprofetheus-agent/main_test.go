@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/travelaudience/pprofetheus"
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// testProfile returns a profile with one sample of main.main, with the given
+// sample types and a value of 10ms for each.
+func testProfile(t *testing.T, sampleTypes ...string) []byte {
+	f := &profile.Function{ID: 1, Name: "main.main", SystemName: "main.main"}
+	l := &profile.Location{ID: 1, Address: 0x1000, Line: []profile.Line{{Function: f}}}
+	p := &profile.Profile{
+		Function: []*profile.Function{f},
+		Location: []*profile.Location{l},
+		Sample:   []*profile.Sample{{Location: []*profile.Location{l}}},
+	}
+	for _, typ := range sampleTypes {
+		p.SampleType = append(p.SampleType, &profile.ValueType{Type: typ, Unit: "nanoseconds"})
+		p.Sample[0].Value = append(p.Sample[0].Value, int64(10*time.Millisecond))
+	}
+
+	var data bytes.Buffer
+	if err := p.Write(&data); err != nil {
+		t.Fatal(err)
+	}
+	return data.Bytes()
+}
+
+func TestRegisterCollectors(t *testing.T) {
+	cpu := testProfile(t, "samples", "cpu")
+	block := testProfile(t, "contentions", "delay")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/debug/pprof/profile":
+			if seconds := r.URL.Query().Get("seconds"); seconds != "5" {
+				t.Errorf("CPU profile of %s seconds requested, expected 5", seconds)
+			}
+			w.Write(cpu)
+		case "/debug/pprof/block":
+			w.Write(block)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	opts := []pprofetheus.Option{pprofetheus.WithSymbols([]pprofetheus.Symbol{})}
+	reg := prometheus.NewRegistry()
+	if _, err := registerCollectors(reg, ts.URL+"/", []string{"cpu", " block"}, 5*time.Second, "", opts); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.Metric {
+			if len(m.Label) > 0 && m.Label[0].GetValue() == "main.main" {
+				values[f.GetName()] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	for _, name := range []string{"pprof_cpu_time_used_ms", "pprof_block_time_ms"} {
+		if values[name] != 10 {
+			t.Errorf("%s of main.main = %f, expected 10", name, values[name])
+		}
+	}
+}
+
+func TestRegisterCollectorsUnknownProfile(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := registerCollectors(reg, "http://localhost:6060", []string{"block", "heap"}, time.Second, "", nil); err == nil {
+		t.Error("registerCollectors succeeded with an unsupported profile")
+	}
+	// the collectors of the other profiles have been unregistered again.
+	if families, _ := reg.Gather(); len(families) != 0 {
+		t.Errorf("got %d metric families, expected none", len(families))
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels("service=api, version=1.2.3,build_id=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := prometheus.Labels{"service": "api", "version": "1.2.3", "build_id": ""}
+	if fmt.Sprint(labels) != fmt.Sprint(expected) {
+		t.Errorf("labels = %v, expected %v", labels, expected)
+	}
+
+	for _, s := range []string{"service", "=api", "service=api,"} {
+		if _, err := parseLabels(s); err == nil {
+			t.Errorf("parseLabels(%q) succeeded", s)
+		}
+	}
+}
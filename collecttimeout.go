@@ -0,0 +1,50 @@
+package pprofetheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectWithin collects the metrics in the background and sends them to ch
+// if that takes less than timeout. Otherwise, it sends the metrics of the
+// previous collection. Only one collection is in progress at a time; scrapes
+// that arrive meanwhile wait for the same one.
+func (c *cpuProfileCollector) collectWithin(timeout time.Duration, ch chan<- prometheus.Metric) {
+	c.lastMetricsLock.Lock()
+	if c.pendingCollection == nil {
+		done := make(chan struct{})
+		c.pendingCollection = done
+		go func() {
+			c.Lock()
+			metrics := snapshotMetrics(c.collectCached)
+			c.Unlock()
+
+			c.lastMetricsLock.Lock()
+			c.lastMetrics = metrics
+			c.pendingCollection = nil
+			c.lastMetricsLock.Unlock()
+			close(done)
+		}()
+	}
+	done := c.pendingCollection
+	c.lastMetricsLock.Unlock()
+
+	t := c.opts.clock.NewTicker(timeout)
+	select {
+	case <-done:
+	case <-t.Chan():
+		c.collectionsTimedOut.Inc()
+		c.opts.log(LevelWarn, "collecting metrics timed out, reporting the previous values", "timeout", timeout)
+	}
+	t.Stop()
+
+	c.lastMetricsLock.Lock()
+	metrics := c.lastMetrics
+	c.lastMetricsLock.Unlock()
+
+	for _, m := range metrics {
+		ch <- m
+	}
+	c.collectionsTimedOut.Collect(ch)
+}
@@ -0,0 +1,61 @@
+package pprofetheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCPUProfileCollectorCollectTimeout(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	clk := &fakeClock{now: time.Unix(0, 0), ticker: newFakeTicker()}
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), clk, WithCollectTimeout(time.Second))
+	c.Start()
+
+	timeUsed := func(metrics []prometheus.Metric) float64 {
+		for _, m := range metrics {
+			if fn, _ := functionLabel(t, m); fn == "main.main" && strings.Contains(m.Desc().String(), `"pprof_cpu_time_used_ms"`) {
+				return counterValue(t, m)
+			}
+		}
+		return 0
+	}
+
+	if value := timeUsed(collectMetrics(c)); value != 10 {
+		t.Errorf("time used = %f, expected 10", value)
+	}
+
+	// the profile can't be read in time, so the previous values are
+	// reported.
+	prof.block = make(chan struct{})
+	result := make(chan []prometheus.Metric)
+	go func() {
+		result <- collectMetrics(c)
+	}()
+	clk.ticker.c <- time.Time{}
+	if value := timeUsed(<-result); value != 10 {
+		t.Errorf("time used = %f after timeout, expected the previous 10", value)
+	}
+	if value := counterValue(t, c.collectionsTimedOut); value != 1 {
+		t.Errorf("timed out collections = %f, expected 1", value)
+	}
+
+	// the collection that timed out goes on, and the next scrape reports
+	// its values as well.
+	close(prof.block)
+	waitFor(t, func() bool {
+		c.lastMetricsLock.Lock()
+		defer c.lastMetricsLock.Unlock()
+		return c.pendingCollection == nil
+	})
+	if value := timeUsed(collectMetrics(c)); value != 30 {
+		t.Errorf("time used = %f, expected 30", value)
+	}
+
+	c.Stop()
+}
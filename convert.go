@@ -0,0 +1,41 @@
+package pprofetheus
+
+import (
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ProfileToMetrics converts the CPU profile in pprof format read from r to the
+// metric families that a collector created with the options opts would export
+// after ingesting it, e.g. to test the CPU usage of code that is profiled by
+// the test itself, or in batch tools that process captured profiles. Locations
+// are resolved like with Ingest, using the symbols set with the options where
+// the profile lacks function information. No profiler is started.
+func ProfileToMetrics(r io.Reader, opts ...Option) ([]*dto.MetricFamily, error) {
+	o := newOptions(opts)
+	symbolizer, err := newSymbolizer(o)
+	if err != nil {
+		return nil, err
+	}
+	c := newCPUProfileCollector(symbolizer, o)
+	if err := c.Ingest(r); err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return gatherMetrics(snapshotMetrics(c.collect))
+}
+
+// gatherMetrics gathers the collected metrics into metric families, sorted by
+// name.
+func gatherMetrics(metrics []prometheus.Metric) ([]*dto.MetricFamily, error) {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(metricList(metrics)); err != nil {
+		return nil, err
+	}
+	return reg.Gather()
+}
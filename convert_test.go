@@ -0,0 +1,43 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProfileToMetrics(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	var data bytes.Buffer
+	if err := testProfile(t, symbols, []string{"main.compute", "main.main"}, []string{"main.main"}).Write(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := ProfileToMetrics(&data, WithSymbols(symbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]float64)
+	for _, f := range families {
+		if f.GetName() != "pprof_cpu_time_used_ms" {
+			continue
+		}
+		for _, m := range f.Metric {
+			values[m.Label[0].GetValue()] = m.GetCounter().GetValue()
+		}
+	}
+	if values["main.main"] != 10 || values["main.compute"] != 10 {
+		t.Errorf("time used = %v, expected 10 for main.main and main.compute", values)
+	}
+}
+
+func TestProfileToMetricsInvalid(t *testing.T) {
+	if _, err := ProfileToMetrics(strings.NewReader("garbage")); err == nil {
+		t.Error("ProfileToMetrics succeeded with invalid data")
+	}
+}
@@ -0,0 +1,182 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+)
+
+// defaultDebugDir is the directory that separate debug files are installed to
+// by most Linux distributions.
+const defaultDebugDir = "/usr/lib/debug"
+
+// findDebugFile returns the path of the separate debug file of the stripped
+// ELF binary at path. Like gdb, it looks up the GNU build ID of the binary in
+// debugDir/.build-id and the file named in its .gnu_debuglink section next to
+// the binary, in its .debug subdirectory and below debugDir. It returns false
+// if there is no debug file.
+func findDebugFile(path, debugDir string) (string, bool) {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	if id, ok := buildID(f); ok && len(id) > 1 {
+		h := hex.EncodeToString(id)
+		candidate := filepath.Join(debugDir, ".build-id", h[:2], h[2:]+".debug")
+		if hasBuildID(candidate, id) {
+			return candidate, true
+		}
+	}
+
+	if name, crc, ok := debugLink(f); ok {
+		dir := filepath.Dir(path)
+		for _, candidate := range []string{
+			filepath.Join(dir, name),
+			filepath.Join(dir, ".debug", name),
+			filepath.Join(debugDir, dir, name),
+		} {
+			if candidate != path && hasCRC(candidate, crc) {
+				return candidate, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// lookupDebugFile returns the path of the separate debug file of the stripped
+// ELF binary at path, which is either installed locally or fetched from the
+// configured debuginfod server.
+func lookupDebugFile(o *options, path string) (string, bool) {
+	if debugPath, ok := findDebugFile(path, o.debugDir); ok {
+		return debugPath, true
+	}
+	if o.debuginfodURL == "" {
+		return "", false
+	}
+
+	client := o.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultDebuginfodTimeout}
+	}
+	cacheDir := o.debuginfodCacheDir
+	if cacheDir == "" {
+		cacheDir = defaultDebuginfodCacheDir()
+	}
+	debugPath, err := fetchDebugFile(client, o.debuginfodURL, cacheDir, path)
+	if err != nil {
+		o.log(LevelWarn, "fetching debug file from debuginfod failed", "path", path, "url", o.debuginfodURL, "err", err)
+		return "", false
+	}
+	return debugPath, true
+}
+
+// buildID returns the GNU build ID of the ELF file f.
+func buildID(f *elf.File) ([]byte, bool) {
+	sect := f.Section(".note.gnu.build-id")
+	if sect == nil {
+		return nil, false
+	}
+	data, err := sect.Data()
+	if err != nil {
+		return nil, false
+	}
+	return parseBuildIDNote(data, f.ByteOrder)
+}
+
+// Types of the ELF notes containing build IDs.
+const (
+	ntGNUBuildID = 3
+	ntGoBuildID  = 4
+)
+
+// parseBuildIDNote returns the build ID contained in the ELF note data.
+func parseBuildIDNote(data []byte, order binary.ByteOrder) ([]byte, bool) {
+	return parseNote(data, order, "GNU\x00", ntGNUBuildID)
+}
+
+// parseNote returns the descriptor of the ELF note with the name and the type
+// typ contained in data.
+func parseNote(data []byte, order binary.ByteOrder, name string, typ uint32) ([]byte, bool) {
+	// a note consists of the sizes of its name and descriptor, its type and
+	// the name and the descriptor, both padded to 4 bytes.
+	for len(data) >= 12 {
+		nameSize := int(order.Uint32(data[0:4]))
+		descSize := int(order.Uint32(data[4:8]))
+		noteType := order.Uint32(data[8:12])
+		data = data[12:]
+
+		nameEnd := (nameSize + 3) &^ 3
+		descEnd := nameEnd + (descSize+3)&^3
+		if nameSize < 0 || descSize < 0 || descEnd > len(data) {
+			return nil, false
+		}
+		if noteType == typ && string(data[:nameSize]) == name {
+			return data[nameEnd : nameEnd+descSize], true
+		}
+		data = data[descEnd:]
+	}
+	return nil, false
+}
+
+// debugLink returns the name and the checksum of the debug file from the
+// .gnu_debuglink section of the ELF file f.
+func debugLink(f *elf.File) (string, uint32, bool) {
+	sect := f.Section(".gnu_debuglink")
+	if sect == nil {
+		return "", 0, false
+	}
+	data, err := sect.Data()
+	if err != nil {
+		return "", 0, false
+	}
+	return parseDebugLink(data, f.ByteOrder)
+}
+
+// parseDebugLink returns the name and the checksum contained in the data of a
+// .gnu_debuglink section, which is the NUL-terminated name padded to 4 bytes
+// followed by the CRC-32 of the debug file.
+func parseDebugLink(data []byte, order binary.ByteOrder) (string, uint32, bool) {
+	n := bytes.IndexByte(data, 0)
+	if n <= 0 {
+		return "", 0, false
+	}
+	crcStart := (n + 1 + 3) &^ 3
+	if crcStart+4 > len(data) {
+		return "", 0, false
+	}
+	return string(data[:n]), order.Uint32(data[crcStart : crcStart+4]), true
+}
+
+// hasBuildID returns true if the ELF file at path has the GNU build ID id.
+func hasBuildID(path string, id []byte) bool {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	fileID, ok := buildID(f)
+	return ok && bytes.Equal(fileID, id)
+}
+
+// hasCRC returns true if the CRC-32 of the contents of the file at path is
+// crc.
+func hasCRC(path string, crc uint32) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return crc32.ChecksumIEEE(data) == crc
+}
@@ -0,0 +1,107 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseBuildIDNote(t *testing.T) {
+	var note bytes.Buffer
+	for _, v := range []uint32{4, 5, 3} {
+		binary.Write(&note, binary.LittleEndian, v)
+	}
+	note.WriteString("GNU\x00")
+	note.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0, 0, 0})
+
+	id, ok := parseBuildIDNote(note.Bytes(), binary.LittleEndian)
+	if !ok || !bytes.Equal(id, []byte{0xde, 0xad, 0xbe, 0xef, 0x01}) {
+		t.Errorf("parseBuildIDNote() = %x, %t", id, ok)
+	}
+
+	if _, ok := parseBuildIDNote(note.Bytes()[:20], binary.LittleEndian); ok {
+		t.Error("expected truncated note to be rejected")
+	}
+}
+
+func TestParseDebugLink(t *testing.T) {
+	data := []byte("app.debug\x00\x00\x00\x78\x56\x34\x12")
+
+	name, crc, ok := parseDebugLink(data, binary.LittleEndian)
+	if !ok || name != "app.debug" || crc != 0x12345678 {
+		t.Errorf("parseDebugLink() = %q, %#x, %t", name, crc, ok)
+	}
+
+	if _, _, ok := parseDebugLink(data[:12], binary.LittleEndian); ok {
+		t.Error("expected truncated debug link to be rejected")
+	}
+}
+
+func TestFindDebugFileBuildID(t *testing.T) {
+	paths, _ := filepath.Glob("/lib*/*-linux-gnu/libc.so.6")
+	if len(paths) == 0 {
+		t.Skip("libc not found")
+	}
+	path := paths[0]
+
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := buildID(f)
+	f.Close()
+	if !ok {
+		t.Skip("libc has no build ID")
+	}
+
+	debugDir, err := ioutil.TempDir("", "pprofetheus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(debugDir)
+
+	if _, ok := findDebugFile(path, debugDir); ok {
+		t.Fatal("found debug file in empty directory")
+	}
+
+	// any file with the same build ID stands in for the debug file.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	debugFile := filepath.Join(debugDir, ".build-id", hex.EncodeToString(id[:1]), hex.EncodeToString(id[1:])+".debug")
+	if err := os.MkdirAll(filepath.Dir(debugFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(debugFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := findDebugFile(path, debugDir); !ok || got != debugFile {
+		t.Errorf("findDebugFile() = %q, %t, expected %q", got, ok, debugFile)
+	}
+}
+
+func TestHasCRC(t *testing.T) {
+	f, err := ioutil.TempFile("", "pprofetheus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	data := []byte("debug info")
+	f.Write(data)
+	f.Close()
+
+	if !hasCRC(f.Name(), crc32.ChecksumIEEE(data)) {
+		t.Error("expected CRC to match")
+	}
+	if hasCRC(f.Name(), crc32.ChecksumIEEE(data)+1) {
+		t.Error("expected CRC not to match")
+	}
+}
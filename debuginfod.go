@@ -0,0 +1,85 @@
+package pprofetheus
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultDebuginfodTimeout is the timeout for downloading a debug file from a
+// debuginfod server. Debug files of large binaries can be hundreds of
+// megabytes.
+const defaultDebuginfodTimeout = 5 * time.Minute
+
+// fetchDebugFile returns the path of the debug file of the ELF binary at path
+// that has been downloaded by its GNU build ID from the debuginfod server at
+// serverURL to cacheDir. Debug files that have been downloaded before are
+// reused.
+func fetchDebugFile(client *http.Client, serverURL, cacheDir, path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	id, ok := buildID(f)
+	f.Close()
+	if !ok {
+		return "", fmt.Errorf("%s has no build ID", path)
+	}
+
+	h := hex.EncodeToString(id)
+	debugPath := filepath.Join(cacheDir, h, "debuginfo")
+	if hasBuildID(debugPath, id) {
+		return debugPath, nil
+	}
+
+	resp, err := client.Get(strings.TrimSuffix(serverURL, "/") + "/buildid/" + h + "/debuginfo")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching debug file for build ID %s: unexpected status %s", h, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(debugPath), 0755); err != nil {
+		return "", err
+	}
+	// the download is written to a temporary file first, so that concurrent
+	// readers never see a partial debug file.
+	tmp, err := ioutil.TempFile(filepath.Dir(debugPath), "debuginfo")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if !hasBuildID(tmp.Name(), id) {
+		return "", fmt.Errorf("fetched debug file for build ID %s has a different build ID", h)
+	}
+	if err := os.Rename(tmp.Name(), debugPath); err != nil {
+		return "", err
+	}
+	return debugPath, nil
+}
+
+// defaultDebuginfodCacheDir returns the directory that debug files downloaded
+// from a debuginfod server are cached in by default.
+func defaultDebuginfodCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "pprofetheus", "debuginfod")
+}
@@ -0,0 +1,85 @@
+package pprofetheus
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchDebugFile(t *testing.T) {
+	paths, _ := filepath.Glob("/lib*/*-linux-gnu/libc.so.6")
+	if len(paths) == 0 {
+		t.Skip("libc not found")
+	}
+	path := paths[0]
+
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := buildID(f)
+	f.Close()
+	if !ok {
+		t.Skip("libc has no build ID")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// any file with the same build ID stands in for the debug file.
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/buildid/"+hex.EncodeToString(id)+"/debuginfo" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	cacheDir, err := ioutil.TempDir("", "pprofetheus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	for i := 0; i < 2; i++ {
+		debugPath, err := fetchDebugFile(srv.Client(), srv.URL+"/", cacheDir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasBuildID(debugPath, id) {
+			t.Errorf("debug file %s doesn't have build ID %x", debugPath, id)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, expected the debug file to be cached after 1", requests)
+	}
+}
+
+func TestFetchDebugFileNotFound(t *testing.T) {
+	paths, _ := filepath.Glob("/lib*/*-linux-gnu/libc.so.6")
+	if len(paths) == 0 {
+		t.Skip("libc not found")
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	cacheDir, err := ioutil.TempDir("", "pprofetheus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	if _, err := fetchDebugFile(srv.Client(), srv.URL, cacheDir, paths[0]); err == nil {
+		t.Error("expected error for missing debug file")
+	}
+}
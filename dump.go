@@ -0,0 +1,122 @@
+package pprofetheus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// addToDump adds the profile p to the profile that will be written with the
+// next dump.
+func (c *cpuProfileCollector) addToDump(p *profile.Profile) {
+	symbolize(p, c.symbolCache)
+
+	if c.dump == nil {
+		c.dump = p
+		return
+	}
+	if err := c.dump.Merge(p, 1); err != nil {
+		c.dumpErrors.Inc()
+		c.opts.log(LevelWarn, "merging profile into dump failed", "err", err)
+		c.dump = p
+	}
+}
+
+// writeDump writes the profile data collected since the last dump to the
+// dump directory.
+func (c *cpuProfileCollector) writeDump() {
+	if c.dump == nil {
+		return
+	}
+	p := c.dump
+	c.dump = nil
+
+	if err := writeProfileFile(c.opts.dumpDir, c.opts.clock.Now(), p); err != nil {
+		c.dumpErrors.Inc()
+		c.opts.log(LevelError, "writing profile dump failed", "dir", c.opts.dumpDir, "err", err)
+		return
+	}
+	c.dumps.Inc()
+}
+
+// writeProfileFile writes the profile p to a file in dir that is named after
+// the time t. If such a file already exists, a numeric suffix is added to the
+// file name.
+func writeProfileFile(dir string, t time.Time, p *profile.Profile) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	name := profileName(t)
+
+	for i := 0; ; i++ {
+		path := filepath.Join(dir, name+".pprof")
+		if i > 0 {
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d.pprof", name, i))
+		}
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = p.Write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+		return err
+	}
+}
+
+// profileName returns the name of a profile that has been read at time t,
+// without extension.
+func profileName(t time.Time) string {
+	return "cpu-" + t.UTC().Format("20060102T150405Z")
+}
+
+// symbolize adds function information to all locations of the profile p that
+// don't have any yet and that can be resolved using symbolizer, including the
+// functions inlined at them if it is a FrameSymbolizer.
+func symbolize(p *profile.Profile, symbolizer Symbolizer) {
+	functions := make(map[string]*profile.Function)
+	for _, f := range p.Function {
+		functions[f.Name] = f
+	}
+
+	for _, l := range p.Location {
+		if len(l.Line) > 0 {
+			continue
+		}
+
+		names, ok := resolveFrames(symbolizer, l.Address)
+		if !ok {
+			continue
+		}
+
+		for _, name := range names {
+			f, ok := functions[name]
+			if !ok {
+				f = &profile.Function{
+					ID:         uint64(len(p.Function) + 1),
+					Name:       name,
+					SystemName: name,
+				}
+				functions[name] = f
+				p.Function = append(p.Function, f)
+			}
+			l.Line = append(l.Line, profile.Line{Function: f})
+		}
+		if l.Mapping != nil && len(l.Line) > 0 {
+			l.Mapping.HasFunctions = true
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pprofetheus
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func TestCPUProfileCollectorProfileDump(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "pprofetheus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := filepath.Join(tmpDir, "profiles")
+
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	clk := &fakeClock{now: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC), ticker: newFakeTicker()}
+	c, _ := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.compute", "main.main"}), clk, WithProfileDump(dir, time.Minute))
+
+	c.Start()
+	for i := 1; i <= 3; i++ {
+		clk.ticker.c <- time.Time{}
+		waitForFiles(t, dir, i)
+	}
+	c.Stop()
+
+	files := waitForFiles(t, dir, 4)
+
+	// all dumps happened at the same time, so their file names collided.
+	expectedFiles := []string{
+		"cpu-20170601T120000Z.pprof",
+		"cpu-20170601T120000Z-1.pprof",
+		"cpu-20170601T120000Z-2.pprof",
+		"cpu-20170601T120000Z-3.pprof",
+	}
+	for idx, name := range expectedFiles {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%d. %v", idx, err)
+			continue
+		}
+		p, err := profile.Parse(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%d. parsing %s failed: %v", idx, name, err)
+			continue
+		}
+
+		functions := map[string]bool{}
+		for _, fn := range p.Function {
+			functions[fn.Name] = true
+		}
+		if !functions["main.main"] || !functions["main.compute"] {
+			t.Errorf("%d. %s is not symbolized: %v", idx, name, p.Function)
+		}
+	}
+
+	if len(files) != len(expectedFiles) {
+		t.Errorf("expected %d files, got %v", len(expectedFiles), files)
+	}
+
+	if value := counterValue(t, c.dumps); value != 4 {
+		t.Errorf("profile dumps = %f, expected 4", value)
+	}
+	if value := counterValue(t, c.dumpErrors); value != 0 {
+		t.Errorf("profile dump errors = %f, expected 0", value)
+	}
+}
+
+func TestCPUProfileCollectorProfileDumpError(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "pprofetheus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	// the dump directory can't be created because a file is in the way.
+	c, _ := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Unix(0, 0)}, WithProfileDump(filepath.Join(tmpFile.Name(), "profiles"), time.Minute))
+
+	c.Start()
+	c.Stop()
+
+	if value := counterValue(t, c.dumpErrors); value != 1 {
+		t.Errorf("profile dump errors = %f, expected 1", value)
+	}
+}
+
+// waitForFiles waits until dir contains at least n files and returns their names.
+func waitForFiles(t *testing.T, dir string, n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.pprof"))
+		if len(files) >= n {
+			return files
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d files in %s, got %v", n, dir, files)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
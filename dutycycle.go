@@ -0,0 +1,54 @@
+package pprofetheus
+
+import "time"
+
+// runDutyCycle alternately lets the profiler run for the profiling window of
+// the duty cycle and pauses it for the rest of the cycle, until stop is
+// closed. The metrics accumulate across windows.
+func (c *cpuProfileCollector) runDutyCycle(stop chan struct{}) {
+	for {
+		if !c.afterTick(c.opts.dutyCycleOn, stop, c.pause) {
+			return
+		}
+		if !c.afterTick(c.opts.dutyCycleEvery-c.opts.dutyCycleOn, stop, c.resume) {
+			return
+		}
+	}
+}
+
+// afterTick waits for d and then calls f with the collector locked. It returns
+// false without calling f if stop is closed in the meantime.
+func (c *cpuProfileCollector) afterTick(d time.Duration, stop chan struct{}, f func()) bool {
+	t := c.opts.clock.NewTicker(d)
+	defer t.Stop()
+
+	select {
+	case <-stop:
+		return false
+	case <-t.Chan():
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	f()
+	return true
+}
+
+// pause stops the profiler at the end of a profiling window and adds the
+// profile data recorded in it to the collector's metrics.
+func (c *cpuProfileCollector) pause() {
+	c.readProfile()
+	c.paused = true
+}
+
+// resume restarts the profiler at the start of a profiling window.
+func (c *cpuProfileCollector) resume() {
+	c.paused = false
+	c.startProfiler()
+	c.lastDrain = c.opts.clock.Now()
+}
@@ -0,0 +1,51 @@
+package pprofetheus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCPUProfileCollectorDutyCycle(t *testing.T) {
+	if _, err := NewCPUProfileCollector(WithSymbols([]Symbol{}), WithDutyCycle(time.Minute, time.Minute)); err == nil {
+		t.Errorf("duty cycle without pause was accepted")
+	}
+
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	clk := &fakeClock{now: time.Unix(0, 0), ticker: newFakeTicker()}
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), clk, WithDutyCycle(10*time.Second, 2*time.Minute))
+
+	running := func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return prof.running
+	}
+
+	c.Start()
+	for i := 1; i <= 2; i++ {
+		// end of the profiling window.
+		clk.ticker.c <- time.Time{}
+		waitFor(t, func() bool { return !running() })
+
+		// scrapes during the pause report the accumulated time without
+		// touching the profiler.
+		c.Flush()
+		if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != float64(i*10) {
+			t.Errorf("%d. time used by main.main = %f, expected %d", i, value, i*10)
+		}
+		if running() {
+			t.Errorf("%d. Flush restarted the paused profiler", i)
+		}
+
+		// start of the next profiling window.
+		clk.ticker.c <- time.Time{}
+		waitFor(t, running)
+	}
+
+	c.Stop()
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != 30 {
+		t.Errorf("time used by main.main = %f after Stop, expected 30", value)
+	}
+}
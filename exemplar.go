@@ -0,0 +1,52 @@
+package pprofetheus
+
+import (
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// defaultExemplarKeys are the keys of the profiler labels that exemplars are
+// taken from if WithExemplars is used without keys.
+var defaultExemplarKeys = []string{"trace_id", "span_id"}
+
+// WithExemplars attaches exemplars to the time metrics of the functions that
+// carry the values of the profiler labels keys, "trace_id" and "span_id" by
+// default, of one of the samples accounted to the series in a profile. The
+// program has to set the labels with runtime/pprof.Do, e.g. in its tracing
+// middleware, which makes it possible to go from the CPU time of a function to
+// a trace that spent it. Samples without any of the keys don't carry
+// exemplars. Exemplars are only exposed in the OpenMetrics format, which the
+// metrics handler has to enable with promhttp.HandlerOpts.EnableOpenMetrics.
+func WithExemplars(keys ...string) Option {
+	return func(o *options) {
+		if len(keys) == 0 {
+			keys = defaultExemplarKeys
+		}
+		o.exemplarKeys = keys
+	}
+}
+
+// exemplarLabels returns the labels of the exemplar for the sample s, or nil if
+// the sample has none of the exemplar keys or its labels are too long for an
+// exemplar.
+func (o *options) exemplarLabels(s *profile.Sample) prometheus.Labels {
+	var labels prometheus.Labels
+	var runes int
+	for _, key := range o.exemplarKeys {
+		values := s.Label[key]
+		if len(values) == 0 || values[0] == "" {
+			continue
+		}
+		if labels == nil {
+			labels = make(prometheus.Labels)
+		}
+		labels[key] = values[0]
+		runes += utf8.RuneCountInString(key) + utf8.RuneCountInString(values[0])
+	}
+	if runes > prometheus.ExemplarMaxRunes {
+		return nil
+	}
+	return labels
+}
@@ -0,0 +1,61 @@
+package pprofetheus
+
+import (
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func TestCPUProfileCollectorExemplars(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.handle", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithExemplars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	p := testProfile(t, symbols, []string{"main.handle", "main.main"}, []string{"main.main"})
+	p.Sample[0].Label = map[string][]string{"trace_id": {"4bf92f3577b34da6a3ce929d0e0e4736"}, "span_id": {"00f067aa0ba902b7"}}
+	c.addProfile(p)
+
+	exemplars := make(map[string]*dto.Exemplar)
+	for _, m := range collectMetrics(c.timeUsed) {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		exemplars[metric.Label[0].GetValue()] = metric.GetCounter().GetExemplar()
+	}
+
+	e := exemplars["main.handle"]
+	if e == nil {
+		t.Fatal("main.handle has no exemplar")
+	}
+	if e.GetValue() != 10 {
+		t.Errorf("exemplar value = %f, expected 10", e.GetValue())
+	}
+	labels := make(map[string]string)
+	for _, l := range e.Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if labels["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || labels["span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("exemplar labels = %v", labels)
+	}
+	if e := exemplars["main.main"]; e != nil {
+		t.Errorf("main.main has exemplar %v, expected none", e)
+	}
+}
+
+func TestExemplarLabelsTooLong(t *testing.T) {
+	o := newOptions([]Option{WithExemplars("trace_id")})
+	s := &profile.Sample{Label: map[string][]string{"trace_id": {strings.Repeat("a", 200)}}}
+	if labels := o.exemplarLabels(s); labels != nil {
+		t.Errorf("exemplar labels = %v, expected none", labels)
+	}
+}
@@ -0,0 +1,35 @@
+package pprofetheus
+
+import (
+	"time"
+)
+
+// WithProfileExporter makes the collector call export with every profile that
+// it reads, in addition to exporting the metrics, e.g. to convert it for a
+// profiling backend that pprofetheus doesn't push to itself. OTLP endpoints are
+// supported by WithOTLPProfiles. RecordedProfile.Data is in
+// pprof format and must not be modified. export is called with the collector's
+// lock held, so it must hand the profile off quickly, e.g. to a channel, and
+// must not scrape the collector.
+func WithProfileExporter(export func(p RecordedProfile)) Option {
+	return func(o *options) {
+		o.exporters = append(o.exporters, export)
+	}
+}
+
+// exportProfile passes the raw profile data, read at time t and covering
+// duration, to the exporters set with WithProfileExporter.
+func (c *cpuProfileCollector) exportProfile(t time.Time, duration time.Duration, data []byte) {
+	if len(c.opts.exporters) == 0 {
+		return
+	}
+	p := RecordedProfile{
+		Name:     profileName(t) + ".pprof",
+		Time:     t,
+		Duration: duration,
+		Data:     data,
+	}
+	for _, export := range c.opts.exporters {
+		export(p)
+	}
+}
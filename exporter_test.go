@@ -0,0 +1,29 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCPUProfileCollectorProfileExporter(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	var exported []RecordedProfile
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)}, WithProfileExporter(func(p RecordedProfile) {
+		exported = append(exported, p)
+	}))
+
+	c.Start()
+	c.Flush()
+	c.Stop()
+
+	if len(exported) != 2 {
+		t.Fatalf("got %d exported profiles, expected 2", len(exported))
+	}
+	if p := exported[0]; p.Name != "cpu-20170601T120000Z.pprof" || !bytes.Equal(p.Data, prof.data) {
+		t.Errorf("unexpected exported profile %s with %d bytes", p.Name, len(p.Data))
+	}
+}
@@ -0,0 +1,240 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// flameNode is a function in the call tree of a flame graph, with the CPU
+// time in milliseconds spent in it and its callees.
+type flameNode struct {
+	Name     string       `json:"name"`
+	Value    float64      `json:"value"`
+	Children []*flameNode `json:"children,omitempty"`
+	children map[string]*flameNode
+}
+
+// child returns the callee name of the node, adding it if necessary.
+func (n *flameNode) child(name string) *flameNode {
+	if c, ok := n.children[name]; ok {
+		return c
+	}
+	if n.children == nil {
+		n.children = make(map[string]*flameNode)
+	}
+	c := &flameNode{Name: name}
+	n.children[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// sort orders the callees of the node and their callees by name.
+func (n *flameNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// addProfile adds the samples of the CPU profile p to the call tree below the
+// root node n.
+func (n *flameNode) addProfile(p *profile.Profile) {
+	forEachStack(p, func(stack []stackFrame, value float64) {
+		n.Value += value
+		node := n
+		for _, f := range stack {
+			node = node.child(f.name)
+			node.Value += value
+		}
+	})
+}
+
+// stackFrame is a function on the stack of a sample.
+type stackFrame struct {
+	name string
+	file string
+	line int64
+}
+
+// forEachStack calls f with the stack of each sample of the CPU profile p,
+// outermost function first, and its CPU time in milliseconds. Locations
+// without function information are named after their address.
+func forEachStack(p *profile.Profile, f func(stack []stackFrame, value float64)) {
+	idx, ok := valueIndex(p, cpuSampleType)
+	if !ok {
+		idx = defaultCPUValueIndex
+	}
+	divisor := float64(nanoToMilliDivisor)
+	if idx < len(p.SampleType) && p.SampleType[idx] != nil {
+		if d, ok := unitDivisors[p.SampleType[idx].Unit]; ok {
+			divisor = d
+		}
+	}
+
+	var stack []stackFrame
+	for _, s := range p.Sample {
+		if len(s.Value) <= idx {
+			continue
+		}
+
+		// the sample's stack starts at the innermost function, and so do
+		// the lines of a location with inlined functions.
+		stack = stack[:0]
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			l := s.Location[i]
+			address := stackFrame{name: fmt.Sprintf("0x%x", l.Address)}
+			if len(l.Line) == 0 {
+				stack = append(stack, address)
+				continue
+			}
+			for j := len(l.Line) - 1; j >= 0; j-- {
+				fn := l.Line[j].Function
+				if fn == nil {
+					stack = append(stack, address)
+					continue
+				}
+				stack = append(stack, stackFrame{name: fn.Name, file: fn.Filename, line: l.Line[j].Line})
+			}
+		}
+		f(stack, float64(s.Value[idx])/divisor)
+	}
+}
+
+// parseProfiles parses the profiles kept by the collector c, or only the one
+// named name unless it is empty. Profiles that can't be parsed are skipped.
+func parseProfiles(c ProfileCollector, name string) []*profile.Profile {
+	var profiles []*profile.Profile
+	for _, rp := range keptProfiles(c, name) {
+		p, err := profile.ParseData(rp.Data)
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles
+}
+
+// keptProfiles returns the profiles kept by the collector c, or only the one
+// named name unless it is empty.
+func keptProfiles(c ProfileCollector, name string) []RecordedProfile {
+	var profiles []RecordedProfile
+	for _, p := range c.Profiles() {
+		if name == "" || p.Name == name {
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles
+}
+
+// FlameGraphHandler returns an http.Handler that renders the profiles kept by
+// the collector c with WithProfileHistory as an interactive flame graph, for
+// a quick look at where the CPU time goes without any other tools:
+//
+//	http.Handle("/debug/pprofetheus/flame", pprofetheus.FlameGraphHandler(cpuProfileCollector))
+//
+// By default, the flame graph covers all kept profiles. The parameter
+// "profile" restricts it to the profile of that name, as listed by
+// ProfileHistoryHandler. Clicking a function zooms into it.
+func FlameGraphHandler(c ProfileCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		profiles := parseProfiles(c, r.FormValue("profile"))
+		if len(profiles) == 0 {
+			http.Error(w, "no CPU profiles recorded", http.StatusNotFound)
+			return
+		}
+		root := &flameNode{Name: "all"}
+		for _, p := range profiles {
+			root.addProfile(p)
+		}
+		root.sort()
+
+		var page bytes.Buffer
+		if err := flameGraphTemplate.Execute(&page, root); err != nil {
+			http.Error(w, fmt.Sprintf("rendering flame graph failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page.Bytes())
+	})
+}
+
+var flameGraphTemplate = template.Must(template.New("flamegraph").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CPU flame graph</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+#flame { position: relative; }
+.frame { position: absolute; height: 17px; overflow: hidden; white-space: nowrap; box-sizing: border-box; border: 1px solid #fff; font-size: 12px; line-height: 15px; padding: 0 2px; cursor: pointer; }
+</style>
+</head>
+<body>
+<p><button id="reset">Reset zoom</button> <span id="info"></span></p>
+<div id="flame"></div>
+<script>
+var root = {{.}};
+var flame = document.getElementById("flame");
+var info = document.getElementById("info");
+
+function color(name) {
+	var h = 0;
+	for (var i = 0; i < name.length; i++) {
+		h = (h * 31 + name.charCodeAt(i)) % 360;
+	}
+	return "hsl(" + (h % 60) + ", 80%, " + (55 + h % 20) + "%)";
+}
+
+function describe(n) {
+	return n.name + ": " + n.value.toFixed(1) + " ms (" + (100 * n.value / root.value).toFixed(2) + "%)";
+}
+
+function render(focus) {
+	flame.textContent = "";
+	var depth = 0;
+	function draw(n, d, left, width) {
+		if (width < 0.05) {
+			return;
+		}
+		depth = Math.max(depth, d + 1);
+		var div = document.createElement("div");
+		div.className = "frame";
+		div.style.left = left + "%";
+		div.style.width = width + "%";
+		div.style.top = (d * 18) + "px";
+		div.style.background = color(n.name);
+		div.textContent = n.name;
+		div.title = describe(n);
+		div.onmouseover = function() { info.textContent = describe(n); };
+		div.onclick = function() { render(n); };
+		flame.appendChild(div);
+		var x = left;
+		(n.children || []).forEach(function(c) {
+			var w = n.value > 0 ? width * c.value / n.value : 0;
+			draw(c, d + 1, x, w);
+			x += w;
+		});
+	}
+	draw(focus, 0, 0, 100);
+	flame.style.height = (depth * 18) + "px";
+	info.textContent = describe(focus);
+}
+
+document.getElementById("reset").onclick = function() { render(root); };
+render(root);
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,81 @@
+package pprofetheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlameNodeAddProfile(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.handle", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.walk", Addr: 0x1200, Size: 0x100, Code: 'T'},
+	}
+
+	p := testProfile(t, symbols,
+		[]string{"main.walk", "main.handle", "main.main"},
+		[]string{"main.handle", "main.main"},
+		[]string{"main.main"},
+	)
+	symbolize(p, newSymbolTable(symbols))
+
+	root := &flameNode{Name: "all"}
+	root.addProfile(p)
+	root.sort()
+
+	if root.Value != 30 {
+		t.Errorf("total time = %f, expected 30", root.Value)
+	}
+	if len(root.Children) != 1 || root.Children[0].Name != "main.main" || root.Children[0].Value != 30 {
+		t.Fatalf("unexpected children of root: %+v", root.Children)
+	}
+	handle := root.Children[0].Children
+	if len(handle) != 1 || handle[0].Name != "main.handle" || handle[0].Value != 20 {
+		t.Fatalf("unexpected children of main.main: %+v", handle)
+	}
+	walk := handle[0].Children
+	if len(walk) != 1 || walk[0].Name != "main.walk" || walk[0].Value != 10 {
+		t.Fatalf("unexpected children of main.handle: %+v", walk)
+	}
+}
+
+func TestFlameGraphHandler(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	// profiles of the runtime contain function names already.
+	p := testProfile(t, symbols, []string{"main.main"})
+	symbolize(p, newSymbolTable(symbols))
+
+	c, _ := newFakeCollector(t, symbols, p, &fakeClock{now: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)}, WithProfileHistory(10, 0))
+	h := FlameGraphHandler(c)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("without profiles: status %d, expected %d", w.Code, http.StatusNotFound)
+	}
+
+	c.Start()
+	defer c.Stop()
+	c.Flush()
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?profile=cpu-20170601T120000Z.pprof", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, expected %d", w.Code, http.StatusOK)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"name":"main.main"`) {
+		t.Errorf("flame graph doesn't contain main.main:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?profile=cpu-20170601T110000Z.pprof", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown profile: status %d, expected %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,60 @@
+package pprofetheus
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	gcSubsystem = "gc"
+)
+
+// NewGCStatsCollector creates a collector that exports the garbage collector's
+// pause history. It uses runtime/debug.ReadGCStats, which is considerably
+// cheaper than runtime.ReadMemStats as it doesn't stop the world, and is thus
+// suitable for being scraped frequently. Of the options, only those that
+// adjust the names, help texts and constant labels of the metrics apply.
+func NewGCStatsCollector(opts ...Option) prometheus.Collector {
+	o := newOptions(opts)
+
+	return &gcStatsCollector{
+		pause: prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, o.subsystemOr(gcSubsystem), "pause_seconds"),
+			o.help("pause_seconds", "GC pause durations in seconds, read via debug.ReadGCStats without stopping the world"),
+			nil, o.constLabels,
+		),
+		num: prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, o.subsystemOr(gcSubsystem), "num_total"),
+			o.help("num_total", "counter of completed GC cycles, read via debug.ReadGCStats without stopping the world"),
+			nil, o.constLabels,
+		),
+	}
+}
+
+type gcStatsCollector struct {
+	pause *prometheus.Desc
+	num   *prometheus.Desc
+}
+
+func (c *gcStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.pause
+	ch <- c.num
+}
+
+func (c *gcStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := debug.GCStats{
+		// min, 25%, 50%, 75%, max
+		PauseQuantiles: make([]time.Duration, 5),
+	}
+	debug.ReadGCStats(&stats)
+
+	quantiles := make(map[float64]float64)
+	for i, q := range stats.PauseQuantiles {
+		quantiles[float64(i)/float64(len(stats.PauseQuantiles)-1)] = q.Seconds()
+	}
+
+	ch <- prometheus.MustNewConstSummary(c.pause, uint64(stats.NumGC), stats.PauseTotal.Seconds(), quantiles)
+	ch <- prometheus.MustNewConstMetric(c.num, prometheus.CounterValue, float64(stats.NumGC))
+}
@@ -0,0 +1,56 @@
+package pprofetheus
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestGCStatsCollector(t *testing.T) {
+	runtime.GC()
+
+	metrics := collectMetrics(NewGCStatsCollector())
+	if len(metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d instead: %#v", len(metrics), metrics)
+	}
+
+	for _, m := range metrics {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatalf("writing metric to DTO failed: %v", err)
+		}
+
+		switch desc := m.Desc().String(); {
+		case strings.Contains(desc, `fqName: "pprof_gc_pause_seconds"`):
+			summary := metric.GetSummary()
+			if summary.GetSampleCount() < 1 {
+				t.Errorf("GC pause sample count = %d, expected at least 1", summary.GetSampleCount())
+			}
+			if len(summary.GetQuantile()) != 5 {
+				t.Errorf("expected 5 GC pause quantiles, got %d", len(summary.GetQuantile()))
+			}
+		case strings.Contains(desc, `fqName: "pprof_gc_num_total"`):
+			if value := metric.GetCounter().GetValue(); value < 1 {
+				t.Errorf("GC count = %f, expected at least 1", value)
+			}
+		default:
+			t.Errorf("unexpected metric %s", desc)
+		}
+	}
+}
+
+func TestGCStatsCollectorConstLabels(t *testing.T) {
+	c := NewGCStatsCollector(
+		WithNamespace("acme"),
+		WithConstLabels(prometheus.Labels{"service": "api", "version": "1.2.2"}),
+		WithConstLabels(prometheus.Labels{"version": "1.2.3"}),
+	)
+	for _, m := range collectMetrics(c) {
+		if desc := m.Desc().String(); !strings.Contains(desc, `fqName: "acme_gc_`) || !strings.Contains(desc, `constLabels: {service="api",version="1.2.3"}`) {
+			t.Errorf("unexpected metric %s", desc)
+		}
+	}
+}
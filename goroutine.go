@@ -0,0 +1,82 @@
+package pprofetheus
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	goroutineSubsystem = "goroutine"
+)
+
+// NewGoroutineProfileCollector creates a collector that exports the number of
+// goroutines by the function they were started with, as recorded by the
+// runtime's goroutine profile. This makes goroutine leaks visible per code
+// path. Reading the goroutine profile stops the world, so the collector
+// shouldn't be scraped too frequently in programs with many goroutines.
+func NewGoroutineProfileCollector(opts ...Option) (prometheus.Collector, error) {
+	o := newOptions(opts)
+
+	symbolizer, err := newSymbolizer(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGoroutineProfileCollector(symbolizer, o, readGoroutineProfile), nil
+}
+
+func newGoroutineProfileCollector(symbolizer Symbolizer, o *options, records func() []runtime.StackRecord) *goroutineProfileCollector {
+	return &goroutineProfileCollector{
+		symbolCache: newSymbolCache(symbolizer),
+		opts:        o,
+		records:     records,
+		goroutines: prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, o.subsystemOr(goroutineSubsystem), "count"),
+			o.help("count", "number of goroutines by the function they were started with"),
+			o.functionLabels(), o.constLabels,
+		),
+	}
+}
+
+type goroutineProfileCollector struct {
+	sync.Mutex
+	symbolCache *symbolCache
+	opts        *options
+	records     func() []runtime.StackRecord
+	goroutines  *prometheus.Desc
+}
+
+func (c *goroutineProfileCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.goroutines
+}
+
+func (c *goroutineProfileCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	counts := make(map[string]int)
+	for _, r := range c.records() {
+		if name, ok := c.opts.entryFunction(r.Stack(), c.symbolCache); ok {
+			counts[name]++
+		}
+	}
+
+	for name, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.goroutines, prometheus.GaugeValue, float64(n), name)
+	}
+}
+
+// readGoroutineProfile returns the stacks of all goroutines.
+func readGoroutineProfile() []runtime.StackRecord {
+	n, _ := runtime.GoroutineProfile(nil)
+	for {
+		// allow for goroutines that are started in the meantime.
+		records := make([]runtime.StackRecord, n+10)
+		var ok bool
+		if n, ok = runtime.GoroutineProfile(records); ok {
+			return records[:n]
+		}
+	}
+}
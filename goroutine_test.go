@@ -0,0 +1,65 @@
+package pprofetheus
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGoroutineProfileCollector(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "runtime.gopark", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "runtime.goexit.abi0", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.serve", Addr: 0x1200, Size: 0x100, Code: 'T'},
+		{Name: "main.worker", Addr: 0x1300, Size: 0x100, Code: 'T'},
+	}
+	records := func() []runtime.StackRecord {
+		return []runtime.StackRecord{
+			{Stack0: [32]uintptr{0x1010, 0x1210, 0x1110}},
+			{Stack0: [32]uintptr{0x1010, 0x1310, 0x1110}},
+			{Stack0: [32]uintptr{0x1010, 0x1320, 0x1110}},
+			{Stack0: [32]uintptr{0x1310}},
+		}
+	}
+	c := newGoroutineProfileCollector(newSymbolTable(symbols), newOptions(nil), records)
+
+	expected := map[string]float64{
+		"main.serve":  1,
+		"main.worker": 3,
+	}
+	for _, m := range collectMetrics(c) {
+		fn, _ := functionLabel(t, m)
+		if value, ok := expected[fn]; !ok || gaugeValue(t, m) != value {
+			t.Errorf("unexpected number of goroutines %f started with %q", gaugeValue(t, m), fn)
+		}
+		delete(expected, fn)
+	}
+	if len(expected) != 0 {
+		t.Errorf("missing series for %v", expected)
+	}
+}
+
+func TestGoroutineProfileCollectorRuntime(t *testing.T) {
+	c, err := NewGoroutineProfileCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < 5; i++ {
+		go func() {
+			<-done
+		}()
+	}
+
+	waitFor(t, func() bool {
+		for _, m := range collectMetrics(c) {
+			fn, _ := functionLabel(t, m)
+			if strings.HasPrefix(fn, "github.com/travelaudience/pprofetheus.TestGoroutineProfileCollectorRuntime.") && gaugeValue(t, m) == 5 {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,197 @@
+package pprofetheus
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	heapSubsystem = "heap"
+)
+
+// NewHeapProfileCollector creates a collector that exports the memory that is
+// in use by allocating function, as recorded by the runtime's heap profile, at
+// the rate set by runtime.MemProfileRate. Like in the heap profile, the values
+// reflect the state as of the most recently completed garbage collection.
+func NewHeapProfileCollector(opts ...Option) (prometheus.Collector, error) {
+	o := newOptions(opts)
+
+	symbolizer, err := newSymbolizer(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHeapProfileCollector(symbolizer, o, readMemProfile), nil
+}
+
+func newHeapProfileCollector(symbolizer Symbolizer, o *options, records func() []runtime.MemProfileRecord) *heapProfileCollector {
+	return &heapProfileCollector{
+		symbolCache: newSymbolCache(symbolizer),
+		opts:        o,
+		records:     records,
+		inuseBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, o.subsystemOr(heapSubsystem), "inuse_bytes"),
+			o.help("inuse_bytes", "bytes of heap memory in use by allocating function"),
+			o.functionLabels(), o.constLabels,
+		),
+		inuseObjects: prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, o.subsystemOr(heapSubsystem), "inuse_objects"),
+			o.help("inuse_objects", "number of heap objects in use by allocating function"),
+			o.functionLabels(), o.constLabels,
+		),
+	}
+}
+
+type heapProfileCollector struct {
+	sync.Mutex
+	symbolCache  *symbolCache
+	opts         *options
+	records      func() []runtime.MemProfileRecord
+	inuseBytes   *prometheus.Desc
+	inuseObjects *prometheus.Desc
+}
+
+// heapUsage is the heap memory in use by a function.
+type heapUsage struct {
+	bytes   int64
+	objects int64
+}
+
+func (c *heapProfileCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inuseBytes
+	ch <- c.inuseObjects
+}
+
+func (c *heapProfileCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	usage := make(map[string]*heapUsage)
+	for _, r := range c.records() {
+		name, ok := c.opts.stackFunction(r.Stack(), c.symbolCache)
+		if !ok {
+			continue
+		}
+		u, ok := usage[name]
+		if !ok {
+			u = &heapUsage{}
+			usage[name] = u
+		}
+		u.bytes += r.InUseBytes()
+		u.objects += r.InUseObjects()
+	}
+
+	for name, u := range usage {
+		ch <- prometheus.MustNewConstMetric(c.inuseBytes, prometheus.GaugeValue, float64(u.bytes), name)
+		ch <- prometheus.MustNewConstMetric(c.inuseObjects, prometheus.GaugeValue, float64(u.objects), name)
+	}
+}
+
+// readMemProfile returns all records of the runtime's heap profile.
+func readMemProfile() []runtime.MemProfileRecord {
+	n, _ := runtime.MemProfile(nil, true)
+	for {
+		// allow for records that are added in the meantime.
+		records := make([]runtime.MemProfileRecord, n+50)
+		var ok bool
+		if n, ok = runtime.MemProfile(records, true); ok {
+			return records[:n]
+		}
+	}
+}
+
+// NewAllocProfileCollector creates a collector that exports the heap memory
+// allocated by function, as recorded by the runtime's heap profile, as
+// counters. The heap profile records allocations since the start of the
+// process, so the collector only adds the allocations since the previous
+// scrape, starting from the time it is created. It accepts the same options
+// as NewHeapProfileCollector.
+func NewAllocProfileCollector(opts ...Option) (prometheus.Collector, error) {
+	o := newOptions(opts)
+
+	symbolizer, err := newSymbolizer(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAllocProfileCollector(symbolizer, o, readMemProfile), nil
+}
+
+func newAllocProfileCollector(symbolizer Symbolizer, o *options, records func() []runtime.MemProfileRecord) *allocProfileCollector {
+	c := &allocProfileCollector{
+		symbolCache: newSymbolCache(symbolizer),
+		opts:        o,
+		records:     records,
+		previous:    make(map[[32]uintptr]heapUsage),
+		allocBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(heapSubsystem),
+				Name:        "alloc_bytes_total",
+				Help:        o.help("alloc_bytes_total", "counter of bytes of heap memory allocated by function"),
+				ConstLabels: o.constLabels,
+			},
+			o.functionLabels(),
+		),
+		allocObjects: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(heapSubsystem),
+				Name:        "alloc_objects_total",
+				Help:        o.help("alloc_objects_total", "counter of heap objects allocated by function"),
+				ConstLabels: o.constLabels,
+			},
+			o.functionLabels(),
+		),
+	}
+	c.update(false)
+	return c
+}
+
+type allocProfileCollector struct {
+	sync.Mutex
+	symbolCache  *symbolCache
+	opts         *options
+	records      func() []runtime.MemProfileRecord
+	previous     map[[32]uintptr]heapUsage
+	allocBytes   *prometheus.CounterVec
+	allocObjects *prometheus.CounterVec
+}
+
+func (c *allocProfileCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.allocBytes.Describe(ch)
+	c.allocObjects.Describe(ch)
+}
+
+func (c *allocProfileCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.update(true)
+
+	c.allocBytes.Collect(ch)
+	c.allocObjects.Collect(ch)
+}
+
+// update snapshots the allocations of every stack in the heap profile and, if
+// count is true, adds the allocations since the previous snapshot to the
+// metrics.
+func (c *allocProfileCollector) update(count bool) {
+	for _, r := range c.records() {
+		current := heapUsage{bytes: r.AllocBytes, objects: r.AllocObjects}
+		previous := c.previous[r.Stack0]
+		c.previous[r.Stack0] = current
+
+		if !count || current == previous {
+			continue
+		}
+		name, ok := c.opts.stackFunction(r.Stack(), c.symbolCache)
+		if !ok {
+			continue
+		}
+		c.allocBytes.WithLabelValues(name).Add(float64(current.bytes - previous.bytes))
+		c.allocObjects.WithLabelValues(name).Add(float64(current.objects - previous.objects))
+	}
+}
@@ -0,0 +1,117 @@
+package pprofetheus
+
+import (
+	"runtime"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestHeapProfileCollector(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.alloc", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+	records := func() []runtime.MemProfileRecord {
+		return []runtime.MemProfileRecord{
+			memProfileRecord(4096, 1024, 4, 1, 0x1110, 0x1010),
+			memProfileRecord(100, 0, 10, 0, 0x1120, 0x1010),
+			memProfileRecord(64, 0, 1, 0, 0x1020),
+		}
+	}
+	c := newHeapProfileCollector(newSymbolTable(symbols), newOptions(nil), records)
+
+	expected := map[string]heapUsage{
+		"main.alloc": {bytes: 3172, objects: 13},
+		"main.main":  {bytes: 64, objects: 1},
+	}
+	actual := make(map[string]heapUsage)
+	for _, m := range collectMetrics(c) {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		fn, _ := functionLabel(t, m)
+		u := actual[fn]
+		switch m.Desc() {
+		case c.inuseBytes:
+			u.bytes = int64(metric.GetGauge().GetValue())
+		case c.inuseObjects:
+			u.objects = int64(metric.GetGauge().GetValue())
+		}
+		actual[fn] = u
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("got heap usage of %v, expected %v", actual, expected)
+	}
+	for fn, u := range expected {
+		if actual[fn] != u {
+			t.Errorf("heap usage of %s = %+v, expected %+v", fn, actual[fn], u)
+		}
+	}
+}
+
+func TestHeapProfileCollectorRuntime(t *testing.T) {
+	c, err := NewHeapProfileCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.GC()
+	runtime.GC()
+	if len(collectMetrics(c)) == 0 {
+		t.Errorf("no heap metrics collected")
+	}
+}
+
+// memProfileRecord returns a heap profile record with the given statistics
+// and stack of return addresses.
+func memProfileRecord(allocBytes, freeBytes, allocObjects, freeObjects int64, stack ...uintptr) runtime.MemProfileRecord {
+	r := runtime.MemProfileRecord{
+		AllocBytes:   allocBytes,
+		FreeBytes:    freeBytes,
+		AllocObjects: allocObjects,
+		FreeObjects:  freeObjects,
+	}
+	copy(r.Stack0[:], stack)
+	return r
+}
+
+func TestAllocProfileCollector(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.alloc", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+	records := []runtime.MemProfileRecord{
+		memProfileRecord(4096, 1024, 4, 1, 0x1110, 0x1010),
+	}
+	c := newAllocProfileCollector(newSymbolTable(symbols), newOptions(nil), func() []runtime.MemProfileRecord {
+		return records
+	})
+
+	// allocations before the collector was created are not counted.
+	if n := len(collectMetrics(c)); n != 0 {
+		t.Errorf("%d metrics collected without new allocations, expected 0", n)
+	}
+
+	records = []runtime.MemProfileRecord{
+		memProfileRecord(8192, 4096, 8, 4, 0x1110, 0x1010),
+		memProfileRecord(100, 0, 10, 0, 0x1120, 0x1010),
+		memProfileRecord(64, 0, 1, 0, 0x1020),
+	}
+	collectMetrics(c)
+	if value := counterValue(t, c.allocBytes.WithLabelValues("main.alloc")); value != 4196 {
+		t.Errorf("bytes allocated by main.alloc = %f, expected 4196", value)
+	}
+	if value := counterValue(t, c.allocObjects.WithLabelValues("main.alloc")); value != 14 {
+		t.Errorf("objects allocated by main.alloc = %f, expected 14", value)
+	}
+	if value := counterValue(t, c.allocBytes.WithLabelValues("main.main")); value != 64 {
+		t.Errorf("bytes allocated by main.main = %f, expected 64", value)
+	}
+
+	collectMetrics(c)
+	if value := counterValue(t, c.allocBytes.WithLabelValues("main.alloc")); value != 4196 {
+		t.Errorf("bytes allocated by main.alloc = %f without new allocations, expected 4196", value)
+	}
+}
@@ -0,0 +1,48 @@
+package pprofetheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// histogramBucketFactor is the growth factor of the buckets of the native
+	// histograms, which results in a relative error of about 5%.
+	histogramBucketFactor = 1.1
+	// histogramMaxBuckets limits the number of buckets of each native
+	// histogram; if it is exceeded, the histogram is reset after
+	// histogramMinResetDuration at the latest, or its resolution is reduced.
+	histogramMaxBuckets       = 160
+	histogramMinResetDuration = time.Hour
+)
+
+func newTimeHistogram(o *options) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                       o.namespace,
+			Subsystem:                       o.subsystemOr(cpuSubsystem),
+			Name:                            o.timeName("time_ms_distribution"),
+			Help:                            o.help(o.timeName("time_ms_distribution"), o.timeHelp("distribution of the CPU time used by function per profile in milliseconds")),
+			ConstLabels:                     o.constLabels,
+			NativeHistogramBucketFactor:     histogramBucketFactor,
+			NativeHistogramMaxBucketNumber:  histogramMaxBuckets,
+			NativeHistogramMinResetDuration: histogramMinResetDuration,
+		},
+		o.functionLabels()[:1],
+	)
+}
+
+// observe observes the CPU time that each of the functions used in the window
+// w in the histogram h. The time of series that differ only by additional
+// labels is summed up, and functions that weren't sampled at all are observed
+// with zero, so that the histogram reflects how often a function is idle.
+func (w *seriesWindow) observe(h *prometheus.HistogramVec, functions []string) {
+	values := make(map[string]float64, len(functions))
+	for key, value := range w.values {
+		values[w.labels[key][0]] += value
+	}
+	for _, fn := range functions {
+		h.WithLabelValues(fn).Observe(values[fn])
+	}
+}
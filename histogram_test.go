@@ -0,0 +1,40 @@
+package pprofetheus
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCPUProfileCollectorFunctionHistogram(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithFunctionHistogram("main.compute"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	c.addProfile(testProfile(t, symbols, []string{"main.compute", "main.main"}, []string{"main.compute", "main.main"}))
+	c.addProfile(testProfile(t, symbols, []string{"main.main"}))
+
+	metrics := collectMetrics(c.timeHistogram)
+	if len(metrics) != 1 {
+		t.Fatalf("got %d histograms, expected 1", len(metrics))
+	}
+	if fn, _ := functionLabel(t, metrics[0]); fn != "main.compute" {
+		t.Errorf("function = %q", fn)
+	}
+	var metric dto.Metric
+	if err := metrics[0].Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	// the profile without main.compute is observed as zero.
+	h := metric.GetHistogram()
+	if h.GetSampleCount() != 2 || h.GetSampleSum() != 20 || h.GetZeroCount() != 1 {
+		t.Errorf("count = %d, sum = %v, zero count = %d", h.GetSampleCount(), h.GetSampleSum(), h.GetZeroCount())
+	}
+}
@@ -0,0 +1,136 @@
+package pprofetheus
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RecordedProfile is a raw CPU profile in pprof format that a collector has
+// kept with WithProfileHistory.
+type RecordedProfile struct {
+	// Name identifies the profile, e.g. "cpu-20240102T150405Z.pprof" for a
+	// profile read at that time.
+	Name string
+	// Time is when the profile has been read from the profiler.
+	Time time.Time
+	// Duration is the time span that the profile covers.
+	Duration time.Duration
+	Data     []byte
+}
+
+// WithProfileHistory makes the collector keep the raw data of the last count
+// CPU profiles that it has read, dropping profiles older than maxAge unless it
+// is 0. Profiles() returns them and ProfileHistoryHandler() serves them for
+// download, so that the actual profile of a spike in the metrics can be
+// inspected with go tool pprof. Every profile is read on a scrape or drain, so
+// the history covers count times the drain interval.
+func WithProfileHistory(count int, maxAge time.Duration) Option {
+	return func(o *options) {
+		o.historyCount = count
+		o.historyMaxAge = maxAge
+	}
+}
+
+// profileHistory is the ring buffer of the profiles kept with
+// WithProfileHistory, oldest first.
+type profileHistory struct {
+	count    int
+	maxAge   time.Duration
+	profiles []RecordedProfile
+}
+
+// add adds the profile data read at time t, covering duration, to the history,
+// dropping the oldest profile if the history is full.
+func (h *profileHistory) add(t time.Time, duration time.Duration, data []byte) {
+	name := profileName(t)
+	for i := 1; h.contains(name + ".pprof"); i++ {
+		name = fmt.Sprintf("%s-%d", profileName(t), i)
+	}
+
+	if len(h.profiles) == h.count {
+		copy(h.profiles, h.profiles[1:])
+		h.profiles = h.profiles[:len(h.profiles)-1]
+	}
+	h.profiles = append(h.profiles, RecordedProfile{
+		Name:     name + ".pprof",
+		Time:     t,
+		Duration: duration,
+		Data:     data,
+	})
+}
+
+// contains returns true if the history has a profile named name.
+func (h *profileHistory) contains(name string) bool {
+	for _, p := range h.profiles {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// list drops the profiles that are older than the maximum age at time now and
+// returns a copy of the remaining ones.
+func (h *profileHistory) list(now time.Time) []RecordedProfile {
+	if h.maxAge > 0 {
+		i := 0
+		for i < len(h.profiles) && now.Sub(h.profiles[i].Time) > h.maxAge {
+			i++
+		}
+		h.profiles = append(h.profiles[:0], h.profiles[i:]...)
+	}
+	return append([]RecordedProfile(nil), h.profiles...)
+}
+
+// Profiles returns the profiles kept with WithProfileHistory, oldest first.
+// It doesn't read the profile data recorded since the last scrape.
+func (c *cpuProfileCollector) Profiles() []RecordedProfile {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.history == nil {
+		return nil
+	}
+	return c.history.list(c.opts.clock.Now())
+}
+
+// ProfileHistoryHandler returns an http.Handler that serves the profiles kept
+// by the collector c with WithProfileHistory. It serves
+//
+//	GET /       lists the profiles, one per line with name, time and duration
+//	GET /<name> downloads a profile in pprof format
+//
+// A downloaded profile can be opened directly, e.g. with
+// "go tool pprof http://app:8080/profiles/cpu-20240102T150405Z.pprof". To
+// mount the handler below a path, wrap it in http.StripPrefix.
+func ProfileHistoryHandler(c ProfileCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		profiles := c.Profiles()
+		if name == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, p := range profiles {
+				fmt.Fprintf(w, "%s\t%s\t%v\n", p.Name, p.Time.UTC().Format(time.RFC3339), p.Duration)
+			}
+			return
+		}
+
+		for _, p := range profiles {
+			if p.Name == name {
+				w.Header().Set("X-Content-Type-Options", "nosniff")
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", p.Name))
+				w.Write(p.Data)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
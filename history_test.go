@@ -0,0 +1,90 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCPUProfileCollectorProfileHistory(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	clk := &fakeClock{now: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)}
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), clk, WithProfileHistory(2, 90*time.Second))
+
+	c.Start()
+	defer c.Stop()
+	for i := 0; i < 2; i++ {
+		c.Flush()
+		clk.Advance(time.Minute)
+	}
+	// the second profile at the same time gets a distinct name.
+	c.Flush()
+	c.Flush()
+
+	var names []string
+	for _, p := range c.Profiles() {
+		names = append(names, p.Name)
+		if !bytes.Equal(p.Data, prof.data) {
+			t.Errorf("profile %s has different data", p.Name)
+		}
+	}
+	expectedNames := []string{"cpu-20170601T120200Z.pprof", "cpu-20170601T120200Z-1.pprof"}
+	if len(names) != len(expectedNames) || names[0] != expectedNames[0] || names[1] != expectedNames[1] {
+		t.Fatalf("profiles = %v, expected %v", names, expectedNames)
+	}
+
+	// the older profile expires, as the maximum age is exceeded.
+	clk.Advance(time.Minute)
+	c.Flush()
+	if profiles := c.Profiles(); len(profiles) != 2 || profiles[0].Name != "cpu-20170601T120200Z-1.pprof" {
+		t.Errorf("got %d profiles, expected cpu-20170601T120200Z-1.pprof first", len(profiles))
+	}
+	clk.Advance(2 * time.Minute)
+	if profiles := c.Profiles(); len(profiles) != 0 {
+		t.Errorf("got %d profiles after their maximum age, expected none", len(profiles))
+	}
+}
+
+func TestProfileHistoryHandler(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)}, WithProfileHistory(10, 0))
+	h := ProfileHistoryHandler(c)
+
+	c.Start()
+	defer c.Stop()
+	c.Flush()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if expected := "cpu-20170601T120000Z.pprof\t2017-06-01T12:00:00Z\t0s\n"; w.Body.String() != expected {
+		t.Errorf("listing = %q, expected %q", w.Body.String(), expected)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cpu-20170601T120000Z.pprof", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	if w.Code != http.StatusOK || !bytes.Equal(body, prof.data) {
+		t.Errorf("download: status %d with %d bytes, expected %d bytes", w.Code, len(body), len(prof.data))
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cpu-20170601T110000Z.pprof", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown profile: status %d, expected %d", w.Code, http.StatusNotFound)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, expected %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,130 @@
+package pprofetheus
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const modulePath = "github.com/travelaudience/pprofetheus"
+
+// collectorInfo is the gauge pprof_collector_info, which describes how the
+// profile data of a CPU profile collector is collected.
+type collectorInfo struct {
+	gauge  *prometheus.GaugeVec
+	labels []string
+}
+
+func newCollectorInfo(o *options) *collectorInfo {
+	return &collectorInfo{
+		gauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "collector_info",
+				Help:        o.help("collector_info", "configuration of the CPU profile collector, with a constant value of 1"),
+				ConstLabels: o.constLabels,
+			},
+			[]string{sampleRateLabel, "go_version", "build_id", "symbolizer", "version"},
+		),
+		labels: []string{runtime.Version(), binaryBuildID(o), symbolizerBackend(o), collectorVersion()},
+	}
+}
+
+// set sets the gauge for the sample rate hz.
+func (i *collectorInfo) set(hz int) {
+	i.gauge.Reset()
+	i.gauge.WithLabelValues(append([]string{strconv.Itoa(hz)}, i.labels...)...).Set(1)
+}
+
+// symbolizerBackend returns how the profile locations are resolved.
+func symbolizerBackend(o *options) string {
+	switch {
+	case o.symbolizer != nil:
+		return "custom"
+	case o.runtimeSymbols:
+		return "runtime"
+	case o.symbols != nil:
+		return "symbols"
+	case o.lazySymbols:
+		return "lazy"
+	}
+	return "binary"
+}
+
+var (
+	executableBuildIDOnce sync.Once
+	executableBuildID     string
+)
+
+// binaryBuildID returns the build ID of the binary set with WithBinaryPath or
+// of the current process, or an empty string if it has none or isn't an ELF
+// binary. The GNU build ID is preferred over the Go build ID. If the symbols
+// are provided separately, the profiled binary may be another one than the
+// current process, e.g. for remote profiles, so no build ID is returned.
+func binaryBuildID(o *options) string {
+	if o.binaryPath != "" {
+		return readBuildID(o.binaryPath)
+	}
+	if o.symbols != nil || o.symbolizer != nil {
+		return ""
+	}
+	executableBuildIDOnce.Do(func() {
+		if path, err := os.Executable(); err == nil {
+			executableBuildID = readBuildID(path)
+		}
+	})
+	return executableBuildID
+}
+
+func readBuildID(path string) string {
+	f, err := elf.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	if id, ok := buildID(f); ok {
+		return hex.EncodeToString(id)
+	}
+	if sect := f.Section(".note.go.buildid"); sect != nil {
+		if data, err := sect.Data(); err == nil {
+			if id, ok := parseNote(data, f.ByteOrder, "Go\x00\x00", ntGoBuildID); ok {
+				return string(id)
+			}
+		}
+	}
+	return ""
+}
+
+// collectorVersion returns the version of the pprofetheus module that the
+// binary has been built with.
+func collectorVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := "unknown"
+	if info.Main.Path == modulePath {
+		version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			version = dep.Version
+			if dep.Replace != nil {
+				version = dep.Replace.Version
+			}
+		}
+	}
+	if version == "" {
+		// replaced by a local directory.
+		version = "(devel)"
+	}
+	return version
+}
@@ -0,0 +1,115 @@
+package pprofetheus
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollectorInfo(t *testing.T) {
+	c, _ := newFakeCollector(t, []Symbol{}, nil, &fakeClock{now: time.Unix(0, 0)})
+
+	infoLabels := func() map[string]string {
+		metrics := collectMetrics(c.collectorInfo.gauge)
+		if len(metrics) != 1 {
+			t.Fatalf("got %d info metrics, expected 1", len(metrics))
+		}
+		var metric dto.Metric
+		if err := metrics[0].Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if value := metric.GetGauge().GetValue(); value != 1 {
+			t.Errorf("value = %v, expected 1", value)
+		}
+		labels := make(map[string]string)
+		for _, l := range metric.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		return labels
+	}
+
+	expected := map[string]string{
+		"sample_rate_hz": "100",
+		"go_version":     runtime.Version(),
+		"build_id":       binaryBuildID(c.opts),
+		"symbolizer":     "symbols",
+		"version":        collectorVersion(),
+	}
+	if defaultRuntimeSymbols {
+		expected["symbolizer"] = "runtime"
+	}
+	if labels := infoLabels(); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("labels = %v, expected %v", labels, expected)
+	}
+
+	if err := c.SetSampleRate(250); err != nil {
+		t.Fatal(err)
+	}
+	expected["sample_rate_hz"] = "250"
+	if labels := infoLabels(); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("labels after SetSampleRate = %v, expected %v", labels, expected)
+	}
+}
+
+func TestSymbolizerBackend(t *testing.T) {
+	testData := []struct {
+		Opts     []Option
+		Expected string
+	}{
+		{nil, "binary"},
+		{[]Option{WithSymbols([]Symbol{})}, "symbols"},
+		{[]Option{WithRuntimeSymbolizer()}, "runtime"},
+		{[]Option{WithLazySymbols()}, "lazy"},
+		{[]Option{WithSymbolizer(symbolTable{})}, "custom"},
+	}
+	for idx, testEntry := range testData {
+		expected := testEntry.Expected
+		if defaultRuntimeSymbols && expected != "custom" {
+			// the runtime is preferred over the symbol table.
+			expected = "runtime"
+		}
+		if backend := symbolizerBackend(newOptions(testEntry.Opts)); backend != expected {
+			t.Errorf("%d. symbolizer backend = %s, expected %s", idx, backend, expected)
+		}
+	}
+}
+
+func TestReadBuildID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("test binaries are ELF binaries only on Linux")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := readBuildID(exe); id == "" {
+		t.Error("test binary has no build ID")
+	}
+	if id := readBuildID("testdata/does-not-exist"); id != "" {
+		t.Errorf("build ID of a missing binary = %q", id)
+	}
+}
+
+func TestCollectorInfoManager(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithSymbols([]Symbol{})},
+		{},
+	} {
+		opts = append(opts, WithBlockProfileRate(0), WithMutexProfileFraction(0), WithDrainInterval(0))
+		m, err := NewManager(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the symbolizer that the manager shares between its collectors
+		// isn't a custom one.
+		labels := m.CPUProfileCollector().(*cpuProfileCollector).collectorInfo.labels
+		if expected := newCollectorInfo(newOptions(opts)).labels; !reflect.DeepEqual(labels, expected) {
+			t.Errorf("labels = %v, expected %v", labels, expected)
+		}
+	}
+}
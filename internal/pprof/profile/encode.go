@@ -51,11 +51,17 @@ func (p *Profile) preEncode() {
 		sort.Strings(numKeys)
 		for _, k := range numKeys {
 			vs := s.NumLabel[k]
-			for _, v := range vs {
+			units := s.NumUnit[k]
+			for i, v := range vs {
+				var unitX int64
+				if i < len(units) {
+					unitX = addString(strings, units[i])
+				}
 				s.labelX = append(s.labelX,
 					Label{
-						keyX: addString(strings, k),
-						numX: v,
+						keyX:  addString(strings, k),
+						numX:  v,
+						unitX: unitX,
 					},
 				)
 			}
@@ -93,6 +99,12 @@ func (p *Profile) preEncode() {
 
 	p.dropFramesX = addString(strings, p.DropFrames)
 	p.keepFramesX = addString(strings, p.KeepFrames)
+	p.commentX = nil
+	for _, c := range p.Comments {
+		p.commentX = append(p.commentX, addString(strings, c))
+	}
+	p.defaultSampleTypeX = addString(strings, p.DefaultSampleType)
+	p.docURLX = addString(strings, p.DocURL)
 
 	if pt := p.PeriodType; pt != nil {
 		pt.typeX = addString(strings, pt.Type)
@@ -130,6 +142,9 @@ func (p *Profile) encode(b *buffer) {
 		encodeMessage(b, 11, p.PeriodType)
 	}
 	encodeInt64Opt(b, 12, p.Period)
+	encodeInt64s(b, 13, p.commentX)
+	encodeInt64Opt(b, 14, p.defaultSampleTypeX)
+	encodeInt64Opt(b, 15, p.docURLX)
 }
 
 var profileDecoder = []decoder{
@@ -197,6 +212,12 @@ var profileDecoder = []decoder{
 	},
 	// repeated int64 period = 12
 	func(b *buffer, m message) error { return decodeInt64(b, &m.(*Profile).Period) },
+	// repeated int64 comment = 13
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*Profile).commentX) },
+	// optional int64 default_sample_type = 14
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*Profile).defaultSampleTypeX) },
+	// optional int64 doc_url = 15
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*Profile).docURLX) },
 }
 
 // postDecode takes the unexported fields populated by decode (with
@@ -244,6 +265,8 @@ func (p *Profile) postDecode() error {
 	for _, s := range p.Sample {
 		labels := make(map[string][]string)
 		numLabels := make(map[string][]int64)
+		numUnits := make(map[string][]string)
+		var hasUnits bool
 		for _, l := range s.labelX {
 			var key, value string
 			key, err = getString(p.stringTable, &l.keyX, err)
@@ -251,7 +274,12 @@ func (p *Profile) postDecode() error {
 				value, err = getString(p.stringTable, &l.strX, err)
 				labels[key] = append(labels[key], value)
 			} else {
+				var unit string
+				unit, err = getString(p.stringTable, &l.unitX, err)
 				numLabels[key] = append(numLabels[key], l.numX)
+				// the units are kept aligned with the values.
+				numUnits[key] = append(numUnits[key], unit)
+				hasUnits = hasUnits || unit != ""
 			}
 		}
 		if len(labels) > 0 {
@@ -260,6 +288,9 @@ func (p *Profile) postDecode() error {
 		if len(numLabels) > 0 {
 			s.NumLabel = numLabels
 		}
+		if hasUnits {
+			s.NumUnit = numUnits
+		}
 		s.Location = nil
 		for _, lid := range s.locationIDX {
 			s.Location = append(s.Location, locations[lid])
@@ -269,6 +300,15 @@ func (p *Profile) postDecode() error {
 
 	p.DropFrames, err = getString(p.stringTable, &p.dropFramesX, err)
 	p.KeepFrames, err = getString(p.stringTable, &p.keepFramesX, err)
+	p.Comments = nil
+	for i := range p.commentX {
+		var c string
+		c, err = getString(p.stringTable, &p.commentX[i], err)
+		p.Comments = append(p.Comments, c)
+	}
+	p.commentX = nil
+	p.DefaultSampleType, err = getString(p.stringTable, &p.defaultSampleTypeX, err)
+	p.DocURL, err = getString(p.stringTable, &p.docURLX, err)
 
 	if pt := p.PeriodType; pt == nil {
 		p.PeriodType = &ValueType{}
@@ -336,6 +376,7 @@ func (p Label) encode(b *buffer) {
 	encodeInt64Opt(b, 1, p.keyX)
 	encodeInt64Opt(b, 2, p.strX)
 	encodeInt64Opt(b, 3, p.numX)
+	encodeInt64Opt(b, 4, p.unitX)
 }
 
 var labelDecoder = []decoder{
@@ -346,6 +387,8 @@ var labelDecoder = []decoder{
 	func(b *buffer, m message) error { return decodeInt64(b, &m.(*Label).strX) },
 	// optional int64 num = 3
 	func(b *buffer, m message) error { return decodeInt64(b, &m.(*Label).numX) },
+	// optional int64 num_unit = 4
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*Label).unitX) },
 }
 
 func (p *Mapping) decoder() []decoder {
@@ -390,6 +433,7 @@ func (p *Location) encode(b *buffer) {
 	for i := range p.Line {
 		encodeMessage(b, 4, &p.Line[i])
 	}
+	encodeBoolOpt(b, 5, p.IsFolded)
 }
 
 var locationDecoder = []decoder{
@@ -403,6 +447,7 @@ var locationDecoder = []decoder{
 		pp.Line = append(pp.Line, Line{})
 		return decodeMessage(b, &pp.Line[n])
 	},
+	func(b *buffer, m message) error { return decodeBool(b, &m.(*Location).IsFolded) }, // optional bool is_folded = 5;
 }
 
 func (p *Line) decoder() []decoder {
@@ -412,6 +457,7 @@ func (p *Line) decoder() []decoder {
 func (p *Line) encode(b *buffer) {
 	encodeUint64Opt(b, 1, p.functionIDX)
 	encodeInt64Opt(b, 2, p.Line)
+	encodeInt64Opt(b, 3, p.Column)
 }
 
 var lineDecoder = []decoder{
@@ -420,6 +466,8 @@ var lineDecoder = []decoder{
 	func(b *buffer, m message) error { return decodeUint64(b, &m.(*Line).functionIDX) },
 	// optional int64 line = 2
 	func(b *buffer, m message) error { return decodeInt64(b, &m.(*Line).Line) },
+	// optional int64 column = 3
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*Line).Column) },
 }
 
 func (p *Function) decoder() []decoder {
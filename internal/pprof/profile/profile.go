@@ -33,9 +33,20 @@ type Profile struct {
 	PeriodType    *ValueType
 	Period        int64
 
-	dropFramesX int64
-	keepFramesX int64
-	stringTable []string
+	// Comments are free-form annotations of the profile.
+	Comments []string
+	// DefaultSampleType is the type of the samples that tools should show
+	// by default, if set.
+	DefaultSampleType string
+	// DocURL documents the sample types of the profile.
+	DocURL string
+
+	dropFramesX        int64
+	keepFramesX        int64
+	commentX           []int64
+	defaultSampleTypeX int64
+	docURLX            int64
+	stringTable        []string
 }
 
 // ValueType corresponds to Profile.ValueType
@@ -53,6 +64,9 @@ type Sample struct {
 	Value    []int64
 	Label    map[string][]string
 	NumLabel map[string][]int64
+	// NumUnit are the units of the values of NumLabel with the same key and
+	// index. It is nil if none of them has a unit.
+	NumUnit map[string][]string
 
 	locationIDX []uint64
 	labelX      []Label
@@ -64,6 +78,8 @@ type Label struct {
 	// Exactly one of the two following values must be set
 	strX int64
 	numX int64 // Integer value for this label
+	// unitX is the unit of numX.
+	unitX int64
 }
 
 // Mapping corresponds to Profile.Mapping
@@ -89,6 +105,9 @@ type Location struct {
 	Mapping *Mapping
 	Address uint64
 	Line    []Line
+	// IsFolded is true if the functions of Line have been folded into a
+	// single location, e.g. by the inliner.
+	IsFolded bool
 
 	mappingIDX uint64
 }
@@ -97,6 +116,7 @@ type Location struct {
 type Line struct {
 	Function *Function
 	Line     int64
+	Column   int64
 
 	functionIDX uint64
 }
@@ -122,8 +142,13 @@ func Parse(r io.Reader) (*Profile, error) {
 	if err != nil {
 		return nil, err
 	}
+	return ParseData(orig)
+}
 
+// ParseData parses a profile from data like Parse.
+func ParseData(orig []byte) (*Profile, error) {
 	var p *Profile
+	var err error
 	if len(orig) >= 2 && orig[0] == 0x1f && orig[1] == 0x8b {
 		gz, err := gzip.NewReader(bytes.NewBuffer(orig))
 		if err != nil {
@@ -136,7 +161,13 @@ func Parse(r io.Reader) (*Profile, error) {
 		orig = data
 	}
 	if p, err = parseUncompressed(orig); err != nil {
-		if p, err = parseLegacy(orig); err != nil {
+		protoErr := err
+		if p, err = parseLegacy(orig); err == errUnrecognized {
+			// the data isn't a legacy profile either, so the protobuf
+			// error is the more helpful one.
+			err = protoErr
+		}
+		if err != nil {
 			return nil, fmt.Errorf("parsing profile: %v", err)
 		}
 	}
@@ -232,9 +263,17 @@ func (p *Profile) CheckValid() error {
 		return fmt.Errorf("missing sample type information")
 	}
 	for _, s := range p.Sample {
+		if s == nil {
+			return fmt.Errorf("profile has nil sample")
+		}
 		if len(s.Value) != sampleLen {
 			return fmt.Errorf("mismatch: sample has: %d values vs. %d types", len(s.Value), len(p.SampleType))
 		}
+		for _, l := range s.Location {
+			if l == nil {
+				return fmt.Errorf("sample has nil location")
+			}
+		}
 	}
 
 	// Check that all mappings/locations/functions are in the tables
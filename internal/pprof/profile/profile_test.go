@@ -22,3 +22,68 @@ func TestEmptyProfile(t *testing.T) {
 		t.Errorf("Profile should be empty, got %#v", p)
 	}
 }
+
+func TestParseRoundTrip(t *testing.T) {
+	f := &Function{ID: 1, Name: "main.main", Filename: "main.go", StartLine: 10}
+	m := &Mapping{ID: 1, Start: 0x1000, Limit: 0x2000, File: "/bin/main"}
+	l := &Location{ID: 1, Mapping: m, Address: 0x1010, Line: []Line{{Function: f, Line: 12, Column: 3}}, IsFolded: true}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "alloc_space", Unit: "bytes"}},
+		Sample: []*Sample{{
+			Location: []*Location{l},
+			Value:    []int64{4096},
+			NumLabel: map[string][]int64{"bytes": {1024, 2048}},
+			NumUnit:  map[string][]string{"bytes": {"bytes", ""}},
+		}},
+		Mapping:           []*Mapping{m},
+		Location:          []*Location{l},
+		Function:          []*Function{f},
+		PeriodType:        &ValueType{Type: "space", Unit: "bytes"},
+		Comments:          []string{"collected by test"},
+		DefaultSampleType: "alloc_space",
+		DocURL:            "https://example.com/profiles",
+	}
+
+	var compressed bytes.Buffer
+	if err := p.Write(&compressed); err != nil {
+		t.Fatal(err)
+	}
+	p.preEncode()
+	uncompressed := marshal(p)
+
+	for name, data := range map[string][]byte{"gzip": compressed.Bytes(), "uncompressed": uncompressed} {
+		got, err := ParseData(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(got.Comments) != 1 || got.Comments[0] != "collected by test" {
+			t.Errorf("%s: comments = %q", name, got.Comments)
+		}
+		if got.DefaultSampleType != "alloc_space" || got.DocURL != "https://example.com/profiles" {
+			t.Errorf("%s: default sample type = %q, doc URL = %q", name, got.DefaultSampleType, got.DocURL)
+		}
+		gl := got.Location[0]
+		if !gl.IsFolded || gl.Line[0].Column != 3 {
+			t.Errorf("%s: location folded = %t, column = %d", name, gl.IsFolded, gl.Line[0].Column)
+		}
+		if units := got.Sample[0].NumUnit["bytes"]; len(units) != 2 || units[0] != "bytes" || units[1] != "" {
+			t.Errorf("%s: num units = %q", name, units)
+		}
+	}
+}
+
+func TestParseMissingLocation(t *testing.T) {
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Sample:     []*Sample{{Location: []*Location{{ID: 1}}, Value: []int64{1}}},
+	}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// the sample refers to a location that isn't in the profile.
+	if _, err := ParseData(buf.Bytes()); err == nil {
+		t.Error("parsing a profile with a missing location succeeded")
+	}
+}
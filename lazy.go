@@ -0,0 +1,197 @@
+package pprofetheus
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// WithLazySymbols makes the collectors resolve the addresses of ELF binaries
+// lazily instead of reading the whole symbol table when they are created. The
+// first lookup indexes the addresses of the symbols; the names are only read
+// from the binary for the addresses that are resolved, and cached. This saves
+// the memory of holding the names of all symbols of large binaries. Other
+// binaries are still read completely.
+func WithLazySymbols() Option {
+	return func(o *options) {
+		o.lazySymbols = true
+	}
+}
+
+// lazySymbol is an index entry of a lazySymbolizer.
+type lazySymbol struct {
+	addr uint64
+	size uint64
+	// name is the offset of the name in the string table.
+	name uint32
+}
+
+// lazySymbolizer is a Symbolizer that resolves addresses using the symbol
+// table of an ELF binary, reading the names of the symbols only on demand.
+// Like a symbolTable, it is safe for concurrent use.
+type lazySymbolizer struct {
+	o    *options
+	path string
+
+	once    sync.Once
+	file    *os.File
+	strtab  *io.SectionReader
+	symbols []lazySymbol
+	// indexBytes is the size of symbols once they have been indexed.
+	indexBytes uint64
+}
+
+// newLazyBinarySymbolizer returns a lazySymbolizer for the binary selected by
+// the options o if WithLazySymbols has been set and the binary or its
+// separate debug file is an ELF binary with a symbol table.
+func newLazyBinarySymbolizer(o *options) (Symbolizer, bool) {
+	if !o.lazySymbols {
+		return nil, false
+	}
+	path, err := binaryPath(o)
+	if err != nil {
+		return nil, false
+	}
+
+	symbolPath := path
+	if !hasSymbolTable(path) {
+		// stripped binaries may come with a separate debug file.
+		debugPath, ok := lookupDebugFile(o, path)
+		if !ok || !hasSymbolTable(debugPath) {
+			return nil, false
+		}
+		symbolPath = debugPath
+	}
+	o.log(LevelInfo, "resolving symbols lazily", "path", path, "symbol_file", symbolPath)
+	return relocatedSymbolizer(o, path, &lazySymbolizer{o: o, path: symbolPath}), true
+}
+
+// hasSymbolTable returns true if the file at path is an ELF binary with a
+// symbol table.
+func hasSymbolTable(path string) bool {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	sect := f.Section(".symtab")
+	return sect != nil && sect.Type == elf.SHT_SYMTAB
+}
+
+func (s *lazySymbolizer) Resolve(addr uint64) (string, bool) {
+	s.once.Do(func() {
+		if err := s.index(); err != nil {
+			s.o.log(LevelError, "indexing symbols failed", "path", s.path, "err", err)
+		}
+	})
+
+	i, ok := resolveIndex(addr, len(s.symbols), func(i int) (uint64, uint64) {
+		return s.symbols[i].addr, s.symbols[i].size
+	})
+	if !ok {
+		return "", false
+	}
+	name, err := s.readName(s.symbols[i].name)
+	if err != nil {
+		s.o.log(LevelWarn, "reading symbol name failed", "path", s.path, "addr", addr, "err", err)
+		return "", false
+	}
+	return name, true
+}
+
+// index reads the addresses, sizes and name offsets of the symbols and sorts
+// them like newSymbolTable. The file is kept open to read the names from.
+func (s *lazySymbolizer) index() error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	f, err := elf.NewFile(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sect := f.Section(".symtab")
+	if sect == nil || int(sect.Link) >= len(f.Sections) {
+		file.Close()
+		return fmt.Errorf("no symbol table")
+	}
+	strtab := f.Sections[sect.Link]
+
+	symbols, err := readLazySymbols(sect.Open(), f.Class, f.ByteOrder)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		if symbols[i].addr != symbols[j].addr {
+			return symbols[i].addr < symbols[j].addr
+		}
+		return symbols[i].size < symbols[j].size
+	})
+
+	s.file = file
+	s.strtab = io.NewSectionReader(file, int64(strtab.Offset), int64(strtab.Size))
+	s.symbols = symbols
+	atomic.StoreUint64(&s.indexBytes, uint64(len(symbols))*uint64(unsafe.Sizeof(lazySymbol{})))
+	return nil
+}
+
+// readLazySymbols reads the entries of an ELF symbol table from r, skipping
+// the first one, which is reserved.
+func readLazySymbols(r io.Reader, class elf.Class, order binary.ByteOrder) ([]lazySymbol, error) {
+	size := elf.Sym64Size
+	if class == elf.ELFCLASS32 {
+		size = elf.Sym32Size
+	}
+
+	br := bufio.NewReader(r)
+	entry := make([]byte, size)
+	var symbols []lazySymbol
+	for first := true; ; first = false {
+		if _, err := io.ReadFull(br, entry); err == io.EOF {
+			return symbols, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if first {
+			continue
+		}
+
+		// both classes start with the offset of the name.
+		s := lazySymbol{name: order.Uint32(entry[0:4])}
+		if class == elf.ELFCLASS32 {
+			s.addr = uint64(order.Uint32(entry[4:8]))
+			s.size = uint64(order.Uint32(entry[8:12]))
+		} else {
+			s.addr = order.Uint64(entry[8:16])
+			s.size = order.Uint64(entry[16:24])
+		}
+		symbols = append(symbols, s)
+	}
+}
+
+// readName reads the NUL-terminated name at offset off of the string table.
+func (s *lazySymbolizer) readName(off uint32) (string, error) {
+	var name []byte
+	buf := make([]byte, 64)
+	for pos := int64(off); ; pos += int64(len(buf)) {
+		n, err := s.strtab.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], 0); i >= 0 {
+			return string(append(name, buf[:i]...)), nil
+		}
+		name = append(name, buf[:n]...)
+		if err != nil {
+			return "", err
+		}
+	}
+}
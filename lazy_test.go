@@ -0,0 +1,72 @@
+package pprofetheus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLazySymbolizer(t *testing.T) {
+	o := newOptions(nil)
+	path, symbols, err := readBinarySymbols(o)
+	if err != nil {
+		t.Skipf("reading symbols of the test binary failed: %v", err)
+	}
+	if !hasSymbolTable(path) {
+		t.Skip("test binary is no ELF binary with a symbol table")
+	}
+
+	eager := newSymbolTable(symbols)
+	// symbols that share their address with others are resolved to either
+	// of them, depending on the order of the symbol table.
+	shared := make(map[uint64]int)
+	for _, s := range eager.symbols {
+		shared[s.Addr]++
+	}
+	lazy := &lazySymbolizer{o: o, path: path}
+	checked := 0
+	for _, s := range eager.symbols {
+		if s.Code != 'T' && s.Code != 't' || shared[s.Addr] > 1 {
+			continue
+		}
+		for _, addr := range []uint64{s.Addr, s.Addr + uint64(s.Size)/2} {
+			expectedName, expectedOK := eager.Resolve(addr)
+			name, ok := lazy.Resolve(addr)
+			if name != expectedName || ok != expectedOK {
+				t.Fatalf("lazy symbolizer resolved %#x to %q, %t, expected %q, %t", addr, name, ok, expectedName, expectedOK)
+			}
+		}
+		checked++
+	}
+	if checked == 0 {
+		t.Fatal("test binary has no text symbols")
+	}
+	if name, ok := lazy.Resolve(^uint64(0)); ok {
+		t.Errorf("highest address resolved to %s", name)
+	}
+}
+
+func TestNewLazyBinarySymbolizer(t *testing.T) {
+	if _, ok := newLazyBinarySymbolizer(newOptions(nil)); ok {
+		t.Error("lazy symbolizer created without WithLazySymbols")
+	}
+	if _, ok := newLazyBinarySymbolizer(newOptions([]Option{WithLazySymbols(), WithBinaryPath("testdata/does-not-exist")})); ok {
+		t.Error("lazy symbolizer created for a missing binary")
+	}
+
+	o := newOptions([]Option{WithLazySymbols()})
+	path, err := binaryPath(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasSymbolTable(path) {
+		t.Skip("test binary is no ELF binary with a symbol table")
+	}
+	symbolizer, ok := newLazyBinarySymbolizer(o)
+	if !ok {
+		t.Fatal("no lazy symbolizer created for the test binary")
+	}
+	addr := uint64(reflect.ValueOf(TestNewLazyBinarySymbolizer).Pointer())
+	if name, ok := symbolizer.Resolve(addr); !ok || name != "github.com/travelaudience/pprofetheus.TestNewLazyBinarySymbolizer" {
+		t.Errorf("resolved the address of the test to %q, %t", name, ok)
+	}
+}
@@ -0,0 +1,112 @@
+package pprofetheus
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mapping is an executable mapping of a file into the address space of the
+// current process.
+type mapping struct {
+	start  uint64
+	end    uint64
+	offset uint64
+	path   string
+}
+
+// loadBias returns the difference between the addresses of the binary at
+// path, which is the binary of the current process, at runtime and the
+// addresses it was linked at. It is non-zero for position-independent
+// executables, which are loaded at a randomized base address. The load address
+// is read from /proc/self/maps.
+func loadBias(path string) (uint64, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := elf.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	mappings, err := readMappings()
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range mappings {
+		if m.path != path {
+			continue
+		}
+		if bias, ok := elfBias(f, m); ok {
+			return bias, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no executable mapping of %s found", path)
+}
+
+// readMappings returns the executable file mappings of the current process.
+func readMappings() ([]mapping, error) {
+	maps, err := os.Open("/proc/self/maps")
+	if err != nil {
+		return nil, err
+	}
+	defer maps.Close()
+
+	var mappings []mapping
+	scanner := bufio.NewScanner(maps)
+	for scanner.Scan() {
+		// e.g. "55d0c8a00000-55d0c8c4b000 r-xp 00000000 fd:01 1234 /usr/bin/app"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || !filepath.IsAbs(fields[5]) || !strings.Contains(fields[1], "x") {
+			continue
+		}
+
+		addrs := strings.SplitN(fields[0], "-", 2)
+		if len(addrs) != 2 {
+			return nil, fmt.Errorf("invalid address range %q", fields[0])
+		}
+		start, err := strconv.ParseUint(addrs[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		end, err := strconv.ParseUint(addrs[1], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := strconv.ParseUint(fields[2], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		mappings = append(mappings, mapping{start: start, end: end, offset: offset, path: fields[5]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+// elfBias returns the load bias of the ELF file f, i.e. the difference between
+// its addresses at runtime and the addresses it was linked at, given its
+// executable mapping m. It returns false if m doesn't contain an executable
+// segment of f.
+func elfBias(f *elf.File, m mapping) (uint64, bool) {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Flags&elf.PF_X == 0 {
+			continue
+		}
+		// the mapping starts at the page that contains the segment.
+		if m.offset <= prog.Off && prog.Off < m.offset+(m.end-m.start) {
+			return m.start - (prog.Vaddr - (prog.Off - m.offset)), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,38 @@
+package pprofetheus
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLoadBias(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols, err := readSymbols(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resolving the runtime address of a function has to work regardless of
+	// whether the test binary is position-independent, e.g. built with
+	// -buildmode=pie.
+	symbolizer := newBinarySymbolizer(newOptions(nil), exe, symbols)
+	addr := uint64(reflect.ValueOf(TestLoadBias).Pointer())
+	if name, ok := symbolizer.Resolve(addr); !ok || name != "github.com/travelaudience/pprofetheus.TestLoadBias" {
+		t.Errorf("Resolve(%#x) = %q, %t", addr, name, ok)
+	}
+}
+
+func TestBiasedSymbolizer(t *testing.T) {
+	s := biasedSymbolizer{symbolizer: fakeSymbolizer{0x1000: "main.main"}, bias: 0x7f0000000000}
+
+	if name, ok := s.Resolve(0x7f0000001000); !ok || name != "main.main" {
+		t.Errorf("Resolve(0x7f0000001000) = %q, %t, expected main.main", name, ok)
+	}
+	if name, ok := s.Resolve(0x1000); ok {
+		t.Errorf("Resolve(0x1000) = %q, expected no function", name)
+	}
+}
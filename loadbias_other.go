@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package pprofetheus
+
+// loadBias returns the difference between the addresses of the binary at
+// path, which is the binary of the current process, at runtime and the
+// addresses it was linked at. Only Linux exposes the load address, so it is
+// assumed to be the link address elsewhere.
+func loadBias(path string) (uint64, error) {
+	return 0, nil
+}
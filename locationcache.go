@@ -0,0 +1,69 @@
+package pprofetheus
+
+import (
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// locationCache keeps the functions of profile locations between profiles,
+// so that the names of the same addresses aren't resolved, filtered and
+// transformed again for every profile. Only locations without function
+// information of their own are cached by address, as the IDs of locations
+// differ between profiles. It is not safe for concurrent use.
+type locationCache struct {
+	functions map[uint64]locationFunctions
+	// result is the map returned by mapLocations, which is reused for the
+	// next profile.
+	result map[uint64]locationFunctions
+}
+
+func newLocationCache() *locationCache {
+	return &locationCache{
+		functions: make(map[uint64]locationFunctions),
+		result:    make(map[uint64]locationFunctions),
+	}
+}
+
+// mapLocations resolves the locations to the functions at them like
+// mapLocations. The returned map is only valid until the next call.
+func (c *locationCache) mapLocations(locations []*profile.Location, symbolizer Symbolizer, o *options) map[uint64]locationFunctions {
+	for id := range c.result {
+		delete(c.result, id)
+	}
+
+	for _, l := range locations {
+		if hasFunctions(l) {
+			c.result[l.ID] = mapLocation(l, symbolizer, o)
+			continue
+		}
+		f, ok := c.functions[l.Address]
+		if !ok {
+			f = mapLocation(l, symbolizer, o)
+			c.functions[l.Address] = f
+		} else if sc, ok := symbolizer.(*symbolCache); ok {
+			// the address would have been answered by the symbol cache,
+			// which keeps its hit ratio meaningful.
+			sc.hits++
+		}
+		c.result[l.ID] = f
+	}
+	return c.result
+}
+
+// invalidate forgets the cached functions, e.g. because the options that
+// select and name them or the symbols have changed.
+func (c *locationCache) invalidate() {
+	for addr := range c.functions {
+		delete(c.functions, addr)
+	}
+}
+
+// hasFunctions returns true if the profile contains the functions at the
+// location l.
+func hasFunctions(l *profile.Location) bool {
+	for _, line := range l.Line {
+		if line.Function != nil {
+			return true
+		}
+	}
+	return false
+}
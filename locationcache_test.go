@@ -0,0 +1,86 @@
+package pprofetheus
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func TestLocationCache(t *testing.T) {
+	symbolizer := &countingSymbolizer{Symbolizer: fakeSymbolizer{
+		0x1000: "main.main",
+		0x2000: "main.handle",
+	}}
+	o := newOptions(nil)
+	cache := newLocationCache()
+
+	// the IDs of the locations differ between the profiles.
+	for i := uint64(0); i < 3; i++ {
+		locations := []*profile.Location{{ID: 2*i + 1, Address: 0x1000}, {ID: 2*i + 2, Address: 0x2000}}
+		functions := cache.mapLocations(locations, symbolizer, o)
+		if len(functions) != 2 || functions[2*i+1].innermost.Function != "main.main" || functions[2*i+2].innermost.Function != "main.handle" {
+			t.Errorf("%d. functions = %v", i, functions)
+		}
+	}
+	if symbolizer.lookups != 2 {
+		t.Errorf("%d lookups were passed on, expected 2", symbolizer.lookups)
+	}
+
+	// locations with functions of their own aren't cached.
+	f := &profile.Function{ID: 1, Name: "main.other"}
+	l := &profile.Location{ID: 1, Address: 0x3000, Line: []profile.Line{{Function: f}}}
+	cache.mapLocations([]*profile.Location{l}, symbolizer, o)
+	if _, ok := cache.functions[0x3000]; ok {
+		t.Error("location with function information was cached")
+	}
+}
+
+func TestCPUProfileCollectorLocationCacheInvalidation(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.handle", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	p := testProfile(t, symbols, []string{"main.handle", "main.main"})
+
+	c.addProfile(p)
+	c.SetFunctionFilter(nil, regexp.MustCompile(`^main\.handle$`))
+	c.addProfile(p)
+
+	// the second profile is accounted with the new filter.
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.handle")); value != 10 {
+		t.Errorf("time used by main.handle = %f, expected 10", value)
+	}
+	if value := counterValue(t, c.timeUsed.WithLabelValues(otherFunction)); value != 10 {
+		t.Errorf("time used by %s = %f, expected 10", otherFunction, value)
+	}
+}
+
+func BenchmarkCPUProfileCollectorAddProfile(b *testing.B) {
+	var symbols []Symbol
+	var stacks [][]string
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("main.f%d", i)
+		symbols = append(symbols, Symbol{Name: name, Addr: 0x1000 + uint64(i)*0x100, Size: 0x100, Code: 'T'})
+		stacks = append(stacks, []string{name, "main.f0"})
+	}
+	p := testProfile(b, symbols, stacks...)
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols))
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.addProfile(p)
+	}
+}
@@ -0,0 +1,28 @@
+package pprofetheus
+
+import (
+	"debug/macho"
+	"strings"
+
+	"github.com/travelaudience/pprofetheus/internal/objfile"
+)
+
+// isMachO returns true if the file at path is a Mach-O binary, as used on
+// macOS.
+func isMachO(path string) bool {
+	f, err := macho.Open(path)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// trimMachOPrefix removes the underscore that the linker prefixes the names of
+// all symbols of Mach-O binaries with, Go functions included, so that the
+// names match the ones on other platforms.
+func trimMachOPrefix(symbols []objfile.Sym) {
+	for i := range symbols {
+		symbols[i].Name = strings.TrimPrefix(symbols[i].Name, "_")
+	}
+}
@@ -0,0 +1,34 @@
+package pprofetheus
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestTrimMachOPrefix(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "_main.main"},
+		{Name: "_runtime.goexit.abi0"},
+		{Name: "__cgo_topofstack"},
+		{Name: "_malloc"},
+	}
+	trimMachOPrefix(symbols)
+
+	for i, expected := range []string{"main.main", "runtime.goexit.abi0", "_cgo_topofstack", "malloc"} {
+		if symbols[i].Name != expected {
+			t.Errorf("symbol name = %q, expected %q", symbols[i].Name, expected)
+		}
+	}
+}
+
+func TestIsMachO(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := runtime.GOOS == "darwin" || runtime.GOOS == "ios"
+	if actual := isMachO(exe); actual != expected {
+		t.Errorf("isMachO(%s) = %t, expected %t", exe, actual, expected)
+	}
+}
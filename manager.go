@@ -0,0 +1,104 @@
+package pprofetheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultBlockProfileRate     = 10000
+	defaultMutexProfileFraction = 100
+)
+
+// WithBlockProfileRate sets the rate that a Manager enables the block profile
+// with, see NewBlockProfileCollector. It is 10000 by default, so that on
+// average one blocking event per 10µs spent blocked is sampled.
+func WithBlockProfileRate(rate int) Option {
+	return func(o *options) {
+		o.blockProfileRate = rate
+	}
+}
+
+// WithMutexProfileFraction sets the fraction that a Manager enables the mutex
+// profile with, see NewMutexProfileCollector. It is 100 by default, so that on
+// average 1% of the contention events are sampled.
+func WithMutexProfileFraction(fraction int) Option {
+	return func(o *options) {
+		o.mutexProfileFraction = fraction
+	}
+}
+
+// Manager bundles the CPU, heap, goroutine, block and mutex profile
+// collectors of a program, so that they can be registered, started and
+// stopped together.
+type Manager struct {
+	cpu        ProfileCollector
+	collectors []prometheus.Collector
+}
+
+// NewManager creates the CPU, heap, goroutine, block and mutex profile
+// collectors with the same options. The symbols are read only once and
+// shared by the collectors.
+func NewManager(opts ...Option) (*Manager, error) {
+	o := newOptions(opts)
+
+	symbolizer, err := newSymbolizer(o)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts[:len(opts):len(opts)], withSharedSymbolizer(symbolizer))
+
+	cpu, err := NewCPUProfileCollector(opts...)
+	if err != nil {
+		return nil, err
+	}
+	heap, err := NewHeapProfileCollector(opts...)
+	if err != nil {
+		return nil, err
+	}
+	goroutine, err := NewGoroutineProfileCollector(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{
+		cpu: cpu,
+		collectors: []prometheus.Collector{
+			cpu,
+			heap,
+			goroutine,
+			NewBlockProfileCollector(o.blockProfileRate, opts...),
+			NewMutexProfileCollector(o.mutexProfileFraction, opts...),
+		},
+	}, nil
+}
+
+// CPUProfileCollector returns the CPU profile collector of the manager, e.g.
+// to flush it or to change its settings at runtime.
+func (m *Manager) CPUProfileCollector() ProfileCollector {
+	return m.cpu
+}
+
+// Register registers all collectors with reg. If one of them can't be
+// registered, the ones registered before are unregistered again.
+func (m *Manager) Register(reg prometheus.Registerer) error {
+	for i, c := range m.collectors {
+		if err := reg.Register(c); err != nil {
+			for _, c := range m.collectors[:i] {
+				reg.Unregister(c)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Start starts the CPU profile collector. The other collectors read their
+// profiles on every scrape and need no starting.
+func (m *Manager) Start() {
+	m.cpu.Start()
+}
+
+// Stop stops the CPU profile collector.
+func (m *Manager) Stop() {
+	m.cpu.Stop()
+}
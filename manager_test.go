@@ -0,0 +1,66 @@
+package pprofetheus
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	m, err := NewManager(WithSymbols([]Symbol{}), WithBlockProfileRate(0), WithMutexProfileFraction(0), WithDrainInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestManager(t *testing.T) {
+	m := newTestManager(t)
+	prof := &fakeProfiler{}
+	m.CPUProfileCollector().(*cpuProfileCollector).opts.profiler = prof
+
+	reg := prometheus.NewRegistry()
+	if err := m.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	if !prof.running {
+		t.Error("CPU profiler not running after Start")
+	}
+	m.Stop()
+	if prof.running || prof.stops != 1 {
+		t.Errorf("CPU profiler running = %t with %d stops after Stop, expected 1 stop", prof.running, prof.stops)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	for _, name := range []string{"pprof_cpu_started", "pprof_goroutine_count", "pprof_heap_inuse_bytes"} {
+		if !names[name] {
+			t.Errorf("metric %s not exported", name)
+		}
+	}
+}
+
+func TestManagerRegisterConflict(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	goroutines, err := NewGoroutineProfileCollector(WithSymbols([]Symbol{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.MustRegister(goroutines)
+
+	m := newTestManager(t)
+	if err := m.Register(reg); err == nil {
+		t.Fatal("Register succeeded with a conflicting goroutine profile collector")
+	}
+	// the CPU and heap profile collectors have been unregistered again.
+	if err := reg.Register(m.CPUProfileCollector()); err != nil {
+		t.Errorf("registering the CPU profile collector after the failure failed: %v", err)
+	}
+}
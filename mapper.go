@@ -0,0 +1,30 @@
+package pprofetheus
+
+// Frame is a function at a profile location that a sample is accounted to.
+type Frame struct {
+	// Function is the name of the function, after WithTrimPrefix and
+	// WithAggregation have been applied.
+	Function string
+	// File and Line are the source position of the location within the
+	// function. They are only known if the profile contains line information.
+	File string
+	Line int64
+}
+
+// LabelMapper returns the label value that the frame is accounted to, and
+// false if it shall be dropped. It is called for the locations of every
+// profile, so it should be cheap.
+type LabelMapper func(frame Frame) (labelValue string, keep bool)
+
+// WithLabelMapper sets the LabelMapper that rewrites function names before
+// they become label values, e.g. to strip instantiations of generic
+// functions, to collapse vendored paths or to map packages to teams. Frames
+// that are mapped to the same label value are summed up in the same series,
+// and a sample is accounted once per label value in the cumulated metrics.
+// The filters of WithModulePrefix, WithAddressRange and WithFunctionFilter are
+// applied before, on the original function names.
+func WithLabelMapper(mapper LabelMapper) Option {
+	return func(o *options) {
+		o.labelMapper = mapper
+	}
+}
@@ -0,0 +1,59 @@
+package pprofetheus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCPUProfileCollectorLabelMapper(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "github.com/example/billing.charge", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "github.com/example/billing.refund", Addr: 0x1200, Size: 0x100, Code: 'T'},
+		{Name: "runtime.mallocgc", Addr: 0x1300, Size: 0x100, Code: 'T'},
+	}
+
+	var frames []Frame
+	mapper := func(frame Frame) (string, bool) {
+		frames = append(frames, frame)
+		switch {
+		case strings.HasPrefix(frame.Function, "billing."):
+			return "team-payments", true
+		case strings.HasPrefix(frame.Function, "runtime."):
+			return "", false
+		}
+		return frame.Function, true
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithTrimPrefix("github.com/example/"), WithLabelMapper(mapper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.addProfile(testProfile(t, symbols,
+		[]string{"runtime.mallocgc", "github.com/example/billing.refund", "github.com/example/billing.charge", "main.main"},
+		[]string{"github.com/example/billing.charge", "main.main"},
+	))
+
+	if len(frames) != len(symbols) {
+		t.Errorf("mapper was called for %d frames, expected %d", len(frames), len(symbols))
+	}
+	for _, frame := range frames {
+		if strings.HasPrefix(frame.Function, "github.com/") {
+			t.Errorf("mapper was called with untrimmed function %q", frame.Function)
+		}
+	}
+
+	if value := counterValue(t, c.timeUsed.WithLabelValues("team-payments")); value != 10 {
+		t.Errorf("time used by team-payments = %f, expected 10", value)
+	}
+	// the first sample is only accounted once to team-payments.
+	if value := counterValue(t, c.timeUsedCum.WithLabelValues("team-payments")); value != 20 {
+		t.Errorf("cumulated time used by team-payments = %f, expected 20", value)
+	}
+	for _, m := range collectMetrics(c.timeUsedCum) {
+		if fn, _ := functionLabel(t, m); strings.HasPrefix(fn, "runtime.") {
+			t.Errorf("dropped function %q was accounted", fn)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package pprofetheus
+
+import (
+	"path/filepath"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// mappingRefresher is implemented by the Symbolizers that pick up the objects
+// mapped into the current process after they have been created.
+type mappingRefresher interface {
+	// refreshMappings updates the Symbolizer if any of the mapped files is
+	// unknown to it, and returns true if it did.
+	refreshMappings(files []string) bool
+}
+
+// refreshMappings passes the files mapped by a profile on to symbolizer if it
+// is a mappingRefresher, and returns true if it has been updated.
+func refreshMappings(symbolizer Symbolizer, files []string) bool {
+	if r, ok := symbolizer.(mappingRefresher); ok {
+		return r.refreshMappings(files)
+	}
+	return false
+}
+
+// mappedFiles returns the files that the mappings of the profile p refer to.
+func mappedFiles(p *profile.Profile) []string {
+	var files []string
+	for _, m := range p.Mapping {
+		if filepath.IsAbs(m.File) {
+			files = append(files, m.File)
+		}
+	}
+	return files
+}
+
+func (s biasedSymbolizer) refreshMappings(files []string) bool {
+	return refreshMappings(s.symbolizer, files)
+}
+
+func (s fallbackSymbolizer) refreshMappings(files []string) bool {
+	refreshed := false
+	for _, symbolizer := range s {
+		if refreshMappings(symbolizer, files) {
+			refreshed = true
+		}
+	}
+	return refreshed
+}
+
+// refreshMappings forgets the addresses that couldn't be resolved if the
+// symbolizer has been updated, as they may be resolvable now.
+func (c *symbolCache) refreshMappings(files []string) bool {
+	if !refreshMappings(c.symbolizer, files) {
+		return false
+	}
+	for addr, n := range c.names {
+		if !n.ok {
+			delete(c.names, addr)
+			c.bytes -= cacheEntryBytes
+		}
+	}
+	return true
+}
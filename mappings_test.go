@@ -0,0 +1,56 @@
+package pprofetheus
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// refreshingSymbolizer resolves a single address once it has been refreshed.
+type refreshingSymbolizer struct {
+	refreshed bool
+}
+
+func (s *refreshingSymbolizer) Resolve(addr uint64) (string, bool) {
+	if !s.refreshed || addr != 0x1000 {
+		return "", false
+	}
+	return "plugin.f", true
+}
+
+func (s *refreshingSymbolizer) refreshMappings(files []string) bool {
+	s.refreshed = true
+	return true
+}
+
+func TestSymbolCacheRefreshMappings(t *testing.T) {
+	symbolizer := &refreshingSymbolizer{}
+	c := newSymbolCache(fallbackSymbolizer{symbolTable{}, biasedSymbolizer{symbolizer: symbolizer}})
+	if _, ok := c.Resolve(0x1000); ok {
+		t.Fatal("address resolved before the refresh")
+	}
+
+	if !refreshMappings(c, []string{"/usr/lib/plugin.so"}) {
+		t.Fatal("refresh wasn't passed on")
+	}
+	// the failed lookup has been forgotten.
+	if name, ok := c.Resolve(0x1000); !ok || name != "plugin.f" {
+		t.Errorf("Resolve(0x1000) = %q, %t after the refresh, expected plugin.f", name, ok)
+	}
+	if refreshMappings(symbolTable{}, nil) {
+		t.Error("symbol table refreshed")
+	}
+}
+
+func TestMappedFiles(t *testing.T) {
+	p := &profile.Profile{Mapping: []*profile.Mapping{
+		{File: "/usr/bin/app"},
+		{File: "[vdso]"},
+		{File: ""},
+		{File: "/usr/lib/plugin.so"},
+	}}
+	if files, expected := mappedFiles(p), []string{"/usr/bin/app", "/usr/lib/plugin.so"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("mapped files = %v, expected %v", files, expected)
+	}
+}
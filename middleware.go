@@ -0,0 +1,26 @@
+package pprofetheus
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+)
+
+// HandlerLabel is the key of the profiler label that HTTPMiddleware sets, and
+// the name of the label of the metric exported with WithHandlerMetric.
+const HandlerLabel = "handler"
+
+// HTTPMiddleware returns a middleware that runs the wrapped handler with the
+// profiler label "handler" set to handlerName, so that the CPU time it uses is
+// attributed to it by WithHandlerMetric or WithProfileLabels(HandlerLabel).
+// The label is inherited by the goroutines that the handler starts.
+func HTTPMiddleware(handlerName string) func(http.Handler) http.Handler {
+	labels := pprof.Labels(HandlerLabel, handlerName)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pprof.Do(r.Context(), labels, func(ctx context.Context) {
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+	}
+}
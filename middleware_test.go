@@ -0,0 +1,46 @@
+package pprofetheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	var handler string
+	h := HTTPMiddleware("search")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, _ = pprof.Label(r.Context(), HandlerLabel)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search", nil))
+
+	if handler != "search" {
+		t.Errorf("handler label = %q, expected search", handler)
+	}
+}
+
+func TestCPUProfileCollectorHandlerMetric(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.search", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithHandlerMetric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	p := testProfile(t, symbols, []string{"main.search"}, []string{"main.main"}, []string{"main.main"})
+	p.Sample[0].Label = map[string][]string{HandlerLabel: {"search"}}
+	p.Sample[1].Label = map[string][]string{HandlerLabel: {"search"}}
+	c.addProfile(p)
+
+	metrics := collectMetrics(c.handlerTime)
+	if len(metrics) != 1 {
+		t.Fatalf("got %d handler series, expected 1", len(metrics))
+	}
+	if value := counterValue(t, c.handlerTime.WithLabelValues("search")); value != 20 {
+		t.Errorf("time used by handler search = %f, expected 20", value)
+	}
+}
@@ -0,0 +1,26 @@
+package pprofetheus
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewMutexProfileCollector creates a collector that exports the time that
+// goroutines waited for contended mutexes in milliseconds by the function
+// that held the lock, as recorded by the runtime's mutex profile. It enables
+// the mutex profile with runtime.SetMutexProfileFraction(fraction), so that on
+// average 1/fraction of the contention events are sampled.
+func NewMutexProfileCollector(fraction int, opts ...Option) prometheus.Collector {
+	runtime.SetMutexProfileFraction(fraction)
+
+	return newRuntimeProfileCollector(lookupProfile("mutex"), mutexProfileMetric, newOptions(opts))
+}
+
+var mutexProfileMetric = runtimeProfileMetric{
+	subsystem: "mutex",
+	name:      "wait_time_ms",
+	help:      "time spent waiting for contended mutexes in milliseconds",
+	valueType: "delay",
+	divisor:   nanoToMilliDivisor,
+}
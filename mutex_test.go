@@ -0,0 +1,45 @@
+package pprofetheus
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMutexProfileCollector(t *testing.T) {
+	c := NewMutexProfileCollector(1)
+	defer runtime.SetMutexProfileFraction(0)
+
+	var mu sync.Mutex
+	locked := make(chan struct{})
+	go func() {
+		holdLock(&mu, locked)
+	}()
+	<-locked
+	mu.Lock()
+	mu.Unlock()
+
+	// the lock holder's Unlock may be inlined into holdLock, so look for the
+	// goroutine's function in the cumulated metric.
+	waitFor(t, func() bool {
+		for _, m := range collectMetrics(c) {
+			if fn, _ := functionLabel(t, m); strings.HasPrefix(fn, "github.com/travelaudience/pprofetheus.TestMutexProfileCollector.") && counterValue(t, m) > 0 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// holdLock holds the lock mu for a while. locked is closed once it has been
+// acquired.
+//
+//go:noinline
+func holdLock(mu *sync.Mutex, locked chan struct{}) {
+	mu.Lock()
+	close(locked)
+	time.Sleep(20 * time.Millisecond)
+	mu.Unlock()
+}
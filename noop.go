@@ -0,0 +1,86 @@
+package pprofetheus
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewCPUProfileCollectorOrNoop creates a new CPU profile collector like
+// NewCPUProfileCollector. If that fails, e.g. because the binary can't be read,
+// it returns a collector that does nothing except for exporting the gauge
+// pprof_cpu_disabled with the reason in its label "reason". This allows
+// applications to unconditionally register and start the collector.
+func NewCPUProfileCollectorOrNoop(opts ...Option) ProfileCollector {
+	c, err := NewCPUProfileCollector(opts...)
+	if err != nil {
+		return newNoopCollector(err, newOptions(opts))
+	}
+	return c
+}
+
+func newNoopCollector(reason error, o *options) *noopCollector {
+	return &noopCollector{
+		reason: reason,
+		disabled: prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, o.subsystemOr(cpuSubsystem), "disabled"),
+			o.help("disabled", "set to 1 if the CPU profile collector is disabled, with the reason in the label reason"),
+			[]string{"reason"}, o.constLabels,
+		),
+	}
+}
+
+type noopCollector struct {
+	reason   error
+	disabled *prometheus.Desc
+}
+
+func (c *noopCollector) Start() {}
+
+func (c *noopCollector) StartContext(ctx context.Context) {}
+
+func (c *noopCollector) StartFor(d time.Duration) {}
+
+func (c *noopCollector) Stop() {}
+
+func (c *noopCollector) Flush() {}
+
+func (c *noopCollector) EnableCumulative(enabled bool) {}
+
+func (c *noopCollector) EnableSampleCount(enabled bool) {}
+
+func (c *noopCollector) SetSampleRate(hz int) error {
+	return fmt.Errorf("collector is disabled: %v", c.reason)
+}
+
+func (c *noopCollector) SetFunctionFilter(include, exclude *regexp.Regexp) {}
+
+func (c *noopCollector) Reset() {}
+
+func (c *noopCollector) Snapshot() map[string]FunctionStats {
+	return map[string]FunctionStats{}
+}
+
+func (c *noopCollector) Profiles() []RecordedProfile {
+	return nil
+}
+
+func (c *noopCollector) Healthy() (bool, error) {
+	return false, fmt.Errorf("collector is disabled: %v", c.reason)
+}
+
+func (c *noopCollector) Ingest(r io.Reader) error {
+	return fmt.Errorf("collector is disabled: %v", c.reason)
+}
+
+func (c *noopCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.disabled
+}
+
+func (c *noopCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.disabled, prometheus.GaugeValue, 1, c.reason.Error())
+}
@@ -0,0 +1,55 @@
+package pprofetheus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewCPUProfileCollectorOrNoop(t *testing.T) {
+	collector := NewCPUProfileCollectorOrNoop(WithBinaryPath("/nonexistent"))
+
+	if _, ok := collector.(*noopCollector); !ok {
+		t.Fatalf("returned ProfileCollector is not a *noopCollector but %T", collector)
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.Start()
+	collector.Flush()
+	if healthy, err := collector.Healthy(); healthy || err == nil {
+		t.Errorf("Healthy() = (%t, %v), expected false with error", healthy, err)
+	}
+	if err := collector.Ingest(strings.NewReader("")); err == nil {
+		t.Errorf("Ingest succeeded")
+	}
+	collector.Stop()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetName() != "pprof_cpu_disabled" {
+		t.Fatalf("expected only pprof_cpu_disabled, got %v", families)
+	}
+
+	metric := families[0].GetMetric()[0]
+	if value := metric.GetGauge().GetValue(); value != 1 {
+		t.Errorf("pprof_cpu_disabled = %f, expected 1", value)
+	}
+	if len(metric.GetLabel()) != 1 || metric.GetLabel()[0].GetName() != "reason" || !strings.Contains(metric.GetLabel()[0].GetValue(), "/nonexistent") {
+		t.Errorf("unexpected labels %v", metric.GetLabel())
+	}
+}
+
+func TestNewCPUProfileCollectorOrNoopSuccess(t *testing.T) {
+	collector := NewCPUProfileCollectorOrNoop(WithSymbols([]Symbol{{Name: "main.main", Addr: 0x1000, Size: 0x100}}))
+
+	if _, ok := collector.(*cpuProfileCollector); !ok {
+		t.Fatalf("returned ProfileCollector is not a *cpuProfileCollector but %T", collector)
+	}
+}
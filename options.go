@@ -0,0 +1,755 @@
+package pprofetheus
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/objfile"
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	unknownMapping = "unknown"
+	unknownSource  = "unknown"
+	// defaultUnresolvedFormat is the format of the function labels of
+	// unresolved addresses.
+	defaultUnresolvedFormat = "unknown:%#x"
+)
+
+// Option configures a ProfileCollector created by NewCPUProfileCollector. The
+// collectors of the other profiles accept the options as well, where they
+// apply, e.g. those that select symbols and restrict or rename functions.
+type Option func(*options)
+
+type options struct {
+	namespace                string
+	subsystem                string
+	constLabels              prometheus.Labels
+	helpTexts                map[string]string
+	functionLabel            string
+	aggregation              Aggregation
+	addrStart                uint64
+	addrEnd                  uint64
+	modulePrefix             string
+	include                  *regexp.Regexp
+	exclude                  *regexp.Regexp
+	trimPrefix               string
+	labelMapper              LabelMapper
+	sampleLabels             []sampleLabel
+	exemplarKeys             []string
+	binaryPath               string
+	debugDir                 string
+	debuginfodURL            string
+	debuginfodCacheDir       string
+	symbols                  []objfile.Sym
+	symbolizer               Symbolizer
+	sharedSymbolizer         Symbolizer
+	runtimeSymbols           bool
+	lazySymbols              bool
+	compactSymbols           bool
+	unresolvedFormat         string
+	sampleRate               int
+	nonDefaultSampleRate     bool
+	drainInterval            time.Duration
+	scrapeCacheWindow        time.Duration
+	collectTimeout           time.Duration
+	dutyCycleOn              time.Duration
+	dutyCycleEvery           time.Duration
+	autoStart                bool
+	resetWhenStopped         bool
+	stackDepth               bool
+	edges                    bool
+	maxFunctions             int
+	minTimeMS                float64
+	minShare                 float64
+	seriesTTL                int
+	intervalGauges           bool
+	fraction                 bool
+	handlerMetric            bool
+	baseUnits                bool
+	histogramFunctions       []string
+	startStopMetricsDisabled bool
+	dumpDir                  string
+	dumpInterval             time.Duration
+	historyCount             int
+	historyMaxAge            time.Duration
+	pyroscope                *PyroscopeConfig
+	cloudProfiler            *CloudProfilerConfig
+	otlp                     *OTLPConfig
+	remoteWriteURL           string
+	remoteWriteInterval      time.Duration
+	exporters                []func(RecordedProfile)
+	blockProfileRate         int
+	mutexProfileFraction     int
+	registerer               prometheus.Registerer
+	httpClient               *http.Client
+	log                      Logger
+	errorHandler             func(error)
+	profiler                 profiler
+	clock                    clock
+}
+
+// sampleLabel describes an additional label of the time metrics whose value is
+// derived from a sample, one of its locations and a function at it.
+type sampleLabel struct {
+	name  string
+	value func(s *profile.Sample, l *profile.Location, f Frame) string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		namespace:            namespace,
+		debugDir:             defaultDebugDir,
+		runtimeSymbols:       defaultRuntimeSymbols,
+		unresolvedFormat:     defaultUnresolvedFormat,
+		sampleRate:           cpuProfileRate,
+		drainInterval:        defaultDrainInterval,
+		blockProfileRate:     defaultBlockProfileRate,
+		mutexProfileFraction: defaultMutexProfileFraction,
+		profiler:             newRuntimeProfiler(),
+		clock:                realClock{},
+		log:                  func(level, msg string, keyvals ...interface{}) {},
+		errorHandler:         func(error) {},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Symbol is a symbol of the profiled program that profile locations are
+// resolved to.
+type Symbol = objfile.Sym
+
+// WithNamespace sets the namespace of the exported metrics, which is "pprof"
+// by default.
+func WithNamespace(ns string) Option {
+	return func(o *options) {
+		o.namespace = ns
+	}
+}
+
+// WithSubsystem sets the subsystem of the exported metrics. By default, it
+// depends on the collector, e.g. "cpu" for NewCPUProfileCollector.
+func WithSubsystem(subsystem string) Option {
+	return func(o *options) {
+		o.subsystem = subsystem
+	}
+}
+
+// WithConstLabels adds the constant labels to all exported metrics, e.g. to
+// tell apart the metrics of several collectors or the service, version and
+// build of the profiled binary. Labels of repeated WithConstLabels options
+// are merged, with the later ones taking precedence.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) {
+		merged := make(prometheus.Labels, len(o.constLabels)+len(labels))
+		for name, value := range o.constLabels {
+			merged[name] = value
+		}
+		for name, value := range labels {
+			merged[name] = value
+		}
+		o.constLabels = merged
+	}
+}
+
+// WithHelpText sets the help text of the metric name, which is the name of the
+// metric without namespace and subsystem, e.g. "time_used_ms".
+func WithHelpText(name, help string) Option {
+	return func(o *options) {
+		if o.helpTexts == nil {
+			o.helpTexts = make(map[string]string)
+		}
+		o.helpTexts[name] = help
+	}
+}
+
+// WithFunctionLabel sets the name of the label that contains the function
+// name, which is "function" by default, or "package" with ByPackage
+// aggregation.
+func WithFunctionLabel(name string) Option {
+	return func(o *options) {
+		o.functionLabel = name
+	}
+}
+
+// WithBinaryPath sets the path of the binary that symbols are read from. By
+// default, the binary of the current process as returned by os.Executable is
+// used.
+func WithBinaryPath(path string) Option {
+	return func(o *options) {
+		o.binaryPath = path
+	}
+}
+
+// WithDebugDir sets the directory that separate debug files of stripped ELF
+// binaries are looked up in by build ID and debug link, which is
+// "/usr/lib/debug" by default.
+func WithDebugDir(dir string) Option {
+	return func(o *options) {
+		o.debugDir = dir
+	}
+}
+
+// WithDebuginfod makes the collector download the debug file of a stripped ELF
+// binary from the debuginfod server at serverURL by the binary's GNU build ID
+// if none is installed locally. Downloaded debug files are cached in cacheDir,
+// or in a pprofetheus directory below os.UserCacheDir if cacheDir is empty. The
+// client from WithHTTPClient is used if set.
+func WithDebuginfod(serverURL, cacheDir string) Option {
+	return func(o *options) {
+		o.debuginfodURL = serverURL
+		o.debuginfodCacheDir = cacheDir
+	}
+}
+
+// WithSymbols sets the symbols that profile locations are resolved to. No
+// binary is read at all in that case, which allows the collector to be used in
+// environments where the binary of the current process isn't accessible.
+// Explicitly provided symbols take precedence over WithBinaryPath.
+func WithSymbols(symbols []Symbol) Option {
+	return func(o *options) {
+		o.symbols = symbols
+	}
+}
+
+// WithSymbolizer sets the Symbolizer that profile locations are resolved with,
+// e.g. to resolve them using a remote symbol server. It takes precedence over
+// WithSymbols and WithBinaryPath.
+func WithSymbolizer(symbolizer Symbolizer) Option {
+	return func(o *options) {
+		o.symbolizer = symbolizer
+	}
+}
+
+// withSharedSymbolizer makes a collector resolve locations with the
+// symbolizer that was created from its options for other collectors, e.g. by
+// NewManager. Unlike WithSymbolizer, it doesn't count as a custom symbolizer.
+func withSharedSymbolizer(symbolizer Symbolizer) Option {
+	return func(o *options) {
+		o.sharedSymbolizer = symbolizer
+	}
+}
+
+// WithRuntimeSymbolizer resolves addresses using the runtime's function table
+// via runtime.FuncForPC, which is portable, names the innermost function of
+// inlined calls and doesn't require access to the binary. The symbols provided
+// with WithSymbols or, if it is readable, those of the binary are used as a
+// fallback for addresses unknown to the runtime, e.g. of cgo frames. As it
+// only knows the current process, it doesn't apply to remote processes.
+// WithSymbolizer takes precedence over it.
+func WithRuntimeSymbolizer() Option {
+	return func(o *options) {
+		o.runtimeSymbols = true
+	}
+}
+
+// WithUnresolvedFormat sets the format of the function label of addresses that
+// can't be resolved to a function, which is formatted with fmt.Sprintf and
+// the address. By default, it is "unknown:%#x", e.g. "unknown:0x4a2f10", which
+// keeps unrelated unresolved hot spots apart. A format without verbs, e.g.
+// "unknown", merges them into a single series.
+func WithUnresolvedFormat(format string) Option {
+	return func(o *options) {
+		o.unresolvedFormat = format
+	}
+}
+
+// WithAddressRange restricts the collector to locations whose address lies
+// within the address range [start, end). Samples in functions outside of the
+// range are dropped.
+func WithAddressRange(start, end uint64) Option {
+	return func(o *options) {
+		o.addrStart = start
+		o.addrEnd = end
+	}
+}
+
+// WithModulePrefix restricts the collector to functions whose symbol name starts
+// with the package path pkgPath, e.g. "github.com/example/project/". Samples in
+// all other functions are dropped.
+func WithModulePrefix(pkgPath string) Option {
+	return func(o *options) {
+		o.modulePrefix = pkgPath
+	}
+}
+
+// WithFunctionFilter restricts the collector to functions whose symbol name
+// matches include and doesn't match exclude, e.g. to only keep the functions
+// of "^github.com/example/" or to drop those of "^runtime\.". Either of them
+// may be nil. Samples in all other functions are dropped.
+func WithFunctionFilter(include, exclude *regexp.Regexp) Option {
+	return func(o *options) {
+		o.include = include
+		o.exclude = exclude
+	}
+}
+
+// WithTrimPrefix strips prefix from function names before they are used as
+// label values, e.g. "github.com/example/monorepo/" to shorten the names of a
+// large repository's functions. Names that don't start with prefix are left
+// unchanged. WithModulePrefix still matches the untrimmed names.
+func WithTrimPrefix(prefix string) Option {
+	return func(o *options) {
+		o.trimPrefix = prefix
+	}
+}
+
+// WithMappingLabel adds the label "mapping" to the time metrics that contains the
+// file of the mapping (i.e. the main binary or a shared library) that a location
+// belongs to. Locations without a known mapping are labeled "unknown".
+func WithMappingLabel() Option {
+	return func(o *options) {
+		o.sampleLabels = append(o.sampleLabels, sampleLabel{
+			name:  "mapping",
+			value: mappingFile,
+		})
+	}
+}
+
+func mappingFile(s *profile.Sample, l *profile.Location, f Frame) string {
+	if l.Mapping == nil || l.Mapping.File == "" {
+		return unknownMapping
+	}
+	return l.Mapping.File
+}
+
+// WithSourceLabels adds the label "file" to the time metrics that contains the
+// source file of the function, which tells apart identically named functions
+// of different packages or types in dashboards. Profiles without line
+// information, e.g. of bare addresses, are labeled "unknown". Building with
+// -trimpath shortens the file names to the import paths of their packages.
+func WithSourceLabels() Option {
+	return func(o *options) {
+		o.sampleLabels = append(o.sampleLabels, sampleLabel{
+			name:  "file",
+			value: sourceFile,
+		})
+	}
+}
+
+// WithLineLabel adds the label "line" to the time metrics that contains the
+// source line that a sample has been recorded at within the function. As it
+// multiplies the number of series by the number of lines that are sampled, it
+// should be used with care. Unknown lines are labeled "unknown".
+func WithLineLabel() Option {
+	return func(o *options) {
+		o.sampleLabels = append(o.sampleLabels, sampleLabel{
+			name:  "line",
+			value: sourceLine,
+		})
+	}
+}
+
+// WithProfileLabels adds a label to the time metrics for each of the keys of
+// the profiler labels that the program sets with runtime/pprof.Do or
+// pprof.SetGoroutineLabels, e.g. "tenant" or "handler", which attributes the
+// CPU time of the functions to them. The keys are used as label names and
+// thus have to be valid Prometheus label names. Samples without a key have an
+// empty value. Every distinct value multiplies the number of series, so keys
+// with many values, such as request IDs, must not be used.
+func WithProfileLabels(keys ...string) Option {
+	return func(o *options) {
+		for _, key := range keys {
+			o.sampleLabels = append(o.sampleLabels, sampleLabel{
+				name:  key,
+				value: profileLabel(key),
+			})
+		}
+	}
+}
+
+// profileLabel returns the value function of the label of the profiler label
+// key.
+func profileLabel(key string) func(s *profile.Sample, l *profile.Location, f Frame) string {
+	return func(s *profile.Sample, l *profile.Location, f Frame) string {
+		if values := s.Label[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+}
+
+func sourceFile(s *profile.Sample, l *profile.Location, f Frame) string {
+	if f.File == "" {
+		return unknownSource
+	}
+	return f.File
+}
+
+func sourceLine(s *profile.Sample, l *profile.Location, f Frame) string {
+	if f.Line <= 0 {
+		return unknownSource
+	}
+	return strconv.FormatInt(f.Line, 10)
+}
+
+// WithSampleRate sets the rate of the CPU profiler in samples per second, which
+// is 100 by default. Lower rates reduce the overhead of profiling, higher rates
+// increase the resolution, e.g. for debugging sessions. The rate is exported as
+// the label sample_rate_hz of pprof_collector_info. It can be changed at
+// runtime with SetSampleRate. Rates other than 100 require
+// WithNonDefaultSampleRate unless the profile data comes from a custom
+// profiler.
+func WithSampleRate(hz int) Option {
+	return func(o *options) {
+		o.sampleRate = hz
+	}
+}
+
+// WithNonDefaultSampleRate allows WithSampleRate and SetSampleRate to set rates
+// other than 100 samples per second for the runtime's CPU profiler. As
+// runtime/pprof only supports the default rate, the runtime then prints the
+// warning "cannot set cpu profile rate until previous profile has finished"
+// whenever the profiler is started, i.e. on every drain.
+func WithNonDefaultSampleRate() Option {
+	return func(o *options) {
+		o.nonDefaultSampleRate = true
+	}
+}
+
+// WithBroker makes the CPU profile collector receive its profile data from the
+// broker b instead of running the CPU profiler of the runtime itself, so that it
+// coexists with other consumers of b. While other consumers are running, the
+// sampling rate of the collector only applies if it started the profiler.
+func WithBroker(b *Broker) Option {
+	return func(o *options) {
+		o.profiler = &brokerProfiler{broker: b, opts: o}
+	}
+}
+
+// WithAutoStart makes the CPU profile collector start profiling as soon as it
+// is registered, so that Start doesn't have to be called. Registering it again,
+// e.g. with another registry, neither starts it a second time nor restarts it
+// after Stop.
+func WithAutoStart() Option {
+	return func(o *options) {
+		o.autoStart = true
+	}
+}
+
+// WithDutyCycle makes the CPU profile collector only profile for profileFor out
+// of every interval every, e.g. 10 seconds out of every 2 minutes, for
+// programs that can't afford to be profiled continuously. The metrics
+// accumulate across the profiling windows; in between, the collector only
+// reports what it has accumulated so far. profileFor must be shorter than
+// every.
+func WithDutyCycle(profileFor, every time.Duration) Option {
+	return func(o *options) {
+		o.dutyCycleOn = profileFor
+		o.dutyCycleEvery = every
+	}
+}
+
+// WithDrainInterval sets the interval at which the collector reads the
+// recorded profile data in the background while it is running, which is 10
+// seconds by default. Scrapes only report the metrics accumulated so far,
+// which keeps the toggling of the profiler and the parsing of the profile off
+// the scrape path and decouples the cost of profiling from the scrape
+// frequency. Flush can be used to read the profile data in between. An
+// interval of 0 reads the profile data on every scrape instead.
+func WithDrainInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.drainInterval = interval
+	}
+}
+
+// WithScrapeCache makes the CPU profile collector answer all scrapes within
+// window of a scrape with the metrics of that scrape, e.g. if several
+// Prometheus servers scrape the same process. They then get the same
+// consistent snapshot, and metrics that refer to the previous scrape, such as
+// the gauges of WithIntervalGauges, aren't split between them.
+func WithScrapeCache(window time.Duration) Option {
+	return func(o *options) {
+		o.scrapeCacheWindow = window
+	}
+}
+
+// WithCollectTimeout limits the time that a scrape of the CPU profile
+// collector waits for the profile data to be read and its metrics to be
+// collected. If that takes longer, e.g. because of a huge profile after a CPU
+// spike, the scrape reports the metrics of the previous scrape, and the
+// counter pprof_cpu_collections_timed_out_total is incremented. The collection
+// goes on in the background, and the next scrape reports its values.
+func WithCollectTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.collectTimeout = timeout
+	}
+}
+
+// WithResetWhenStopped makes the collector reset its time metrics when it is
+// scraped while stopped, instead of reporting the last values again.
+func WithResetWhenStopped() Option {
+	return func(o *options) {
+		o.resetWhenStopped = true
+	}
+}
+
+// WithStackDepthSummary makes the collector export the summary
+// pprof_cpu_stack_depth of the number of locations in the call stacks of the
+// profile samples, e.g. to judge the cost of the cumulated time metric.
+func WithStackDepthSummary() Option {
+	return func(o *options) {
+		o.stackDepth = true
+	}
+}
+
+// WithMaxFunctions limits the time and sample metrics of the CPU profile
+// collector to n series of their own. The first n functions that are sampled
+// get them, those with the most CPU time in a profile first, and keep them
+// until the series are deleted, e.g. by Reset or WithSeriesTTL. The time of
+// all other functions is summed up in the series labeled "_other_", so the
+// values of all series only increase.
+func WithMaxFunctions(n int) Option {
+	return func(o *options) {
+		o.maxFunctions = n
+	}
+}
+
+// WithMinTime drops functions that used less than min CPU time in a profile,
+// i.e. since the previous scrape or drain, from the time and sample metrics of
+// the CPU profile collector. The many functions that are only sampled rarely
+// are mostly noise, but expensive to store.
+func WithMinTime(min time.Duration) Option {
+	return func(o *options) {
+		o.minTimeMS = float64(min) / float64(time.Millisecond)
+	}
+}
+
+// WithMinShare drops functions that used less than the share, e.g. 0.001 for
+// 0.1%, of the total CPU time of a profile from the time and sample metrics of
+// the CPU profile collector, like WithMinTime. If both are set, functions have
+// to reach both thresholds.
+func WithMinShare(share float64) Option {
+	return func(o *options) {
+		o.minShare = share
+	}
+}
+
+// WithSeriesTTL makes the collector delete the series of functions that
+// haven't been sampled for the given number of profiles read from the
+// profiler, i.e. drains, which keeps the size of the scrape payload of
+// long-running processes bounded. Deleted series are created anew if a
+// function is sampled again.
+func WithSeriesTTL(profiles int) Option {
+	return func(o *options) {
+		o.seriesTTL = profiles
+	}
+}
+
+// WithIntervalGauges makes the CPU profile collector export the gauges
+// pprof_cpu_time_ms_interval and pprof_cpu_time_cum_ms_interval of the CPU
+// time used since the previous scrape instead of the counters of the total CPU
+// time, for dashboards that only show what is hot right now without rate()
+// over many series. As every scrape starts a new interval, the collector must
+// only be scraped by a single Prometheus server. WithMaxFunctions doesn't apply
+// to the gauges.
+func WithIntervalGauges() Option {
+	return func(o *options) {
+		o.intervalGauges = true
+	}
+}
+
+// WithFractionMetric makes the CPU profile collector export the gauge
+// pprof_cpu_fraction of the share of each function in the total CPU time of
+// the most recent profile, i.e. since the previous scrape or drain. Unlike the
+// CPU time, it doesn't depend on the load of the machine, so that alerting
+// thresholds apply to all instances of a program alike.
+func WithFractionMetric() Option {
+	return func(o *options) {
+		o.fraction = true
+	}
+}
+
+// WithHandlerMetric makes the CPU profile collector export the counter
+// pprof_cpu_handler_time_ms of the CPU time used by each HTTP handler wrapped
+// with HTTPMiddleware, labeled "handler", which tells the CPU cost of each
+// endpoint. Samples without the profiler label aren't accounted.
+func WithHandlerMetric() Option {
+	return func(o *options) {
+		o.handlerMetric = true
+	}
+}
+
+// WithEdgeMetric makes the collector export the counter pprof_cpu_edge_time_ms
+// of the CPU time used by each pair of adjacent functions in the call stacks,
+// labeled "caller" and "callee", from which call graphs can be rendered. The
+// number of its series grows with the number of distinct calls.
+func WithEdgeMetric() Option {
+	return func(o *options) {
+		o.edges = true
+	}
+}
+
+// WithFunctionHistogram makes the CPU profile collector export the native
+// histogram pprof_cpu_time_ms_distribution of the CPU time that each of the
+// functions used per profile, i.e. per scrape or drain, which shows the
+// variance of hot paths over time rather than just their total. The functions
+// are given by their label value, e.g. package names with ByPackage. Native
+// histograms are only scraped by Prometheus servers that have them enabled.
+func WithFunctionHistogram(functions ...string) Option {
+	return func(o *options) {
+		o.histogramFunctions = functions
+	}
+}
+
+// WithBaseUnits makes the collectors export their time metrics in seconds with
+// names that follow the Prometheus naming conventions, e.g.
+// pprof_cpu_time_used_seconds_total instead of pprof_cpu_time_used_ms. The
+// millisecond metrics remain the default for compatibility with existing
+// dashboards. Help texts set with WithHelpText apply to the new names.
+func WithBaseUnits() Option {
+	return func(o *options) {
+		o.baseUnits = true
+	}
+}
+
+// WithStartStopMetricsDisabled omits the counters pprof_cpu_started and
+// pprof_cpu_stopped entirely, for setups with a tight budget of series.
+func WithStartStopMetricsDisabled() Option {
+	return func(o *options) {
+		o.startStopMetricsDisabled = true
+	}
+}
+
+// WithProfileDump makes the collector write the recorded profile data as
+// symbolized pprof files to the directory dir every interval while it is
+// running. The directory is created if it doesn't exist yet.
+func WithProfileDump(dir string, interval time.Duration) Option {
+	return func(o *options) {
+		o.dumpDir = dir
+		o.dumpInterval = interval
+	}
+}
+
+// WithHTTPClient sets the HTTP client that a collector created by
+// NewRemoteCPUProfileCollector fetches profiles with. By default, a client with
+// a timeout of one minute is used. It is also used to download debug files
+// from the server set with WithDebuginfod.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// Log levels passed to a Logger.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Logger logs the message msg at the given level. keyvals contains
+// alternating keys and values with additional context, e.g. "err", err.
+type Logger func(level, msg string, keyvals ...interface{})
+
+// WithLogger sets the Logger that the collector reports parse errors, loaded
+// symbols, profiler conflicts, empty profiles and the like to. By default,
+// nothing is logged.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.log = logger
+	}
+}
+
+// WithErrorHandler sets a function that is called with the errors that keep a
+// collector from reading a profile during a scrape, e.g. if the profile can't
+// be parsed. The metrics that are still valid are exported regardless. The
+// handler is called with the collector's lock held and must not scrape it.
+func WithErrorHandler(handler func(err error)) Option {
+	return func(o *options) {
+		o.errorHandler = handler
+	}
+}
+
+// filtered returns true if any option restricts the set of symbols that
+// metrics are emitted for.
+func (o *options) filtered() bool {
+	return o.addrEnd > o.addrStart || o.modulePrefix != "" || o.include != nil || o.exclude != nil
+}
+
+// keep returns true if metrics for the function name at the address addr shall
+// be emitted.
+func (o *options) keep(name string, addr uint64) bool {
+	if o.addrEnd > o.addrStart && (addr < o.addrStart || addr >= o.addrEnd) {
+		return false
+	}
+	if o.modulePrefix != "" && !strings.HasPrefix(name, o.modulePrefix) {
+		return false
+	}
+	if o.include != nil && !o.include.MatchString(name) {
+		return false
+	}
+	if o.exclude != nil && o.exclude.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// unresolvedName returns the function label of the unresolved address addr.
+func (o *options) unresolvedName(addr uint64) string {
+	if !strings.Contains(o.unresolvedFormat, "%") {
+		return o.unresolvedFormat
+	}
+	return fmt.Sprintf(o.unresolvedFormat, addr)
+}
+
+// displayName returns the label value for the function name, i.e. the name or,
+// with ByPackage aggregation, its package without the prefix to be trimmed.
+func (o *options) displayName(name string) string {
+	if o.aggregation == ByPackage {
+		name = packageName(name)
+	}
+	return strings.TrimPrefix(name, o.trimPrefix)
+}
+
+// labelNames returns the label names of the time metrics.
+func (o *options) labelNames() []string {
+	names := o.functionLabels()
+	for _, sl := range o.sampleLabels {
+		names = append(names, sl.name)
+	}
+	return names
+}
+
+// functionLabels returns the label names of metrics that are only labeled by
+// function.
+func (o *options) functionLabels() []string {
+	name := o.functionLabel
+	if name == "" {
+		name = o.aggregation.labelName()
+	}
+	return []string{name}
+}
+
+// subsystemOr returns the configured subsystem, or the collector's default
+// subsystem def if none has been configured.
+func (o *options) subsystemOr(def string) string {
+	if o.subsystem != "" {
+		return o.subsystem
+	}
+	return def
+}
+
+// help returns the help text of the metric name, i.e. the configured one or
+// def.
+func (o *options) help(name, def string) string {
+	if help, ok := o.helpTexts[name]; ok {
+		return help
+	}
+	return def
+}
+
+// dynamicLabels returns true if the time metrics carry labels in addition to
+// the function name.
+func (o *options) dynamicLabels() bool {
+	return len(o.sampleLabels) > 0
+}
@@ -0,0 +1,387 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// defaultOTLPTimeout is the timeout for sending a profile to an OTLP
+	// endpoint.
+	defaultOTLPTimeout = 10 * time.Second
+	// otlpProfilesPath is the path of the OTLP/HTTP profiles receiver.
+	otlpProfilesPath = "/v1development/profiles"
+)
+
+// OTLPConfig configures sending profiles to an OpenTelemetry Protocol endpoint
+// with WithOTLPProfiles.
+type OTLPConfig struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver, e.g.
+	// "http://otel-collector:4318". The profiles are posted to its path
+	// /v1development/profiles.
+	Endpoint string
+	// ServiceName is the resource attribute service.name of the profiles,
+	// "unknown_service:" followed by the name of the executable unless set.
+	ServiceName string
+	// Attributes are added to the resource of the profiles, e.g.
+	// {"deployment.environment": "production"}.
+	Attributes map[string]string
+	// Headers are sent with every request, e.g. {"Authorization": "Bearer
+	// secret"}.
+	Headers map[string]string
+}
+
+// WithOTLPProfiles makes the collector send every profile that it reads to the
+// OTLP endpoint configured by cfg in addition to exporting the metrics, so
+// that an OpenTelemetry collector pipeline receives the same profiles as
+// Prometheus the metrics. The profiles are converted to the development
+// version of the OTLP profiles signal and sent as protobuf over HTTP in the
+// background, like WithPyroscope pushes them. Sample labels aren't converted.
+// Pushes and their errors are counted in pprof_cpu_otlp_pushes_total and
+// pprof_cpu_otlp_push_errors_total. The HTTP client set with WithHTTPClient is
+// used if any.
+func WithOTLPProfiles(cfg OTLPConfig) Option {
+	return func(o *options) {
+		o.otlp = &cfg
+	}
+}
+
+// otlpSender sends profiles to an OTLP endpoint.
+type otlpSender struct {
+	cfg    OTLPConfig
+	client *http.Client
+	// resource is the encoded resource of the profiles.
+	resource []byte
+}
+
+func newOTLPPusher(o *options) *profilePusher {
+	client := o.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultOTLPTimeout}
+	}
+	s := &otlpSender{
+		cfg:      *o.otlp,
+		client:   client,
+		resource: otlpResource(o.otlp),
+	}
+	return newProfilePusher(o, "otlp", "OTLP", s.send)
+}
+
+// validateOTLPConfig returns an error if cfg lacks required fields.
+func validateOTLPConfig(cfg *OTLPConfig) error {
+	if cfg.Endpoint == "" {
+		return fmt.Errorf("invalid OTLP config: an endpoint is required")
+	}
+	return nil
+}
+
+// send converts the profile data to an OTLP export request and sends it, see
+// profilePusher.send.
+func (s *otlpSender) send(from, until time.Time, hz int, data []byte) (string, error) {
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return reasonEncode, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return reasonEncode, err
+	}
+	body := otlpExportRequest(s.resource, p, from, until, id)
+
+	endpoint := strings.TrimSuffix(s.cfg.Endpoint, "/") + otlpProfilesPath
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return reasonRequest, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return reasonRequest, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return reasonStatus, fmt.Errorf("sending to %s failed: %s", endpoint, resp.Status)
+	}
+	return "", nil
+}
+
+// Field numbers of the messages of opentelemetry.proto.collector.profiles.
+// v1development and the messages that they refer to.
+const (
+	otlpRequestResourceProfiles = 1
+	otlpRequestDictionary       = 2
+
+	otlpResourceProfilesResource = 1
+	otlpResourceProfilesScope    = 2
+	otlpResourceAttributes       = 1
+	otlpKeyValueKey              = 1
+	otlpKeyValueValue            = 2
+	otlpAnyValueString           = 1
+
+	otlpScopeProfilesScope    = 1
+	otlpScopeProfilesProfiles = 2
+	otlpScopeName             = 1
+	otlpScopeVersion          = 2
+
+	otlpDictionaryMappings  = 1
+	otlpDictionaryLocations = 2
+	otlpDictionaryFunctions = 3
+	otlpDictionaryStrings   = 5
+
+	otlpProfileSampleType      = 1
+	otlpProfileSample          = 2
+	otlpProfileLocationIndices = 3
+	otlpProfileTime            = 4
+	otlpProfileDuration        = 5
+	otlpProfilePeriodType      = 6
+	otlpProfilePeriod          = 7
+	otlpProfileID              = 10
+
+	otlpValueTypeType = 1
+	otlpValueTypeUnit = 2
+
+	otlpSampleLocationsStart  = 1
+	otlpSampleLocationsLength = 2
+	otlpSampleValue           = 3
+
+	otlpMappingStart    = 1
+	otlpMappingLimit    = 2
+	otlpMappingOffset   = 3
+	otlpMappingFilename = 4
+
+	otlpLocationMapping = 1
+	otlpLocationAddress = 2
+	otlpLocationLine    = 3
+	otlpLocationFolded  = 4
+
+	otlpLineFunction = 1
+	otlpLineLine     = 2
+	otlpLineColumn   = 3
+
+	otlpFunctionName       = 1
+	otlpFunctionSystemName = 2
+	otlpFunctionFilename   = 3
+	otlpFunctionStartLine  = 4
+)
+
+// otlpResource returns the encoded resource of the profiles sent as
+// configured by cfg.
+func otlpResource(cfg *OTLPConfig) []byte {
+	attributes := map[string]string{"service.name": cfg.ServiceName}
+	if cfg.ServiceName == "" {
+		attributes["service.name"] = "unknown_service:" + filepath.Base(os.Args[0])
+	}
+	for k, v := range cfg.Attributes {
+		attributes[k] = v
+	}
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var resource []byte
+	for _, k := range keys {
+		var kv, value []byte
+		value = protowire.AppendTag(value, otlpAnyValueString, protowire.BytesType)
+		value = protowire.AppendString(value, attributes[k])
+		kv = protowire.AppendTag(kv, otlpKeyValueKey, protowire.BytesType)
+		kv = protowire.AppendString(kv, k)
+		kv = appendMessage(kv, otlpKeyValueValue, value)
+		resource = appendMessage(resource, otlpResourceAttributes, kv)
+	}
+	return resource
+}
+
+// otlpExportRequest converts the profile p, covering from until until, to an
+// encoded ExportProfilesServiceRequest with the encoded resource and the
+// profile ID id.
+func otlpExportRequest(resource []byte, p *profile.Profile, from, until time.Time, id []byte) []byte {
+	d := newOTLPDictionary()
+
+	var prof []byte
+	for _, t := range p.SampleType {
+		prof = appendMessage(prof, otlpProfileSampleType, d.valueType(t))
+	}
+	var indices []byte
+	n := 0
+	for _, s := range p.Sample {
+		var sample, values []byte
+		sample = appendVarint(sample, otlpSampleLocationsStart, uint64(n))
+		sample = appendVarint(sample, otlpSampleLocationsLength, uint64(len(s.Location)))
+		for _, v := range s.Value {
+			values = protowire.AppendVarint(values, uint64(v))
+		}
+		sample = appendMessage(sample, otlpSampleValue, values)
+		prof = appendMessage(prof, otlpProfileSample, sample)
+
+		for _, l := range s.Location {
+			indices = protowire.AppendVarint(indices, uint64(d.location(l)))
+			n++
+		}
+	}
+	prof = appendMessage(prof, otlpProfileLocationIndices, indices)
+	prof = appendVarint(prof, otlpProfileTime, uint64(from.UnixNano()))
+	prof = appendVarint(prof, otlpProfileDuration, uint64(until.Sub(from)))
+	if p.PeriodType != nil {
+		prof = appendMessage(prof, otlpProfilePeriodType, d.valueType(p.PeriodType))
+	}
+	prof = appendVarint(prof, otlpProfilePeriod, uint64(p.Period))
+	prof = protowire.AppendTag(prof, otlpProfileID, protowire.BytesType)
+	prof = protowire.AppendBytes(prof, id)
+
+	var scope, scopeProfiles, resourceProfiles, req []byte
+	scope = protowire.AppendTag(scope, otlpScopeName, protowire.BytesType)
+	scope = protowire.AppendString(scope, modulePath)
+	scope = protowire.AppendTag(scope, otlpScopeVersion, protowire.BytesType)
+	scope = protowire.AppendString(scope, collectorVersion())
+	scopeProfiles = appendMessage(scopeProfiles, otlpScopeProfilesScope, scope)
+	scopeProfiles = appendMessage(scopeProfiles, otlpScopeProfilesProfiles, prof)
+	resourceProfiles = appendMessage(resourceProfiles, otlpResourceProfilesResource, resource)
+	resourceProfiles = appendMessage(resourceProfiles, otlpResourceProfilesScope, scopeProfiles)
+	req = appendMessage(req, otlpRequestResourceProfiles, resourceProfiles)
+	return appendMessage(req, otlpRequestDictionary, d.encode())
+}
+
+// otlpDictionary builds the tables of an OTLP ProfilesDictionary, which the
+// profiles of a request refer to by index. The first entry of each table is
+// the zero value.
+type otlpDictionary struct {
+	mappings, locations, functions []byte
+	mappingIndex                   map[*profile.Mapping]int
+	locationIndex                  map[*profile.Location]int
+	functionIndex                  map[*profile.Function]int
+	strings                        []string
+	stringIndex                    map[string]int
+}
+
+func newOTLPDictionary() *otlpDictionary {
+	d := &otlpDictionary{
+		mappingIndex:  map[*profile.Mapping]int{nil: 0},
+		locationIndex: map[*profile.Location]int{nil: 0},
+		functionIndex: map[*profile.Function]int{nil: 0},
+		stringIndex:   make(map[string]int),
+	}
+	d.mappings = appendMessage(d.mappings, otlpDictionaryMappings, nil)
+	d.locations = appendMessage(d.locations, otlpDictionaryLocations, nil)
+	d.functions = appendMessage(d.functions, otlpDictionaryFunctions, nil)
+	d.str("")
+	return d
+}
+
+// encode returns the encoded ProfilesDictionary.
+func (d *otlpDictionary) encode() []byte {
+	b := append(append(append([]byte(nil), d.mappings...), d.locations...), d.functions...)
+	for _, s := range d.strings {
+		b = protowire.AppendTag(b, otlpDictionaryStrings, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return b
+}
+
+// str returns the index of s in the string table.
+func (d *otlpDictionary) str(s string) int {
+	i, ok := d.stringIndex[s]
+	if !ok {
+		i = len(d.strings)
+		d.strings = append(d.strings, s)
+		d.stringIndex[s] = i
+	}
+	return i
+}
+
+// valueType returns the encoded ValueType of t.
+func (d *otlpDictionary) valueType(t *profile.ValueType) []byte {
+	var b []byte
+	b = appendVarint(b, otlpValueTypeType, uint64(d.str(t.Type)))
+	return appendVarint(b, otlpValueTypeUnit, uint64(d.str(t.Unit)))
+}
+
+// mapping returns the index of m in the mapping table.
+func (d *otlpDictionary) mapping(m *profile.Mapping) int {
+	if i, ok := d.mappingIndex[m]; ok {
+		return i
+	}
+	var b []byte
+	b = appendVarint(b, otlpMappingStart, m.Start)
+	b = appendVarint(b, otlpMappingLimit, m.Limit)
+	b = appendVarint(b, otlpMappingOffset, m.Offset)
+	b = appendVarint(b, otlpMappingFilename, uint64(d.str(m.File)))
+	i := len(d.mappingIndex)
+	d.mappingIndex[m] = i
+	d.mappings = appendMessage(d.mappings, otlpDictionaryMappings, b)
+	return i
+}
+
+// location returns the index of l in the location table.
+func (d *otlpDictionary) location(l *profile.Location) int {
+	if i, ok := d.locationIndex[l]; ok {
+		return i
+	}
+	var b []byte
+	if l.Mapping != nil {
+		b = appendVarint(b, otlpLocationMapping, uint64(d.mapping(l.Mapping)))
+	}
+	b = appendVarint(b, otlpLocationAddress, l.Address)
+	for _, line := range l.Line {
+		var lb []byte
+		lb = appendVarint(lb, otlpLineFunction, uint64(d.function(line.Function)))
+		lb = appendVarint(lb, otlpLineLine, uint64(line.Line))
+		lb = appendVarint(lb, otlpLineColumn, uint64(line.Column))
+		b = appendMessage(b, otlpLocationLine, lb)
+	}
+	if l.IsFolded {
+		b = appendVarint(b, otlpLocationFolded, 1)
+	}
+	i := len(d.locationIndex)
+	d.locationIndex[l] = i
+	d.locations = appendMessage(d.locations, otlpDictionaryLocations, b)
+	return i
+}
+
+// function returns the index of f in the function table.
+func (d *otlpDictionary) function(f *profile.Function) int {
+	if i, ok := d.functionIndex[f]; ok {
+		return i
+	}
+	var b []byte
+	b = appendVarint(b, otlpFunctionName, uint64(d.str(f.Name)))
+	b = appendVarint(b, otlpFunctionSystemName, uint64(d.str(f.SystemName)))
+	b = appendVarint(b, otlpFunctionFilename, uint64(d.str(f.Filename)))
+	b = appendVarint(b, otlpFunctionStartLine, uint64(f.StartLine))
+	i := len(d.functionIndex)
+	d.functionIndex[f] = i
+	d.functions = appendMessage(d.functions, otlpDictionaryFunctions, b)
+	return i
+}
+
+// appendMessage appends the field num with the encoded message or packed
+// repeated field msg to b.
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// appendVarint appends the varint field num with the value v to b.
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
@@ -0,0 +1,308 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoFields calls f with the number and the value of every field of the
+// encoded message b, b for length-delimited fields and v for varints.
+func protoFields(t *testing.T, b []byte, f func(num protowire.Number, b []byte, v uint64)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+			}
+			f(num, v, 0)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+			}
+			f(num, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+}
+
+// packedVarints decodes a packed repeated varint field.
+func packedVarints(t *testing.T, b []byte) []uint64 {
+	var values []uint64
+	for len(b) > 0 {
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			t.Fatalf("invalid packed field: %v", protowire.ParseError(n))
+		}
+		values = append(values, v)
+		b = b[n:]
+	}
+	return values
+}
+
+// otlpTestSample is a sample of a decoded OTLP profile, with the function
+// names of its stack, leaf first.
+type otlpTestSample struct {
+	stack  []string
+	values []int64
+}
+
+// otlpTestRequest is the part of a decoded ExportProfilesServiceRequest that
+// the tests check.
+type otlpTestRequest struct {
+	attributes  map[string]string
+	scope       string
+	sampleTypes []string
+	samples     []otlpTestSample
+	time        int64
+	duration    int64
+	period      int64
+	id          []byte
+}
+
+// decodeOTLPRequest decodes the single profile of an
+// ExportProfilesServiceRequest, resolving its locations with the dictionary.
+func decodeOTLPRequest(t *testing.T, data []byte) otlpTestRequest {
+	req := otlpTestRequest{attributes: make(map[string]string)}
+	var profile, dictionary []byte
+	protoFields(t, data, func(num protowire.Number, b []byte, _ uint64) {
+		switch num {
+		case otlpRequestResourceProfiles:
+			protoFields(t, b, func(num protowire.Number, b []byte, _ uint64) {
+				switch num {
+				case otlpResourceProfilesResource:
+					protoFields(t, b, func(_ protowire.Number, kv []byte, _ uint64) {
+						var k, v string
+						protoFields(t, kv, func(num protowire.Number, b []byte, _ uint64) {
+							if num == otlpKeyValueKey {
+								k = string(b)
+								return
+							}
+							protoFields(t, b, func(_ protowire.Number, b []byte, _ uint64) {
+								v = string(b)
+							})
+						})
+						req.attributes[k] = v
+					})
+				case otlpResourceProfilesScope:
+					protoFields(t, b, func(num protowire.Number, b []byte, _ uint64) {
+						switch num {
+						case otlpScopeProfilesScope:
+							protoFields(t, b, func(num protowire.Number, b []byte, _ uint64) {
+								if num == otlpScopeName {
+									req.scope = string(b)
+								}
+							})
+						case otlpScopeProfilesProfiles:
+							profile = b
+						}
+					})
+				}
+			})
+		case otlpRequestDictionary:
+			dictionary = b
+		}
+	})
+
+	var strs []string
+	var locations, functions [][]byte
+	protoFields(t, dictionary, func(num protowire.Number, b []byte, _ uint64) {
+		switch num {
+		case otlpDictionaryLocations:
+			locations = append(locations, b)
+		case otlpDictionaryFunctions:
+			functions = append(functions, b)
+		case otlpDictionaryStrings:
+			strs = append(strs, string(b))
+		}
+	})
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatalf("string table %q doesn't start with the empty string", strs)
+	}
+	functionName := func(i uint64) string {
+		var name string
+		protoFields(t, functions[i], func(num protowire.Number, _ []byte, v uint64) {
+			if num == otlpFunctionName {
+				name = strs[v]
+			}
+		})
+		return name
+	}
+	locationName := func(i uint64) string {
+		var name string
+		protoFields(t, locations[i], func(num protowire.Number, b []byte, _ uint64) {
+			if num == otlpLocationLine {
+				protoFields(t, b, func(num protowire.Number, _ []byte, v uint64) {
+					if num == otlpLineFunction {
+						name = functionName(v)
+					}
+				})
+			}
+		})
+		return name
+	}
+
+	var indices []uint64
+	type sampleRange struct{ start, length uint64 }
+	var ranges []sampleRange
+	protoFields(t, profile, func(num protowire.Number, b []byte, v uint64) {
+		switch num {
+		case otlpProfileSampleType:
+			var typ, unit string
+			protoFields(t, b, func(num protowire.Number, _ []byte, v uint64) {
+				if num == otlpValueTypeType {
+					typ = strs[v]
+				} else {
+					unit = strs[v]
+				}
+			})
+			req.sampleTypes = append(req.sampleTypes, typ+"/"+unit)
+		case otlpProfileSample:
+			var r sampleRange
+			var s otlpTestSample
+			protoFields(t, b, func(num protowire.Number, b []byte, v uint64) {
+				switch num {
+				case otlpSampleLocationsStart:
+					r.start = v
+				case otlpSampleLocationsLength:
+					r.length = v
+				case otlpSampleValue:
+					for _, v := range packedVarints(t, b) {
+						s.values = append(s.values, int64(v))
+					}
+				}
+			})
+			ranges = append(ranges, r)
+			req.samples = append(req.samples, s)
+		case otlpProfileLocationIndices:
+			indices = packedVarints(t, b)
+		case otlpProfileTime:
+			req.time = int64(v)
+		case otlpProfileDuration:
+			req.duration = int64(v)
+		case otlpProfilePeriod:
+			req.period = int64(v)
+		case otlpProfileID:
+			req.id = b
+		}
+	})
+	for i, r := range ranges {
+		for _, l := range indices[r.start : r.start+r.length] {
+			req.samples[i].stack = append(req.samples[i].stack, locationName(l))
+		}
+	}
+	return req
+}
+
+func TestOTLPExportRequest(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.work", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+	p := testProfile(t, symbols, []string{"main.work", "main.main"}, []string{"main.main"})
+	symbolize(p, newSymbolTable(symbols))
+
+	from := time.Unix(1500000000, 0)
+	id := bytes.Repeat([]byte{1}, 16)
+	resource := otlpResource(&OTLPConfig{ServiceName: "checkout", Attributes: map[string]string{"deployment.environment": "production"}})
+	req := decodeOTLPRequest(t, otlpExportRequest(resource, p, from, from.Add(10*time.Second), id))
+
+	if expected := map[string]string{"service.name": "checkout", "deployment.environment": "production"}; !reflect.DeepEqual(req.attributes, expected) {
+		t.Errorf("resource attributes = %v, expected %v", req.attributes, expected)
+	}
+	if req.scope != modulePath {
+		t.Errorf("scope = %q, expected %q", req.scope, modulePath)
+	}
+	if expected := []string{"samples/count", "cpu/nanoseconds"}; !reflect.DeepEqual(req.sampleTypes, expected) {
+		t.Errorf("sample types = %v, expected %v", req.sampleTypes, expected)
+	}
+	expected := []otlpTestSample{
+		{stack: []string{"main.work", "main.main"}, values: []int64{1, 10000000}},
+		{stack: []string{"main.main"}, values: []int64{1, 10000000}},
+	}
+	if !reflect.DeepEqual(req.samples, expected) {
+		t.Errorf("samples = %v, expected %v", req.samples, expected)
+	}
+	if req.time != from.UnixNano() || req.duration != int64(10*time.Second) || req.period != 10000000 {
+		t.Errorf("time = %d, duration = %d, period = %d", req.time, req.duration, req.period)
+	}
+	if !bytes.Equal(req.id, id) {
+		t.Errorf("profile ID = %x, expected %x", req.id, id)
+	}
+}
+
+func TestCPUProfileCollectorOTLPProfiles(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	if _, err := NewCPUProfileCollector(WithSymbols(symbols), WithOTLPProfiles(OTLPConfig{})); err == nil {
+		t.Error("OTLP config without endpoint was accepted")
+	}
+
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading request failed: %v", err)
+		}
+		w.WriteHeader(status)
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	c, _ := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Unix(1500000000, 0)}, WithOTLPProfiles(OTLPConfig{
+		Endpoint:    server.URL + "/",
+		ServiceName: "checkout",
+		Headers:     map[string]string{"Authorization": "Bearer secret"},
+	}))
+
+	c.Start()
+	defer c.Stop()
+	c.Flush()
+
+	r := <-requests
+	if r.URL.Path != "/v1development/profiles" {
+		t.Errorf("path = %s, expected /v1development/profiles", r.URL.Path)
+	}
+	if typ := r.Header.Get("Content-Type"); typ != "application/x-protobuf" {
+		t.Errorf("content type = %q, expected application/x-protobuf", typ)
+	}
+	if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("authorization = %q, expected bearer token", auth)
+	}
+	// the profile has been symbolized before it was converted.
+	req := decodeOTLPRequest(t, <-bodies)
+	if len(req.samples) != 1 || !reflect.DeepEqual(req.samples[0].stack, []string{"main.main"}) {
+		t.Errorf("samples = %v, expected one of main.main", req.samples)
+	}
+	if name := req.attributes["service.name"]; name != "checkout" {
+		t.Errorf("service name = %q, expected checkout", name)
+	}
+	waitFor(t, func() bool { return counterValue(t, c.pushers[0].pushes) == 1 })
+
+	status = http.StatusBadRequest
+	c.Flush()
+	<-requests
+	<-bodies
+	waitFor(t, func() bool { return counterValue(t, c.pushers[0].errors.WithLabelValues(reasonStatus)) == 1 })
+}
@@ -20,9 +20,14 @@
 package pprofetheus
 
 import (
-	"bytes"
-	"runtime"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
 	"sync"
+	"time"
 
 	"github.com/travelaudience/pprofetheus/internal/objfile"
 	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
@@ -34,173 +39,1514 @@ const (
 	namespace          = "pprof"
 	cpuSubsystem       = "cpu"
 	cpuProfileRate     = 100
+	sampleRateLabel    = "sample_rate_hz"
 	nanoToMilliDivisor = 1000000
+	// defaultDrainInterval is the interval at which the CPU profile
+	// collector reads the recorded profile data in the background.
+	defaultDrainInterval = 10 * time.Second
+)
+
+const (
+	// cpuSampleType is the type of the sample values that contain CPU time.
+	cpuSampleType = "cpu"
+	// defaultCPUValueIndex is the index of the CPU time sample values in
+	// profiles that don't declare a sample type "cpu".
+	defaultCPUValueIndex = 1
+	// lostProfileEventFunction is the function that the Go runtime accounts
+	// the samples to that it dropped because its profile buffer overflowed.
+	lostProfileEventFunction = "runtime/pprof.lostProfileEvent"
+)
+
+const (
+	reasonNoLocation         = "no_location"
+	reasonInsufficientValues = "insufficient_values"
+	// reasonFiltered and reasonMinTime are the reasons of samples that are
+	// accounted to the series otherFunction instead of their function.
+	reasonFiltered = "filtered"
+	reasonMinTime  = "min_time"
 )
 
 var (
-	labelNames = []string{"function"}
+	// unitDivisors maps the units of profile sample values to the divisor
+	// that converts them to milliseconds.
+	unitDivisors = map[string]float64{
+		"nanoseconds":  nanoToMilliDivisor,
+		"microseconds": 1000,
+		"milliseconds": 1,
+		"seconds":      0.001,
+	}
 )
 
-// NewCPUProfileCollector creates a new CPU profile collector.
-func NewCPUProfileCollector() (ProfileCollector, error) {
-	exeFile, err := objfile.Open("/proc/self/exe")
+// NewCPUProfileCollector creates a new CPU profile collector. Its behaviour can
+// be adjusted by passing any number of Options.
+func NewCPUProfileCollector(opts ...Option) (ProfileCollector, error) {
+	o := newOptions(opts)
+	if err := o.checkSampleRate(o.sampleRate); err != nil {
+		return nil, err
+	}
+	if o.sampleRate != cpuProfileRate && usesRuntimeProfiler(o.profiler) {
+		o.log(LevelWarn, "the runtime prints a warning on every start of the CPU profiler at a non-default sampling rate", "hz", o.sampleRate)
+	}
+	if o.dutyCycleEvery != 0 && (o.dutyCycleOn <= 0 || o.dutyCycleOn >= o.dutyCycleEvery) {
+		return nil, fmt.Errorf("invalid duty cycle: profiling for %v every %v", o.dutyCycleOn, o.dutyCycleEvery)
+	}
+	if o.historyCount < 0 || o.historyMaxAge < 0 {
+		return nil, fmt.Errorf("invalid profile history: %d profiles of at most %v", o.historyCount, o.historyMaxAge)
+	}
+	if o.cloudProfiler != nil {
+		if err := validateCloudProfilerConfig(o.cloudProfiler); err != nil {
+			return nil, err
+		}
+	}
+	if o.otlp != nil {
+		if err := validateOTLPConfig(o.otlp); err != nil {
+			return nil, err
+		}
+	}
+
+	symbolizer, err := newSymbolizer(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCPUProfileCollector(symbolizer, o), nil
+}
+
+// newSymbolizer returns the Symbolizer selected by the options o.
+func newSymbolizer(o *options) (Symbolizer, error) {
+	if o.symbolizer != nil {
+		return o.symbolizer, nil
+	}
+	if o.sharedSymbolizer != nil {
+		return o.sharedSymbolizer, nil
+	}
+	if o.runtimeSymbols {
+		return newRuntimeSymbolizer(o), nil
+	}
+	if o.symbols != nil {
+		return newSymbolTable(o.symbols), nil
+	}
+	if symbolizer, ok := newLazyBinarySymbolizer(o); ok {
+		return symbolizer, nil
+	}
+
+	path, symbols, err := readBinarySymbols(o)
 	if err != nil {
+		o.log(LevelError, "reading symbols failed", "path", path, "err", err)
 		return nil, err
 	}
+	o.log(LevelInfo, "read symbols", "path", path, "symbols", len(symbols))
+	return newBinarySymbolizer(o, path, symbols), nil
+}
+
+// newRuntimeSymbolizer returns a runtimeSymbolizer that falls back to the
+// explicitly provided symbols or, if it is readable, the symbol table of the
+// binary, for addresses unknown to the runtime such as those of C functions.
+func newRuntimeSymbolizer(o *options) Symbolizer {
+	if o.symbols != nil {
+		return fallbackSymbolizer{runtimeSymbolizer{}, newSymbolTable(o.symbols)}
+	}
+	if symbolizer, ok := newLazyBinarySymbolizer(o); ok {
+		return fallbackSymbolizer{runtimeSymbolizer{}, symbolizer}
+	}
+
+	path, symbols, err := readBinarySymbols(o)
+	if err != nil {
+		o.log(LevelInfo, "reading fallback symbols failed", "path", path, "err", err)
+		return runtimeSymbolizer{}
+	}
+	o.log(LevelInfo, "read fallback symbols", "path", path, "symbols", len(symbols))
+	return fallbackSymbolizer{runtimeSymbolizer{}, newBinarySymbolizer(o, path, symbols)}
+}
+
+// readBinarySymbols reads the symbols of the binary set with WithBinaryPath or,
+// by default, of the binary of the current process as returned by
+// os.Executable, which works on all platforms. It also returns the path of
+// the binary.
+func readBinarySymbols(o *options) (string, []objfile.Sym, error) {
+	path, err := binaryPath(o)
+	if err != nil {
+		return "", nil, err
+	}
+
+	symbols, err := readSymbols(path)
+	if err != nil || len(symbols) == 0 {
+		// stripped binaries may come with a separate debug file.
+		if debugPath, ok := lookupDebugFile(o, path); ok {
+			o.log(LevelInfo, "reading symbols from debug file", "path", path, "debug_file", debugPath)
+			symbols, err = readSymbols(debugPath)
+		}
+	}
+	return path, symbols, err
+}
+
+// binaryPath returns the path of the binary set with WithBinaryPath or, by
+// default, of the binary of the current process.
+func binaryPath(o *options) (string, error) {
+	if o.binaryPath != "" {
+		return o.binaryPath, nil
+	}
+	return os.Executable()
+}
+
+// newBinarySymbolizer returns a symbol table of the symbols of the binary at
+// path, adjusted to the process like by relocatedSymbolizer.
+func newBinarySymbolizer(o *options, path string, symbols []objfile.Sym) Symbolizer {
+	return relocatedSymbolizer(o, path, newBinarySymbolTable(o, symbols))
+}
+
+// relocatedSymbolizer returns t, which resolves the addresses of the binary at
+// path. If that is the binary of the current process and it has been loaded at
+// a different address than the one it was linked at, as position-independent
+// executables are, the addresses are adjusted accordingly. For the current
+// process, the shared objects it has loaded are symbolized as well.
+func relocatedSymbolizer(o *options, path string, t Symbolizer) Symbolizer {
+	if !isCurrentExecutable(path) {
+		return t
+	}
+
+	var symbolizer Symbolizer = t
+	bias, err := loadBias(path)
+	switch {
+	case err != nil:
+		o.log(LevelWarn, "determining load address failed", "path", path, "err", err)
+	case bias != 0:
+		o.log(LevelInfo, "binary has been relocated", "path", path, "bias", bias)
+		symbolizer = biasedSymbolizer{symbolizer: t, bias: bias}
+	}
+
+	// addresses outside of the binary may belong to shared objects, e.g. C
+	// libraries called via cgo.
+	if sharedObjects := newSharedObjectSymbolizer(o, path); sharedObjects != nil {
+		return fallbackSymbolizer{symbolizer, sharedObjects}
+	}
+	return symbolizer
+}
+
+// isCurrentExecutable returns true if path is the binary of the current
+// process.
+func isCurrentExecutable(path string) bool {
+	exe, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	exeFi, err := os.Stat(exe)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, exeFi)
+}
+
+func readSymbols(path string) ([]objfile.Sym, error) {
+	exeFile, err := objfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer exeFile.Close()
 
 	symbols, err := exeFile.Symbols()
 	if err != nil {
 		return nil, err
 	}
+	if isMachO(path) {
+		trimMachOPrefix(symbols)
+	}
+	return symbols, nil
+}
+
+func newCPUProfileCollector(symbolizer Symbolizer, o *options) *cpuProfileCollector {
+	labelNames := o.labelNames()
 
-	return &cpuProfileCollector{
+	c := &cpuProfileCollector{
 		timeUsed: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Namespace: namespace,
-				Subsystem: cpuSubsystem,
-				Name:      "time_used_ms",
-				Help:      "CPU time used by function in milliseconds",
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        o.timeName("time_used_ms"),
+				Help:        o.help(o.timeName("time_used_ms"), o.timeHelp("CPU time used by function in milliseconds")),
+				ConstLabels: o.constLabels,
 			},
 			labelNames,
 		),
 		timeUsedCum: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Namespace: namespace,
-				Subsystem: cpuSubsystem,
-				Name:      "time_used_cum_ms",
-				Help:      "CPU time used by function in milliseconds (cumulated)",
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        o.timeName("time_used_cum_ms"),
+				Help:        o.help(o.timeName("time_used_cum_ms"), o.timeHelp("CPU time used by function in milliseconds (cumulated)")),
+				ConstLabels: o.constLabels,
+			},
+			labelNames,
+		),
+		intervalTime: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        o.timeName("time_ms_interval"),
+				Help:        o.help(o.timeName("time_ms_interval"), o.timeHelp("CPU time used by function in milliseconds since the previous scrape")),
+				ConstLabels: o.constLabels,
 			},
 			labelNames,
 		),
+		intervalTimeCum: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        o.timeName("time_cum_ms_interval"),
+				Help:        o.help(o.timeName("time_cum_ms_interval"), o.timeHelp("CPU time used by function in milliseconds since the previous scrape (cumulated)")),
+				ConstLabels: o.constLabels,
+			},
+			labelNames,
+		),
+		fraction: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "fraction",
+				Help:        o.help("fraction", "share of function in the CPU time of the most recent profile"),
+				ConstLabels: o.constLabels,
+			},
+			labelNames,
+		),
+		samples: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "samples_total",
+				Help:        o.help("samples_total", "number of CPU profile samples by function"),
+				ConstLabels: o.constLabels,
+			},
+			labelNames,
+		),
+		edgeTime: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        o.timeName("edge_time_ms"),
+				Help:        o.help(o.timeName("edge_time_ms"), o.timeHelp("CPU time used by callee when called by caller in milliseconds")),
+				ConstLabels: o.constLabels,
+			},
+			[]string{"caller", "callee"},
+		),
+		timeHistogram: newTimeHistogram(o),
+		handlerTime: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        o.timeName("handler_time_ms"),
+				Help:        o.help(o.timeName("handler_time_ms"), o.timeHelp("CPU time used by HTTP handler in milliseconds")),
+				ConstLabels: o.constLabels,
+			},
+			[]string{HandlerLabel},
+		),
 		started: prometheus.NewCounter(
 			prometheus.CounterOpts{
-				Namespace: namespace,
-				Subsystem: cpuSubsystem,
-				Name:      "started",
-				Help:      "counter of pprof start events in CPU profile collector",
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "started",
+				Help:        o.help("started", "counter of pprof start events in CPU profile collector"),
+				ConstLabels: o.constLabels,
 			},
 		),
 		stopped: prometheus.NewCounter(
 			prometheus.CounterOpts{
-				Namespace: namespace,
-				Subsystem: cpuSubsystem,
-				Name:      "stopped",
-				Help:      "counter of pprof stop events in CPU profile collector",
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "stopped",
+				Help:        o.help("stopped", "counter of pprof stop events in CPU profile collector"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		droppedSamples: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "dropped_samples_total",
+				Help:        o.help("dropped_samples_total", "counter of profile samples that were dropped because of missing data, or accounted to the function _other_ because of filters or thresholds"),
+				ConstLabels: o.constLabels,
+			},
+			[]string{"reason"},
+		),
+		collectDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "collect_duration_seconds",
+				Help:        o.help("collect_duration_seconds", "time spent by the CPU profile collector to process the profile during a scrape"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		stackDepth: prometheus.NewSummary(
+			prometheus.SummaryOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "stack_depth",
+				Help:        o.help("stack_depth", "number of locations in the call stacks of CPU profile samples"),
+				ConstLabels: o.constLabels,
+				Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			},
+		),
+		profileBytes: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "profile_bytes_total",
+				Help:        o.help("profile_bytes_total", "counter of bytes of profile data read from the runtime"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		processedSamples: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "processed_samples_total",
+				Help:        o.help("processed_samples_total", "counter of profile samples processed by the CPU profile collector"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		processedLocations: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "processed_locations_total",
+				Help:        o.help("processed_locations_total", "counter of profile locations resolved by the CPU profile collector"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		dumps: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "profile_dumps_total",
+				Help:        o.help("profile_dumps_total", "counter of profile files written by the CPU profile collector"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		dumpErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "profile_dump_errors_total",
+				Help:        o.help("profile_dump_errors_total", "counter of errors while writing profile files in the CPU profile collector"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		unitFallbacks: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "unit_fallbacks_total",
+				Help:        o.help("unit_fallbacks_total", "counter of profiles whose sample value unit was unrecognized and assumed to be nanoseconds"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		parseErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "parse_errors_total",
+				Help:        o.help("parse_errors_total", "counter of profiles that could not be parsed by the CPU profile collector"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		emptyProfiles: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "empty_profiles_total",
+				Help:        o.help("empty_profiles_total", "counter of profiles without any samples read by the CPU profile collector"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		samplingSaturation: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "sampling_saturation_ratio",
+				Help:        o.help("sampling_saturation_ratio", "ratio of the number of samples in the most recent profile to the number expected for its duration and the sampling rate"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		runningGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "running",
+				Help:        o.help("running", "1 if the CPU profile collector is running, 0 otherwise"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		collectionsTimedOut: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "collections_timed_out_total",
+				Help:        o.help("collections_timed_out_total", "counter of scrapes of the CPU profile collector that exceeded the collect timeout and reported the previous values"),
+				ConstLabels: o.constLabels,
 			},
 		),
-		symbols: symbols,
-	}, nil
+		symbolCacheHitRatio: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "symbol_cache_hit_ratio",
+				Help:        o.help("symbol_cache_hit_ratio", "ratio of address lookups answered by the symbol cache of the CPU profile collector"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		unresolvedSamples: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "unresolved_samples_total",
+				Help:        o.help("unresolved_samples_total", "counter of CPU profile samples whose innermost location couldn't be resolved to a function"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		lostSamples: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "lost_samples_total",
+				Help:        o.help("lost_samples_total", "counter of CPU profile samples that the runtime reported as lost because its profile buffer overflowed"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		symbolizerMemory: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "symbolizer_memory_bytes",
+				Help:        o.help("symbolizer_memory_bytes", "estimated memory held by the symbol tables and the symbol cache of the CPU profile collector in bytes"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		collectorInfo:     newCollectorInfo(o),
+		cumulativeEnabled: true,
+		symbolizer:        symbolizer,
+		symbolCache:       newSymbolCache(symbolizer),
+		locationCache:     newLocationCache(),
+		flatWindow:        newSeriesWindow(),
+		samplesWindow:     newSeriesWindow(),
+		cumWindow:         newSeriesWindow(),
+		recordsWindow:     newSeriesWindow(),
+		ttl:               newSeriesTTL(o.seriesTTL),
+		flatLimit:         newSeriesLimit(o.maxFunctions),
+		cumLimit:          newSeriesLimit(o.maxFunctions),
+		opts:              o,
+	}
+	if o.scrapeCacheWindow > 0 {
+		c.scrapeCache = &scrapeCache{window: o.scrapeCacheWindow}
+	}
+	if o.historyCount > 0 {
+		c.history = &profileHistory{count: o.historyCount, maxAge: o.historyMaxAge}
+	}
+	if o.pyroscope != nil {
+		c.pushers = append(c.pushers, newPyroscopePusher(o))
+	}
+	if o.cloudProfiler != nil {
+		c.pushers = append(c.pushers, newCloudProfilerPusher(o))
+	}
+	if o.otlp != nil {
+		c.pushers = append(c.pushers, newOTLPPusher(o))
+	}
+	if o.remoteWriteURL != "" {
+		c.remoteWriter = newRemoteWriter(o)
+	}
+	if o.startStopMetricsDisabled {
+		c.started = nil
+		c.stopped = nil
+	}
+	c.collectorInfo.set(o.sampleRate)
+	return c
 }
 
 // ProfileCollector describes a pprofetheus collector. It can act as a prometheus.Collector
-// plus it can be Start()ed and Stop()ed to limit profiling to only desired time periods.
+// plus it can be Start()ed and Stop()ed to limit profiling to only desired time periods,
+// or be started with StartContext() or StartFor() to stop automatically.
+// Profiles that have been captured elsewhere can be added to its metrics with Ingest(),
+// and Flush() adds the profile data recorded so far without waiting for a scrape.
+// Healthy() reports whether the collector is fully functional,
+// EnableCumulative() and EnableSampleCount() toggle individual metrics at runtime,
+// SetSampleRate() and SetFunctionFilter() change the settings of the profiler
+// without restarting the program, which AdminHandler() exposes over HTTP,
+// Reset() clears the accumulated metrics, e.g. between the phases of a test, and
+// Snapshot() returns them per function for programmatic use, and Profiles() returns
+// the raw profiles kept with WithProfileHistory(), which ProfileHistoryHandler() serves.
 type ProfileCollector interface {
 	prometheus.Collector
 	Start()
+	StartContext(ctx context.Context)
+	StartFor(d time.Duration)
 	Stop()
+	Healthy() (bool, error)
+	Flush()
+	Ingest(r io.Reader) error
+	EnableCumulative(enabled bool)
+	EnableSampleCount(enabled bool)
+	SetSampleRate(hz int) error
+	SetFunctionFilter(include, exclude *regexp.Regexp)
+	Reset()
+	Snapshot() map[string]FunctionStats
+	Profiles() []RecordedProfile
 }
 
 type cpuProfileCollector struct {
 	sync.Mutex
-	timeUsed    *prometheus.CounterVec
-	timeUsedCum *prometheus.CounterVec
-	started     prometheus.Counter
-	stopped     prometheus.Counter
-	running     bool
-	symbols     []objfile.Sym
+	timeUsed            *prometheus.CounterVec
+	timeUsedCum         *prometheus.CounterVec
+	intervalTime        *prometheus.GaugeVec
+	intervalTimeCum     *prometheus.GaugeVec
+	fraction            *prometheus.GaugeVec
+	samples             *prometheus.CounterVec
+	edgeTime            *prometheus.CounterVec
+	timeHistogram       *prometheus.HistogramVec
+	handlerTime         *prometheus.CounterVec
+	started             prometheus.Counter
+	stopped             prometheus.Counter
+	droppedSamples      *prometheus.CounterVec
+	collectDuration     prometheus.Histogram
+	stackDepth          prometheus.Summary
+	profileBytes        prometheus.Counter
+	processedSamples    prometheus.Counter
+	processedLocations  prometheus.Counter
+	dumps               prometheus.Counter
+	dumpErrors          prometheus.Counter
+	unitFallbacks       prometheus.Counter
+	parseErrors         prometheus.Counter
+	emptyProfiles       prometheus.Counter
+	samplingSaturation  prometheus.Gauge
+	runningGauge        prometheus.Gauge
+	collectorInfo       *collectorInfo
+	symbolCacheHitRatio prometheus.Gauge
+	symbolizerMemory    prometheus.Gauge
+	unresolvedSamples   prometheus.Counter
+	lostSamples         prometheus.Counter
+	running             bool
+	cumulativeEnabled   bool
+	samplesEnabled      bool
+	symbolizer          Symbolizer
+	symbolCache         *symbolCache
+	locationCache       *locationCache
+	// the windows are reused for every profile.
+	flatWindow    *seriesWindow
+	samplesWindow *seriesWindow
+	cumWindow     *seriesWindow
+	recordsWindow *seriesWindow
+	ttl           *seriesTTL
+	// flatLimit and cumLimit are the series of the flat and the cumulated
+	// metrics admitted with WithMaxFunctions.
+	flatLimit, cumLimit *seriesLimit
+	opts                *options
+	dump                *profile.Profile
+	stopBackground      chan struct{}
+	paused              bool
+	autoStarted         sync.Once
+	scrapeCache         *scrapeCache
+	history             *profileHistory
+	pushers             []*profilePusher
+	remoteWriter        *remoteWriter
+	collectionsTimedOut prometheus.Counter
+	// pendingCollection is closed once the collection that is in progress
+	// with a collect timeout has finished, and lastMetrics holds the
+	// metrics of the most recent one. Both are guarded by lastMetricsLock
+	// instead of the collector's lock, which the collection holds.
+	lastMetricsLock   sync.Mutex
+	pendingCollection chan struct{}
+	lastMetrics       []prometheus.Metric
+	profilerErr       error
+	parseErr          error
+	lastDrain         time.Time
+	profileRate       int
 }
 
 func (c *cpuProfileCollector) Start() {
 	c.Lock()
 	defer c.Unlock()
 
+	c.start()
+}
+
+// StartContext starts the collector like Start and stops it once ctx is done.
+// If the collector is already running, ctx stops the current run.
+func (c *cpuProfileCollector) StartContext(ctx context.Context) {
+	c.Lock()
+	stop := c.start()
+	c.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.stopRun(stop)
+		case <-stop:
+		}
+	}()
+}
+
+// StartFor starts the collector like Start and stops it after d. If the
+// collector is already running, the current run is stopped after d.
+func (c *cpuProfileCollector) StartFor(d time.Duration) {
+	c.Lock()
+	stop := c.start()
+	c.Unlock()
+
+	t := c.opts.clock.NewTicker(d)
+	go func() {
+		defer t.Stop()
+		select {
+		case <-t.Chan():
+			c.stopRun(stop)
+		case <-stop:
+		}
+	}()
+}
+
+// start starts profiling unless the collector is already running, and
+// returns the channel that is closed when the current run is stopped.
+func (c *cpuProfileCollector) start() chan struct{} {
 	if c.running {
-		return
+		return c.stopBackground
 	}
 	c.running = true
+	c.paused = false
 
-	runtime.SetCPUProfileRate(cpuProfileRate)
+	c.startProfiler()
+	c.lastDrain = c.opts.clock.Now()
 
-	c.started.Inc()
+	c.stopBackground = make(chan struct{})
+	if c.opts.dutyCycleEvery > 0 {
+		go c.runDutyCycle(c.stopBackground)
+	}
+	if c.opts.drainInterval > 0 {
+		go c.runPeriodically(c.opts.drainInterval, c.stopBackground, c.drain)
+	}
+	if c.opts.dumpDir != "" {
+		go c.runPeriodically(c.opts.dumpInterval, c.stopBackground, func() {
+			c.drain()
+			c.writeDump()
+		})
+	}
+	if c.remoteWriter != nil && c.opts.remoteWriteInterval > 0 {
+		go c.runPeriodically(c.opts.remoteWriteInterval, c.stopBackground, c.remoteWriteInBackground)
+	}
+
+	if c.started != nil {
+		c.started.Inc()
+	}
+	return c.stopBackground
 }
 
 func (c *cpuProfileCollector) Stop() {
 	c.Lock()
-	defer c.Unlock()
+	write := c.stop()
+	c.Unlock()
+
+	write()
+}
 
+// stopRun stops the collector if it is still in the run whose channel is stop,
+// i.e. it hasn't been stopped and started again in the meantime.
+func (c *cpuProfileCollector) stopRun(stop chan struct{}) {
+	c.Lock()
+	write := func() {}
+	if c.running && c.stopBackground == stop {
+		write = c.stop()
+	}
+	c.Unlock()
+
+	write()
+}
+
+// stop stops profiling and adds the remaining profile data to the collector's
+// metrics. It returns a function that sends the final metrics to the remote
+// write endpoint, which is to be called once the collector is unlocked.
+func (c *cpuProfileCollector) stop() func() {
 	if !c.running {
-		return
+		return func() {}
 	}
 	c.running = false
 
-	runtime.SetCPUProfileRate(0)
+	if !c.paused {
+		c.addData(c.opts.profiler.Stop())
+	}
+
+	close(c.stopBackground)
+	if c.opts.dumpDir != "" {
+		c.writeDump()
+	}
 
-	c.stopped.Inc()
+	if c.stopped != nil {
+		c.stopped.Inc()
+	}
+
+	if c.remoteWriter == nil {
+		return func() {}
+	}
+	metrics := c.remoteWriteMetrics()
+	now := c.opts.clock.Now()
+	return func() {
+		c.remoteWriter.write(now, metrics)
+	}
 }
 
 func (c *cpuProfileCollector) Describe(ch chan<- *prometheus.Desc) {
-	c.timeUsed.Describe(ch)
-	c.timeUsedCum.Describe(ch)
-	c.started.Describe(ch)
-	c.stopped.Describe(ch)
+	// registering the collector calls Describe, which starts it with
+	// WithAutoStart. Later calls, e.g. from registering it with another
+	// registry, don't start it again after it has been stopped.
+	if c.opts.autoStart {
+		c.autoStarted.Do(c.Start)
+	}
+
+	// With dynamic labels, the collector is registered as an unchecked
+	// collector by not sending any descriptors, so that the label sets
+	// emitted by Collect are not subject to the registry's consistency checks.
+	if c.opts.dynamicLabels() {
+		return
+	}
+
+	if c.opts.intervalGauges {
+		c.intervalTime.Describe(ch)
+		c.intervalTimeCum.Describe(ch)
+	} else {
+		c.timeUsed.Describe(ch)
+		c.timeUsedCum.Describe(ch)
+	}
+	c.samples.Describe(ch)
+	if c.opts.fraction {
+		c.fraction.Describe(ch)
+	}
+	if c.opts.edges {
+		c.edgeTime.Describe(ch)
+	}
+	if len(c.opts.histogramFunctions) > 0 {
+		c.timeHistogram.Describe(ch)
+	}
+	if c.opts.handlerMetric {
+		c.handlerTime.Describe(ch)
+	}
+	if c.started != nil {
+		c.started.Describe(ch)
+		c.stopped.Describe(ch)
+	}
+	c.droppedSamples.Describe(ch)
+	c.collectDuration.Describe(ch)
+	c.profileBytes.Describe(ch)
+	c.processedSamples.Describe(ch)
+	c.processedLocations.Describe(ch)
+	c.unitFallbacks.Describe(ch)
+	c.parseErrors.Describe(ch)
+	c.emptyProfiles.Describe(ch)
+	c.samplingSaturation.Describe(ch)
+	c.runningGauge.Describe(ch)
+	c.collectorInfo.gauge.Describe(ch)
+	c.symbolCacheHitRatio.Describe(ch)
+	c.symbolizerMemory.Describe(ch)
+	c.unresolvedSamples.Describe(ch)
+	c.lostSamples.Describe(ch)
+	if c.opts.dumpDir != "" {
+		c.dumps.Describe(ch)
+		c.dumpErrors.Describe(ch)
+	}
+	if c.opts.stackDepth {
+		c.stackDepth.Describe(ch)
+	}
+	for _, p := range c.pushers {
+		p.Describe(ch)
+	}
+	if c.remoteWriter != nil {
+		c.remoteWriter.Describe(ch)
+	}
+	if c.opts.collectTimeout > 0 {
+		c.collectionsTimedOut.Describe(ch)
+	}
 }
 
 func (c *cpuProfileCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.opts.collectTimeout > 0 {
+		c.collectWithin(c.opts.collectTimeout, ch)
+		return
+	}
+
 	c.Lock()
 	defer c.Unlock()
+
+	c.collectCached(ch)
+}
+
+// collectCached sends the metrics of the most recent scrape to ch if they are
+// still fresh, and collects them anew otherwise.
+func (c *cpuProfileCollector) collectCached(ch chan<- prometheus.Metric) {
+	if c.scrapeCache == nil {
+		c.collect(ch)
+		return
+	}
+	for _, m := range c.scrapeCache.get(c.opts.clock.Now(), c.collect) {
+		ch <- m
+	}
+}
+
+// collect reads the profile data, unless it is read in the background, and
+// sends the metrics to ch.
+func (c *cpuProfileCollector) collect(ch chan<- prometheus.Metric) {
+	start := c.opts.clock.Now()
+
+	if c.opts.drainInterval == 0 {
+		c.drain()
+	}
+
+	c.collectDuration.Observe(c.opts.clock.Now().Sub(start).Seconds())
+
 	if c.running {
-		runtime.SetCPUProfileRate(0)
+		c.runningGauge.Set(1)
+	} else {
+		c.runningGauge.Set(0)
+		if c.opts.resetWhenStopped {
+			c.timeUsed.Reset()
+			c.timeUsedCum.Reset()
+			c.samples.Reset()
+			c.edgeTime.Reset()
+		}
+	}
 
-		var allData bytes.Buffer
-		for {
-			data := runtime.CPUProfile()
-			if data == nil {
-				break
-			}
-			allData.Write(data)
+	if c.opts.intervalGauges {
+		// the gauges only cover the time since the previous scrape.
+		c.intervalTime.Collect(ch)
+		if c.cumulativeEnabled {
+			c.intervalTimeCum.Collect(ch)
+		}
+		c.intervalTime.Reset()
+		c.intervalTimeCum.Reset()
+	} else {
+		c.timeUsed.Collect(ch)
+		if c.cumulativeEnabled {
+			c.timeUsedCum.Collect(ch)
 		}
+	}
+	if c.samplesEnabled {
+		c.samples.Collect(ch)
+	}
+	if c.opts.fraction {
+		c.fraction.Collect(ch)
+	}
+	if c.opts.edges {
+		c.edgeTime.Collect(ch)
+	}
+	if len(c.opts.histogramFunctions) > 0 {
+		c.timeHistogram.Collect(ch)
+	}
+	if c.opts.handlerMetric {
+		c.handlerTime.Collect(ch)
+	}
+	if c.started != nil {
+		c.started.Collect(ch)
+		c.stopped.Collect(ch)
+	}
+	c.droppedSamples.Collect(ch)
+	c.collectDuration.Collect(ch)
+	c.profileBytes.Collect(ch)
+	c.processedSamples.Collect(ch)
+	c.processedLocations.Collect(ch)
+	c.unitFallbacks.Collect(ch)
+	c.parseErrors.Collect(ch)
+	c.emptyProfiles.Collect(ch)
+	c.samplingSaturation.Collect(ch)
+	c.runningGauge.Collect(ch)
+	c.collectorInfo.gauge.Collect(ch)
+	c.symbolCacheHitRatio.Set(c.symbolCache.hitRatio())
+	c.symbolCacheHitRatio.Collect(ch)
+	c.symbolizerMemory.Set(float64(symbolizerMemory(c.symbolCache)))
+	c.symbolizerMemory.Collect(ch)
+	c.unresolvedSamples.Collect(ch)
+	c.lostSamples.Collect(ch)
+	if c.opts.dumpDir != "" {
+		c.dumps.Collect(ch)
+		c.dumpErrors.Collect(ch)
+	}
+	if c.opts.stackDepth {
+		c.stackDepth.Collect(ch)
+	}
+	for _, p := range c.pushers {
+		p.Collect(ch)
+	}
+	if c.remoteWriter != nil {
+		c.remoteWriter.Collect(ch)
+	}
+}
+
+// EnableCumulative enables or disables the cumulated time metric at runtime.
+// It is enabled by default. Disabling it removes all of its series.
+func (c *cpuProfileCollector) EnableCumulative(enabled bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.cumulativeEnabled = enabled
+	if !enabled {
+		c.timeUsedCum.Reset()
+		c.intervalTimeCum.Reset()
+	}
+}
+
+// EnableSampleCount enables or disables the metric of the number of samples
+// per function at runtime. It is disabled by default. Disabling it removes
+// all of its series.
+func (c *cpuProfileCollector) EnableSampleCount(enabled bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.samplesEnabled = enabled
+	if !enabled {
+		c.samples.Reset()
+	}
+}
+
+// SetSampleRate changes the rate of the CPU profiler to hz samples per second,
+// e.g. to increase the resolution during an incident. If the collector is
+// running, the profile data recorded so far is added to the metrics and the
+// profiler is restarted at the new rate. As the profile records the CPU time
+// of each sample, the time metrics remain continuous across the change. Rates
+// other than 100 require WithNonDefaultSampleRate, as with WithSampleRate.
+func (c *cpuProfileCollector) SetSampleRate(hz int) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.opts.checkSampleRate(hz); err != nil {
+		return err
+	}
+
+	c.opts.sampleRate = hz
+	c.drain()
+
+	c.collectorInfo.set(hz)
+	return nil
+}
 
-		p, err := profile.Parse(&allData)
-		if err != nil {
-			panic(err) // TODO: introduce metric for parse errors.
+// SetFunctionFilter replaces the function filter set with WithFunctionFilter.
+// It applies to the profile data read from now on; the series of functions
+// that are filtered out keep their values until Reset.
+func (c *cpuProfileCollector) SetFunctionFilter(include, exclude *regexp.Regexp) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.opts.include = include
+	c.opts.exclude = exclude
+	c.locationCache.invalidate()
+}
+
+// Reset removes all series of the time, sample, edge, histogram and dropped
+// samples metrics. Profile data that has been recorded but not been read yet
+// is discarded, so that only samples recorded after the reset are accounted.
+func (c *cpuProfileCollector) Reset() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.running && !c.paused {
+		c.opts.profiler.Stop()
+		c.startProfiler()
+		c.lastDrain = c.opts.clock.Now()
+	}
+
+	c.timeUsed.Reset()
+	c.timeUsedCum.Reset()
+	c.intervalTime.Reset()
+	c.intervalTimeCum.Reset()
+	c.fraction.Reset()
+	c.samples.Reset()
+	c.edgeTime.Reset()
+	c.timeHistogram.Reset()
+	c.handlerTime.Reset()
+	c.droppedSamples.Reset()
+	c.ttl = newSeriesTTL(c.opts.seriesTTL)
+	c.flatLimit = newSeriesLimit(c.opts.maxFunctions)
+	c.cumLimit = newSeriesLimit(c.opts.maxFunctions)
+	if c.scrapeCache != nil {
+		c.scrapeCache.invalidate()
+	}
+}
+
+// Healthy returns whether the collector is fully functional, i.e. it is
+// running, could enable the CPU profiler, has symbols to resolve locations
+// to, and could parse the most recent profile. If it isn't, the returned
+// error describes the reason.
+func (c *cpuProfileCollector) Healthy() (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	switch {
+	case !c.running:
+		return false, errors.New("collector is not running")
+	case c.profilerErr != nil:
+		return false, fmt.Errorf("CPU profiler is not available: %v", c.profilerErr)
+	case isEmptySymbolTable(c.symbolizer):
+		return false, errors.New("no symbols available")
+	case c.parseErr != nil:
+		return false, fmt.Errorf("parsing profile failed: %v", c.parseErr)
+	}
+
+	return true, nil
+}
+
+// Flush reads the profile data recorded so far and adds it to the collector's
+// metrics. This is mostly useful in combination with WithDrainInterval.
+func (c *cpuProfileCollector) Flush() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.drain()
+}
+
+// startProfiler starts the profiler and records whether that failed, e.g.
+// because another CPU profile is already being recorded.
+func (c *cpuProfileCollector) startProfiler() {
+	c.profileRate = c.opts.sampleRate
+	c.profilerErr = c.opts.profiler.Start(c.profileRate)
+	if c.profilerErr != nil {
+		c.opts.log(LevelError, "starting CPU profiler failed", "err", c.profilerErr)
+	}
+}
+
+// drain reads the profile data recorded so far, if the collector is running
+// and not paused by the duty cycle, and adds it to the collector's metrics.
+func (c *cpuProfileCollector) drain() {
+	if !c.running || c.paused {
+		return
+	}
+
+	c.readProfile()
+	c.startProfiler()
+}
+
+// readProfile stops the profiler and adds the profile data recorded since it
+// was started to the collector's metrics.
+func (c *cpuProfileCollector) readProfile() {
+	now := c.opts.clock.Now()
+	rate := c.profileRate
+	samples := c.addData(c.opts.profiler.Stop())
+
+	// The profiler drops samples when its buffer overflows, which shows as
+	// fewer samples than the sampling rate suggests for the elapsed time.
+	if expected := now.Sub(c.lastDrain).Seconds() * float64(rate); expected > 0 {
+		c.samplingSaturation.Set(float64(samples) / expected)
+	}
+	c.lastDrain = now
+
+	c.ttl.expire(c.forgetSeries)
+}
+
+// forgetSeries removes the series key of v, which has been deleted, from the
+// series admitted with WithMaxFunctions.
+func (c *cpuProfileCollector) forgetSeries(v *prometheus.CounterVec, key string) {
+	switch v {
+	case c.timeUsed:
+		c.flatLimit.forget(key)
+	case c.timeUsedCum:
+		c.cumLimit.forget(key)
+	}
+}
+
+// runPeriodically calls f with the collector locked every interval, until stop
+// is closed.
+func (c *cpuProfileCollector) runPeriodically(interval time.Duration, stop chan struct{}, f func()) {
+	t := c.opts.clock.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.Chan():
 		}
 
-		locations := mapLocations(p.Location, c.symbols)
+		c.Lock()
+		select {
+		case <-stop:
+			c.Unlock()
+			return
+		default:
+		}
+		f()
+		c.Unlock()
+	}
+}
 
-		for _, s := range p.Sample {
-			if len(s.Location) == 0 || len(s.Value) < 2 {
-				continue
+// Ingest parses a CPU profile in pprof format from r and adds its samples to the
+// collector's metrics. Locations are resolved using the profile's own function
+// information if available, and the collector's symbols otherwise.
+func (c *cpuProfileCollector) Ingest(r io.Reader) error {
+	p, err := profile.Parse(r)
+	if err != nil {
+		return err
+	}
+	if _, ok := valueIndex(p, cpuSampleType); !ok {
+		return fmt.Errorf("not a CPU profile: no sample type %q", cpuSampleType)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.addProfile(p)
+
+	return nil
+}
+
+// addData parses the raw profile data and adds it to the collector's metrics.
+// It returns the number of samples in the profile.
+func (c *cpuProfileCollector) addData(data []byte) int64 {
+	c.profileBytes.Add(float64(len(data)))
+
+	// an idle program may not have produced any profile data at all.
+	if len(data) == 0 {
+		c.parseErr = nil
+		c.emptyProfiles.Inc()
+		c.opts.log(LevelDebug, "profile is empty")
+		return 0
+	}
+
+	p, err := profile.ParseData(data)
+	c.parseErr = err
+	if err != nil {
+		c.parseErrors.Inc()
+		c.opts.log(LevelError, "parsing profile failed", "bytes", len(data), "err", err)
+		c.opts.errorHandler(fmt.Errorf("parsing CPU profile failed: %v", err))
+		return 0
+	}
+
+	if p.Empty() {
+		c.emptyProfiles.Inc()
+		c.opts.log(LevelDebug, "profile contains no samples")
+		return 0
+	}
+
+	c.addProfile(p)
+
+	if c.history != nil {
+		c.history.add(c.opts.clock.Now(), time.Duration(p.DurationNanos), data)
+	}
+	for _, pusher := range c.pushers {
+		pusher.push(c.opts.clock.Now(), c.profileRate, p, data, c.symbolCache)
+	}
+	c.exportProfile(c.opts.clock.Now(), time.Duration(p.DurationNanos), data)
+	if c.opts.dumpDir != "" {
+		c.addToDump(p)
+	}
+
+	return sampleCount(p)
+}
+
+// sampleCount returns the number of samples in the profile p.
+func sampleCount(p *profile.Profile) int64 {
+	idx, ok := valueIndex(p, "samples")
+	if !ok {
+		return int64(len(p.Sample))
+	}
+
+	var n int64
+	for _, s := range p.Sample {
+		if idx < len(s.Value) {
+			n += s.Value[idx]
+		}
+	}
+	return n
+}
+
+// addProfile adds the samples of the profile p to the collector's metrics.
+func (c *cpuProfileCollector) addProfile(p *profile.Profile) {
+	// plugins and shared objects may have been loaded since the last profile.
+	if refreshMappings(c.symbolCache, mappedFiles(p)) {
+		c.locationCache.invalidate()
+	}
+	locations := c.locationCache.mapLocations(p.Location, c.symbolCache, c.opts)
+	c.processedSamples.Add(float64(len(p.Sample)))
+	c.processedLocations.Add(float64(len(p.Location)))
+
+	idx, ok := valueIndex(p, cpuSampleType)
+	if !ok {
+		idx = defaultCPUValueIndex
+	}
+	divisor := c.unitDivisor(p, idx) * c.opts.timeScale()
+	samplesIdx, samplesOK := valueIndex(p, "samples")
+
+	// the series are accumulated over the profile first, so that functions
+	// below the minimum time can be dropped.
+	flat, samples, cum := c.flatWindow.reset(), c.samplesWindow.reset(), c.cumWindow.reset()
+	// records counts the profile samples of the flat series, for accounting
+	// the ones below the minimum time as dropped.
+	records := c.recordsWindow.reset()
+	var total, otherTime, otherSamples float64
+
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 {
+			c.droppedSamples.WithLabelValues(reasonNoLocation).Inc()
+			continue
+		}
+		if len(s.Value) <= idx {
+			c.droppedSamples.WithLabelValues(reasonInsufficientValues).Inc()
+			continue
+		}
+
+		if c.opts.stackDepth {
+			c.stackDepth.Observe(float64(len(s.Location)))
+		}
+
+		value := float64(s.Value[idx]) / divisor
+		total += value
+		exemplar := c.opts.exemplarLabels(s)
+
+		if c.opts.edges {
+			c.addEdges(locations, s, value)
+		}
+		if handler := s.Label[HandlerLabel]; c.opts.handlerMetric && len(handler) > 0 {
+			c.handlerTime.WithLabelValues(handler[0]).Add(value)
+		}
+
+		count := 1.0
+		if samplesOK && samplesIdx < len(s.Value) {
+			count = float64(s.Value[samplesIdx])
+		}
+		if locations[s.Location[0].ID].unresolved {
+			c.unresolvedSamples.Add(count)
+		}
+		if locations[s.Location[0].ID].lost {
+			c.lostSamples.Add(count)
+		}
+		if f, ok := c.opts.locationName(locations, s.Location[0].ID); ok {
+			labels := c.opts.labelValues(f, s, s.Location[0])
+			flat.addWithExemplar(labels, value, exemplar)
+			records.add(labels, 1)
+			if c.samplesEnabled {
+				samples.add(labels, count)
 			}
+		} else {
+			otherTime += value
+			otherSamples += count
+			c.droppedSamples.WithLabelValues(reasonFiltered).Inc()
+		}
 
-			c.timeUsed.WithLabelValues(locations[s.Location[0].ID]).Add(float64(s.Value[1]) / nanoToMilliDivisor)
+		if !c.cumulativeEnabled {
+			continue
+		}
+		seen := c.opts.newSeenSet()
+		for _, l := range s.Location {
+			for _, f := range c.opts.locationNames(locations, l.ID) {
+				if seen.add(f.Function) {
+					cum.addWithExemplar(c.opts.labelValues(f, s, l), value, exemplar)
+				}
+			}
+		}
+	}
 
-			for _, l := range s.Location {
-				c.timeUsedCum.WithLabelValues(locations[l.ID]).Add(float64(s.Value[1]) / nanoToMilliDivisor)
+	if len(c.opts.histogramFunctions) > 0 {
+		flat.observe(c.timeHistogram, c.opts.histogramFunctions)
+	}
+
+	// the time of the functions below the minimum time and of the filtered
+	// samples is accounted to otherFunction, so that the sum of the flat
+	// series still is the total CPU time.
+	min := c.opts.minTime(total)
+	for key, value := range flat.values {
+		if value < min {
+			otherTime += value
+			otherSamples += samples.values[key]
+			c.droppedSamples.WithLabelValues(reasonMinTime).Add(records.values[key])
+			flat.remove(key)
+			samples.remove(key)
+		}
+	}
+	if otherTime > 0 || otherSamples > 0 {
+		flat.add(c.opts.otherLabels(), otherTime)
+		if c.samplesEnabled {
+			samples.add(c.opts.otherLabels(), otherSamples)
+		}
+	}
+
+	for key, value := range cum.values {
+		if value < min {
+			cum.remove(key)
+		}
+	}
+
+	// the functions beyond the limit set with WithMaxFunctions are accounted
+	// to otherFunction as well, the samples like the time of the function.
+	other := c.opts.otherLabels()
+	c.flatLimit.admit(flat, other)
+	c.flatLimit.fold(flat, other)
+	c.flatLimit.fold(samples, other)
+	c.cumLimit.admit(cum, other)
+	c.cumLimit.fold(cum, other)
+
+	flat.flush(c.timeUsed, keepAll, c.ttl)
+	samples.flush(c.samples, keepAll, c.ttl)
+	cum.flush(c.timeUsedCum, keepAll, c.ttl)
+	if c.opts.intervalGauges {
+		flat.flushGauge(c.intervalTime, keepAll)
+		cum.flushGauge(c.intervalTimeCum, keepAll)
+	}
+	if c.opts.fraction {
+		c.fraction.Reset()
+		flat.setShares(c.fraction, total, keepAll)
+	}
+}
+
+// addEdges accounts the value of the sample s to the edges from caller to
+// callee between adjacent functions of its stack. Each edge is accounted once
+// per sample, and calls of functions to themselves aren't accounted at all.
+func (c *cpuProfileCollector) addEdges(locations map[uint64]locationFunctions, s *profile.Sample, value float64) {
+	var callee string
+	first := true
+	seen := make(map[[2]string]bool)
+	for _, l := range s.Location {
+		for _, f := range c.opts.locationNames(locations, l.ID) {
+			if !first && f.Function != callee {
+				edge := [2]string{f.Function, callee}
+				if !seen[edge] {
+					seen[edge] = true
+					c.edgeTime.WithLabelValues(edge[0], edge[1]).Add(value)
+					c.ttl.touch(c.edgeTime, edge[0]+"\x00"+edge[1], edge[:])
+				}
 			}
+			callee, first = f.Function, false
 		}
 	}
+}
 
-	c.timeUsed.Collect(ch)
-	c.timeUsedCum.Collect(ch)
-	c.started.Collect(ch)
-	c.stopped.Collect(ch)
+// unitDivisor returns the divisor that converts the time values at index idx
+// of the profile p to milliseconds, as declared by its sample type. Profiles
+// with an unknown unit are assumed to be in nanoseconds.
+func (c *cpuProfileCollector) unitDivisor(p *profile.Profile, idx int) float64 {
+	if idx < len(p.SampleType) && p.SampleType[idx] != nil {
+		if divisor, ok := unitDivisors[p.SampleType[idx].Unit]; ok {
+			return divisor
+		}
+	}
+	c.unitFallbacks.Inc()
+	return nanoToMilliDivisor
+}
 
-	if c.running {
-		runtime.SetCPUProfileRate(cpuProfileRate)
+// valueIndex returns the index of the sample values of type typ (e.g. "cpu" or
+// "samples") in the profile p.
+func valueIndex(p *profile.Profile, typ string) (int, bool) {
+	for i, st := range p.SampleType {
+		if st != nil && st.Type == typ {
+			return i, true
+		}
 	}
+	return 0, false
+}
+
+// locationFunctions are the functions at a profile location that metrics
+// shall be emitted for.
+type locationFunctions struct {
+	// innermost is the function whose code is at the location. It is only
+	// valid if ok is true.
+	innermost Frame
+	ok        bool
+	// all are the innermost function and the functions that it has been
+	// inlined into, innermost first.
+	all []Frame
+	// unresolved is true if the location couldn't be resolved to any
+	// function.
+	unresolved bool
+	// lost is true if the location is the one that the runtime accounts lost
+	// samples to.
+	lost bool
 }
 
-func mapLocations(locations []*profile.Location, symbols []objfile.Sym) map[uint64]string {
-	result := make(map[uint64]string)
+// unresolvedFrames are the frames of an unresolved location.
+var unresolvedFrames = []Frame{{}}
 
-	for _, l := range locations {
-		for _, s := range symbols {
-			if l.Address >= s.Addr && l.Address <= s.Addr+uint64(s.Size) {
-				result[l.ID] = s.Name
-				break
-			}
+// locationName returns the innermost function at the location ID, i.e. the
+// one that the flat metrics are accounted to, and whether metrics shall be
+// emitted for it at all. Locations missing from the profile are reported with
+// an empty function name unless the collector is restricted to a subset of
+// the binary.
+func (o *options) locationName(locations map[uint64]locationFunctions, id uint64) (Frame, bool) {
+	f, ok := locations[id]
+	if !ok {
+		return Frame{}, !o.filtered()
+	}
+	return f.innermost, f.ok
+}
+
+// locationNames returns all functions at the location ID that metrics shall
+// be emitted for, i.e. the ones that the cumulated metrics are accounted to,
+// including the ones that the innermost function has been inlined into.
+func (o *options) locationNames(locations map[uint64]locationFunctions, id uint64) []Frame {
+	f, ok := locations[id]
+	if !ok {
+		if o.filtered() {
+			return nil
 		}
+		return unresolvedFrames
+	}
+	return f.all
+}
+
+// labelValues returns the label values for the location l of the sample s
+// at the function f.
+func (o *options) labelValues(f Frame, s *profile.Sample, l *profile.Location) []string {
+	values := []string{f.Function}
+	for _, sl := range o.sampleLabels {
+		values = append(values, sl.value(s, l, f))
 	}
+	return values
+}
 
+// mapLocations resolves the locations to the functions at them. Functions
+// that have been inlined at a location are taken from its lines if the profile
+// is symbolized, or from symbolizer if it is a FrameSymbolizer.
+func mapLocations(locations []*profile.Location, symbolizer Symbolizer, o *options) map[uint64]locationFunctions {
+	result := make(map[uint64]locationFunctions, len(locations))
+	for _, l := range locations {
+		result[l.ID] = mapLocation(l, symbolizer, o)
+	}
 	return result
 }
+
+// mapLocation resolves the location l to the functions at it like
+// mapLocations.
+func mapLocation(l *profile.Location, symbolizer Symbolizer, o *options) locationFunctions {
+	var frames []Frame
+	for _, line := range l.Line {
+		if line.Function != nil {
+			frames = append(frames, Frame{Function: line.Function.Name, File: line.Function.Filename, Line: line.Line})
+		}
+	}
+	if len(frames) == 0 {
+		names, _ := resolveFrames(symbolizer, l.Address)
+		for _, name := range names {
+			frames = append(frames, Frame{Function: name})
+		}
+	}
+	if len(frames) == 0 {
+		// unresolved locations are labeled with their address unless the
+		// collector is restricted to a subset of the binary.
+		f := locationFunctions{unresolved: true}
+		if !o.filtered() {
+			fr := Frame{Function: o.unresolvedName(l.Address)}
+			f.innermost, f.ok, f.all = fr, true, []Frame{fr}
+		}
+		return f
+	}
+
+	f := locationFunctions{lost: frames[0].Function == lostProfileEventFunction}
+	for i, fr := range frames {
+		if !o.keep(fr.Function, l.Address) {
+			continue
+		}
+		fr.Function = o.displayName(fr.Function)
+		if o.labelMapper != nil {
+			var keep bool
+			if fr.Function, keep = o.labelMapper(fr); !keep {
+				continue
+			}
+		}
+		if i == 0 {
+			f.innermost, f.ok = fr, true
+		}
+		f.all = append(f.all, fr)
+	}
+	return f
+}
@@ -1,17 +1,29 @@
 package pprofetheus
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/travelaudience/pprofetheus/internal/objfile"
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+
 	"github.com/prometheus/client_golang/prometheus"
 
 	dto "github.com/prometheus/client_model/go"
 )
 
 func TestCPUProfileCollector(t *testing.T) {
-	profileCollector, err := NewCPUProfileCollector()
+	profileCollector, err := NewCPUProfileCollector(WithDrainInterval(0))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -43,8 +55,9 @@ func TestCPUProfileCollector(t *testing.T) {
 		metrics = append(metrics, m)
 	}
 
-	if len(metrics) != 6 {
-		t.Fatalf("Expected 6 metrics, got %d instead: %#v", len(metrics), metrics)
+	// the number of series depends on the functions that have been sampled.
+	if len(metrics) == 0 {
+		t.Fatal("Expected metrics, got none")
 	}
 
 	testData := []struct {
@@ -54,10 +67,10 @@ func TestCPUProfileCollector(t *testing.T) {
 		ExpectedMinValue float64
 		ExpectedMaxValue float64
 	}{
-		{"pprof_cpu_time_used_ms", "github.com/travelaudience/pprofetheus.spendSomeTimeComputing", true, 990, 1100},
-		{"pprof_cpu_time_used_cum_ms", "github.com/travelaudience/pprofetheus.spendSomeTimeComputing", true, 990, 1100},
-		{"pprof_cpu_time_used_cum_ms", "testing.tRunner", true, 990, 1100},
-		{"pprof_cpu_time_used_cum_ms", "runtime.goexit", true, 990, 1100},
+		// the samples of a second of CPU time vary by a few percent.
+		{"pprof_cpu_time_used_ms", "github.com/travelaudience/pprofetheus.spendSomeTimeComputing", true, 900, 1100},
+		{"pprof_cpu_time_used_cum_ms", "github.com/travelaudience/pprofetheus.spendSomeTimeComputing", true, 900, 1100},
+		{"pprof_cpu_time_used_cum_ms", "testing.tRunner", true, 900, 1100},
 		{"pprof_cpu_started", "", false, 1, 1},
 		{"pprof_cpu_stopped", "", false, 0, 0},
 	}
@@ -127,3 +140,1748 @@ func spendSomeTimeComputing() {
 		}
 	}
 }
+
+func TestCPUProfileCollectorModulePrefix(t *testing.T) {
+	const prefix = "github.com/travelaudience/pprofetheus."
+
+	profileCollector, err := NewCPUProfileCollector(WithModulePrefix(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := profileCollector.(*cpuProfileCollector)
+	c.addProfile(testProfile(t, binarySymbolTable(t, c.symbolizer), []string{prefix + "spendSomeTimeComputing", "testing.tRunner"}))
+
+	found := false
+	for _, m := range collectMetrics(c) {
+		fn, ok := functionLabel(t, m)
+		if !ok {
+			continue
+		}
+		if !strings.HasPrefix(fn, prefix) {
+			t.Errorf("unexpected function %q outside of prefix %q", fn, prefix)
+		}
+		if fn == prefix+"spendSomeTimeComputing" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no metrics for %sspendSomeTimeComputing found", prefix)
+	}
+}
+
+// binarySymbolTable returns the symbols of the binary that symbolizer resolves
+// addresses to, at the addresses that it resolves.
+func binarySymbolTable(t *testing.T, symbolizer Symbolizer) []Symbol {
+	switch s := symbolizer.(type) {
+	case symbolTable:
+		return s.symbols
+	case biasedSymbolizer:
+		var relocated []Symbol
+		for _, sym := range binarySymbolTable(t, s.symbolizer) {
+			sym.Addr += s.bias
+			relocated = append(relocated, sym)
+		}
+		return relocated
+	case fallbackSymbolizer:
+		return binarySymbolTable(t, s[0])
+	}
+	t.Fatalf("unexpected symbolizer %T", symbolizer)
+	return nil
+}
+
+// testProfile returns a CPU profile that contains one 10ms sample for each of
+// the provided stacks. Each stack is a list of symbol names, leaf first, that
+// are resolved to addresses using symbols.
+func testProfile(t testing.TB, symbols []objfile.Sym, stacks ...[]string) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+	}
+
+	locations := make(map[string]*profile.Location)
+	for _, stack := range stacks {
+		s := &profile.Sample{Value: []int64{1, 10000000}}
+		for _, name := range stack {
+			l, ok := locations[name]
+			if !ok {
+				l = &profile.Location{ID: uint64(len(p.Location) + 1), Address: symbolAddr(t, symbols, name)}
+				locations[name] = l
+				p.Location = append(p.Location, l)
+			}
+			s.Location = append(s.Location, l)
+		}
+		p.Sample = append(p.Sample, s)
+	}
+
+	return p
+}
+
+func symbolAddr(t testing.TB, symbols []objfile.Sym, name string) uint64 {
+	for _, s := range symbols {
+		if s.Name == name {
+			return s.Addr + 1
+		}
+	}
+	t.Fatalf("symbol %s not found", name)
+	return 0
+}
+
+func collectMetrics(c prometheus.Collector) []prometheus.Metric {
+	metricsChan := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(metricsChan)
+		close(metricsChan)
+	}()
+
+	metrics := []prometheus.Metric{}
+	for m := range metricsChan {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func functionLabel(t *testing.T, m prometheus.Metric) (string, bool) {
+	var metric dto.Metric
+	if err := m.Write(&metric); err != nil {
+		t.Fatalf("writing metric to DTO failed: %v", err)
+	}
+	for _, l := range metric.Label {
+		if l.GetName() == "function" {
+			return l.GetValue(), true
+		}
+	}
+	return "", false
+}
+
+func TestCPUProfileCollectorDroppedSamples(t *testing.T) {
+	profileCollector, err := NewCPUProfileCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := profileCollector.(*cpuProfileCollector)
+
+	p := testProfile(t, binarySymbolTable(t, c.symbolizer), []string{"testing.tRunner"})
+	p.Sample = append(p.Sample,
+		&profile.Sample{Value: []int64{1, 10000000}},
+		&profile.Sample{Value: []int64{1, 10000000}},
+		&profile.Sample{Location: p.Sample[0].Location, Value: []int64{1}},
+	)
+	c.addProfile(p)
+
+	testData := []struct {
+		Reason        string
+		ExpectedValue float64
+	}{
+		{"no_location", 2},
+		{"insufficient_values", 1},
+	}
+
+	for idx, testEntry := range testData {
+		if value := counterValue(t, c.droppedSamples.WithLabelValues(testEntry.Reason)); value != testEntry.ExpectedValue {
+			t.Errorf("%d. dropped samples with reason %s = %f, expected %f", idx, testEntry.Reason, value, testEntry.ExpectedValue)
+		}
+	}
+
+	if value := counterValue(t, c.timeUsed.WithLabelValues("testing.tRunner")); value != 10 {
+		t.Errorf("time used by testing.tRunner = %f, expected 10", value)
+	}
+}
+
+func counterValue(t *testing.T, m prometheus.Metric) float64 {
+	var metric dto.Metric
+	if err := m.Write(&metric); err != nil {
+		t.Fatalf("writing metric to DTO failed: %v", err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestCPUProfileCollectorDynamicLabels(t *testing.T) {
+	profileCollector, err := NewCPUProfileCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := newOptions(nil)
+	o.sampleLabels = append(o.sampleLabels, sampleLabel{
+		name: "location",
+		value: func(s *profile.Sample, l *profile.Location, f Frame) string {
+			return fmt.Sprint(l.ID)
+		},
+	})
+	c := newCPUProfileCollector(profileCollector.(*cpuProfileCollector).symbolizer, o)
+
+	descChan := make(chan *prometheus.Desc, 10)
+	c.Describe(descChan)
+	close(descChan)
+	if n := len(descChan); n != 0 {
+		t.Errorf("expected no descriptors with dynamic labels, got %d", n)
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.addProfile(testProfile(t, binarySymbolTable(t, c.symbolizer), []string{"testing.tRunner", "testing.(*T).Run"}))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gathering metrics failed: %v", err)
+	}
+
+	for _, mf := range families {
+		if mf.GetName() != "pprof_cpu_time_used_cum_ms" {
+			continue
+		}
+		if n := len(mf.GetMetric()); n != 2 {
+			t.Errorf("expected 2 series in %s, got %d", mf.GetName(), n)
+		}
+		for _, m := range mf.GetMetric() {
+			if n := len(m.GetLabel()); n != 2 {
+				t.Errorf("expected 2 labels, got %d: %v", n, m.GetLabel())
+			}
+		}
+	}
+}
+
+func TestCPUProfileCollectorCollectDuration(t *testing.T) {
+	profileCollector, err := NewCPUProfileCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	symbols := binarySymbolTable(t, profileCollector.(*cpuProfileCollector).symbolizer)
+
+	clk := &fakeClock{now: time.Unix(0, 0)}
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"testing.tRunner"}), clk)
+	prof.clock = clk
+	prof.delay = 250 * time.Millisecond
+
+	c.Start()
+	for i := 1; i <= 3; i++ {
+		collectMetrics(c)
+
+		var metric dto.Metric
+		if err := c.collectDuration.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if count := metric.GetHistogram().GetSampleCount(); count != uint64(i) {
+			t.Errorf("%d. collect duration sample count = %d", i, count)
+		}
+		if sum := metric.GetHistogram().GetSampleSum(); sum != 0.25*float64(i) {
+			t.Errorf("%d. collect duration sample sum = %f", i, sum)
+		}
+		if value := counterValue(t, c.profileBytes); value != float64(i*len(prof.data)) {
+			t.Errorf("%d. profile bytes = %f, expected %d", i, value, i*len(prof.data))
+		}
+		if value := counterValue(t, c.processedSamples); value != float64(i) {
+			t.Errorf("%d. processed samples = %f, expected %d", i, value, i)
+		}
+		if value := counterValue(t, c.processedLocations); value != float64(i) {
+			t.Errorf("%d. processed locations = %f, expected %d", i, value, i)
+		}
+	}
+	c.Stop()
+}
+
+// fakeProfiler is a profiler that returns the same profile data every time it
+// is stopped. If clock is set, reading the data takes delay. If block is set,
+// reading the data waits until it is closed.
+type fakeProfiler struct {
+	data    []byte
+	err     error
+	running bool
+	stops   int
+	hz      int
+	block   chan struct{}
+	clock   *fakeClock
+	delay   time.Duration
+}
+
+func (p *fakeProfiler) Start(hz int) error {
+	p.hz = hz
+	p.running = p.err == nil
+	return p.err
+}
+
+func (p *fakeProfiler) Stop() []byte {
+	if p.block != nil {
+		<-p.block
+	}
+	p.running = false
+	p.stops++
+	if p.clock != nil {
+		p.clock.Advance(p.delay)
+	}
+	return p.data
+}
+
+// setProfile makes the profiler return p, or no data at all if p is nil.
+func (f *fakeProfiler) setProfile(t testing.TB, p *profile.Profile) {
+	if p == nil {
+		f.data = nil
+		return
+	}
+	var data bytes.Buffer
+	if err := p.Write(&data); err != nil {
+		t.Fatal(err)
+	}
+	f.data = data.Bytes()
+}
+
+// newFakeCollector returns a collector that resolves addresses with symbols and
+// tells the time with clock, with opts set after WithSymbols(symbols) and
+// WithDrainInterval(0). Its fake profiler, which is returned as well, returns
+// p, or no data at all if p is nil.
+func newFakeCollector(t testing.TB, symbols []Symbol, p *profile.Profile, clock *fakeClock, opts ...Option) (*cpuProfileCollector, *fakeProfiler) {
+	prof := &fakeProfiler{}
+	prof.setProfile(t, p)
+	o := newOptions(append([]Option{WithSymbols(symbols), WithDrainInterval(0)}, opts...))
+	o.profiler = prof
+	o.clock = clock
+	return newCPUProfileCollector(newSymbolTable(symbols), o), prof
+}
+
+// fakeClock is a clock that only advances when told so. Its tickers only tick
+// when the test sends on their channel.
+type fakeClock struct {
+	sync.Mutex
+	now    time.Time
+	ticker *fakeTicker
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.Lock()
+	defer c.Unlock()
+	if c.ticker == nil {
+		c.ticker = newFakeTicker()
+	}
+	return c.ticker
+}
+
+type fakeTicker struct {
+	c chan time.Time
+}
+
+func newFakeTicker() *fakeTicker {
+	return &fakeTicker{c: make(chan time.Time)}
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {}
+
+func TestCPUProfileCollectorWithSymbols(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.handle", Addr: 0x1100, Size: 0x80, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1180, Size: 0x40, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithBinaryPath("/nonexistent"), WithSymbols(symbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := profileCollector.(*cpuProfileCollector)
+	c.addProfile(testProfile(t, symbols,
+		[]string{"main.compute", "main.handle", "main.main"},
+		[]string{"main.handle", "main.main"},
+	))
+
+	testData := []struct {
+		Function    string
+		ExpectedMs  float64
+		ExpectedCum float64
+	}{
+		{"main.compute", 10, 10},
+		{"main.handle", 10, 20},
+		{"main.main", 0, 20},
+	}
+
+	for idx, testEntry := range testData {
+		if value := counterValue(t, c.timeUsed.WithLabelValues(testEntry.Function)); value != testEntry.ExpectedMs {
+			t.Errorf("%d. time used by %s = %f, expected %f", idx, testEntry.Function, value, testEntry.ExpectedMs)
+		}
+		if value := counterValue(t, c.timeUsedCum.WithLabelValues(testEntry.Function)); value != testEntry.ExpectedCum {
+			t.Errorf("%d. cumulated time used by %s = %f, expected %f", idx, testEntry.Function, value, testEntry.ExpectedCum)
+		}
+	}
+}
+
+func TestCPUProfileCollectorMappingLabel(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "SSL_read", Addr: 0x7f0000001000, Size: 0x100, Code: 'T'},
+		{Name: "memcpy", Addr: 0x7f0000002000, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithMappingLabel())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := profileCollector.(*cpuProfileCollector)
+
+	p := testProfile(t, symbols, []string{"memcpy", "SSL_read", "main.main"})
+	p.Mapping = []*profile.Mapping{
+		{ID: 1, Start: 0x1000, Limit: 0x2000, File: "/app/server"},
+		{ID: 2, Start: 0x7f0000001000, Limit: 0x7f0000002000, File: "/usr/lib/libssl.so.1.1"},
+	}
+	p.Location[1].Mapping = p.Mapping[1]
+	p.Location[2].Mapping = p.Mapping[0]
+	c.addProfile(p)
+
+	expected := map[string]string{
+		"main.main": "/app/server",
+		"SSL_read":  "/usr/lib/libssl.so.1.1",
+		"memcpy":    "unknown",
+	}
+
+	for _, m := range collectMetrics(c.timeUsedCum) {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]string{}
+		for _, l := range metric.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if mapping, ok := expected[labels["function"]]; !ok || labels["mapping"] != mapping {
+			t.Errorf("unexpected labels %v", labels)
+		}
+		delete(expected, labels["function"])
+	}
+	if len(expected) != 0 {
+		t.Errorf("missing series for %v", expected)
+	}
+}
+
+func TestCPUProfileCollectorIngest(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	// the ingested profile carries its own function information, so the
+	// collector doesn't need to know any symbols.
+	profileCollector, err := NewCPUProfileCollector(WithSymbols([]Symbol{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	p := testProfile(t, symbols, []string{"main.compute", "main.main"}, []string{"main.compute", "main.main"})
+	symbolize(p, newSymbolTable(symbols))
+
+	var data bytes.Buffer
+	if err := p.Write(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if err := c.Ingest(bytes.NewReader(data.Bytes())); err != nil {
+			t.Fatalf("%d. ingesting profile failed: %v", i, err)
+		}
+		if value := counterValue(t, c.timeUsed.WithLabelValues("main.compute")); value != float64(i*20) {
+			t.Errorf("%d. time used by main.compute = %f, expected %d", i, value, i*20)
+		}
+		if value := counterValue(t, c.timeUsedCum.WithLabelValues("main.main")); value != float64(i*20) {
+			t.Errorf("%d. cumulated time used by main.main = %f, expected %d", i, value, i*20)
+		}
+	}
+
+	if err := c.Ingest(strings.NewReader("this is not a profile")); err == nil {
+		t.Errorf("ingesting garbage succeeded")
+	}
+}
+
+func TestCPUProfileCollectorSampleUnit(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	testData := []struct {
+		Unit             string
+		Value            int64
+		ExpectedMs       float64
+		ExpectedFallback float64
+	}{
+		{"nanoseconds", 10000000, 10, 0},
+		{"microseconds", 10000, 10, 0},
+		{"milliseconds", 10, 10, 0},
+		{"seconds", 2, 2000, 0},
+		{"jiffies", 10000000, 10, 1},
+	}
+
+	for idx, testEntry := range testData {
+		profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := profileCollector.(*cpuProfileCollector)
+
+		p := testProfile(t, symbols, []string{"main.main"})
+		p.SampleType[1].Unit = testEntry.Unit
+		p.Sample[0].Value[1] = testEntry.Value
+		c.addProfile(p)
+
+		if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != testEntry.ExpectedMs {
+			t.Errorf("%d. time used with unit %s = %f, expected %f", idx, testEntry.Unit, value, testEntry.ExpectedMs)
+		}
+		if value := counterValue(t, c.unitFallbacks); value != testEntry.ExpectedFallback {
+			t.Errorf("%d. unit fallbacks with unit %s = %f, expected %f", idx, testEntry.Unit, value, testEntry.ExpectedFallback)
+		}
+	}
+}
+
+func TestCPUProfileCollectorDrainInterval(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	clk := &fakeClock{now: time.Unix(0, 0), ticker: newFakeTicker()}
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), clk, WithDrainInterval(10*time.Second))
+
+	drains := func() int {
+		c.Lock()
+		defer c.Unlock()
+		return prof.stops
+	}
+
+	c.Start()
+
+	collectMetrics(c)
+	if n := drains(); n != 0 {
+		t.Errorf("profile was drained %d times on Collect", n)
+	}
+
+	for i := 1; i <= 3; i++ {
+		clk.ticker.c <- time.Time{}
+		waitFor(t, func() bool { return drains() == i })
+		if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != float64(i*10) {
+			t.Errorf("%d. time used by main.main = %f, expected %d", i, value, i*10)
+		}
+	}
+
+	collectMetrics(c)
+	if n := drains(); n != 3 {
+		t.Errorf("profile was drained %d times, expected 3", n)
+	}
+
+	c.Flush()
+	if n := drains(); n != 4 {
+		t.Errorf("profile was drained %d times after Flush, expected 4", n)
+	}
+
+	c.Stop()
+}
+
+// waitFor waits until cond returns true.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCPUProfileCollectorHealthy(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	var data bytes.Buffer
+	if err := testProfile(t, symbols, []string{"main.main"}).Write(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		Symbols       []Symbol
+		Data          []byte
+		ProfilerErr   error
+		Start         bool
+		ExpectHealthy bool
+		ExpectedErr   string
+	}{
+		{symbols, data.Bytes(), nil, true, true, ""},
+		{symbols, data.Bytes(), nil, false, false, "collector is not running"},
+		{symbols, data.Bytes(), errors.New("profiler busy"), true, false, "CPU profiler is not available: profiler busy"},
+		{[]Symbol{}, data.Bytes(), nil, true, false, "no symbols available"},
+		{symbols, []byte("garbage"), nil, true, false, "parsing profile failed"},
+	}
+
+	for idx, testEntry := range testData {
+		c, prof := newFakeCollector(t, testEntry.Symbols, nil, &fakeClock{now: time.Unix(0, 0)})
+		prof.data, prof.err = testEntry.Data, testEntry.ProfilerErr
+
+		if testEntry.Start {
+			c.Start()
+			c.Flush()
+		}
+
+		healthy, err := c.Healthy()
+		if healthy != testEntry.ExpectHealthy {
+			t.Errorf("%d. Healthy() = %t, expected %t", idx, healthy, testEntry.ExpectHealthy)
+		}
+		if testEntry.ExpectedErr == "" && err != nil {
+			t.Errorf("%d. unexpected error %v", idx, err)
+		}
+		if testEntry.ExpectedErr != "" && (err == nil || !strings.HasPrefix(err.Error(), testEntry.ExpectedErr)) {
+			t.Errorf("%d. error = %v, expected %q", idx, err, testEntry.ExpectedErr)
+		}
+
+		c.Stop()
+	}
+}
+
+func TestCPUProfileCollectorHealthyStrippedBinary(t *testing.T) {
+	path, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compact := range []bool{false, true} {
+		var opts []Option
+		if compact {
+			opts = append(opts, WithCompactSymbols())
+		}
+		o := newOptions(opts)
+		o.profiler = &fakeProfiler{}
+		o.clock = &fakeClock{now: time.Unix(0, 0)}
+		// the symbolizer of the binary is relocated and falls back to the
+		// shared objects as in the default setup, but the binary has no
+		// symbols.
+		c := newCPUProfileCollector(newBinarySymbolizer(o, path, nil), o)
+		c.Start()
+
+		if healthy, err := c.Healthy(); healthy || err == nil || err.Error() != "no symbols available" {
+			t.Errorf("compact = %t: Healthy() = %t, %v, expected no symbols available", compact, healthy, err)
+		}
+		c.Stop()
+	}
+}
+
+func TestValueIndex(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	p := testProfile(t, symbols, []string{"main.main"})
+	p.SampleType[0], p.SampleType[1] = p.SampleType[1], p.SampleType[0]
+	p.Sample[0].Value = []int64{20000000, 2}
+
+	testData := []struct {
+		Type          string
+		ExpectedIndex int
+		ExpectedOk    bool
+	}{
+		{"cpu", 0, true},
+		{"samples", 1, true},
+		{"inuse_space", 0, false},
+	}
+
+	for idx, testEntry := range testData {
+		if i, ok := valueIndex(p, testEntry.Type); i != testEntry.ExpectedIndex || ok != testEntry.ExpectedOk {
+			t.Errorf("%d. valueIndex(%q) = (%d, %t), expected (%d, %t)", idx, testEntry.Type, i, ok, testEntry.ExpectedIndex, testEntry.ExpectedOk)
+		}
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.addProfile(p)
+
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != 20 {
+		t.Errorf("time used by main.main = %f, expected 20", value)
+	}
+}
+
+func TestCPUProfileCollectorEmptyProfile(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	for idx, p := range []*profile.Profile{nil, testProfile(t, symbols)} {
+		c, _ := newFakeCollector(t, symbols, p, &fakeClock{now: time.Unix(0, 0)})
+
+		c.Start()
+		collectMetrics(c)
+
+		if value := counterValue(t, c.emptyProfiles); value != 1 {
+			t.Errorf("%d. empty profiles = %f, expected 1", idx, value)
+		}
+		if value := counterValue(t, c.parseErrors); value != 0 {
+			t.Errorf("%d. parse errors = %f, expected 0", idx, value)
+		}
+		if healthy, err := c.Healthy(); !healthy {
+			t.Errorf("%d. collector is unhealthy after empty profile: %v", idx, err)
+		}
+
+		c.Stop()
+	}
+}
+
+func TestCPUProfileCollectorSamplingSaturation(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	// 50 samples at 100 Hz cover 0.5s.
+	p := testProfile(t, symbols, []string{"main.main"})
+	p.Sample[0].Value = []int64{50, 500000000}
+
+	clk := &fakeClock{now: time.Unix(0, 0)}
+	c, _ := newFakeCollector(t, symbols, p, clk)
+
+	c.Start()
+
+	testData := []struct {
+		Elapsed       time.Duration
+		ExpectedRatio float64
+	}{
+		{500 * time.Millisecond, 1},
+		{1 * time.Second, 0.5},
+		{2 * time.Second, 0.25},
+	}
+
+	for idx, testEntry := range testData {
+		clk.Advance(testEntry.Elapsed)
+		c.Flush()
+
+		var metric dto.Metric
+		if err := c.samplingSaturation.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if ratio := metric.GetGauge().GetValue(); ratio != testEntry.ExpectedRatio {
+			t.Errorf("%d. sampling saturation after %s = %f, expected %f", idx, testEntry.Elapsed, ratio, testEntry.ExpectedRatio)
+		}
+	}
+
+	c.Stop()
+}
+
+func TestCPUProfileCollectorStopped(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	testData := []struct {
+		Options        []Option
+		ExpectedSeries int
+	}{
+		{nil, 1},
+		{[]Option{WithResetWhenStopped()}, 0},
+	}
+
+	for idx, testEntry := range testData {
+		c, _ := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Unix(0, 0)}, testEntry.Options...)
+
+		c.Start()
+		collectMetrics(c)
+		if value := gaugeValue(t, c.runningGauge); value != 1 {
+			t.Errorf("%d. running = %f while running, expected 1", idx, value)
+		}
+
+		c.Stop()
+		collectMetrics(c)
+		if value := gaugeValue(t, c.runningGauge); value != 0 {
+			t.Errorf("%d. running = %f while stopped, expected 0", idx, value)
+		}
+		if n := len(collectMetrics(c.timeUsed)); n != testEntry.ExpectedSeries {
+			t.Errorf("%d. %d series of time used while stopped, expected %d", idx, n, testEntry.ExpectedSeries)
+		}
+	}
+}
+
+func gaugeValue(t *testing.T, m prometheus.Metric) float64 {
+	var metric dto.Metric
+	if err := m.Write(&metric); err != nil {
+		t.Fatalf("writing metric to DTO failed: %v", err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func TestCPUProfileCollectorWithSymbolizer(t *testing.T) {
+	symbolizer := fakeSymbolizer{
+		0x1000: "main.main",
+		0x2000: "main.handle",
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithBinaryPath("/nonexistent"), WithSymbolizer(symbolizer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	p := testProfile(t, nil)
+	p.Location = []*profile.Location{
+		{ID: 1, Address: 0x2000},
+		{ID: 2, Address: 0x1000},
+		{ID: 3, Address: 0x3000},
+	}
+	p.Sample = []*profile.Sample{
+		{Location: p.Location[:2], Value: []int64{1, 10000000}},
+		{Location: p.Location[2:], Value: []int64{1, 10000000}},
+	}
+	c.addProfile(p)
+
+	// the unresolved location is labeled with its address.
+	expected := map[string]float64{
+		"main.handle":    10,
+		"unknown:0x3000": 10,
+	}
+	for _, m := range collectMetrics(c.timeUsed) {
+		fn, _ := functionLabel(t, m)
+		if value, ok := expected[fn]; !ok || counterValue(t, m) != value {
+			t.Errorf("unexpected time used %f by %q", counterValue(t, m), fn)
+		}
+		delete(expected, fn)
+	}
+	if len(expected) != 0 {
+		t.Errorf("missing series for %v", expected)
+	}
+
+	if value := counterValue(t, c.timeUsedCum.WithLabelValues("main.main")); value != 10 {
+		t.Errorf("cumulated time used by main.main = %f, expected 10", value)
+	}
+	if value := counterValue(t, c.unresolvedSamples); value != 1 {
+		t.Errorf("unresolved samples = %f, expected 1", value)
+	}
+}
+
+func TestCPUProfileCollectorLostSamples(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: lostProfileEventFunction, Addr: 0x2000, Size: 0x10, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	// the runtime reports the samples lost on overflow in a single record
+	// with the stack of lostProfileEvent.
+	p := testProfile(t, symbols, []string{"main.main"}, []string{lostProfileEventFunction})
+	p.Sample[1].Value = []int64{5, 50000000}
+	c.addProfile(p)
+
+	if value := counterValue(t, c.lostSamples); value != 5 {
+		t.Errorf("lost samples = %f, expected 5", value)
+	}
+}
+
+func TestUnresolvedName(t *testing.T) {
+	testData := []struct {
+		Opts     []Option
+		Expected string
+	}{
+		{nil, "unknown:0x4a2f10"},
+		{[]Option{WithUnresolvedFormat("unresolved %x")}, "unresolved 4a2f10"},
+		{[]Option{WithUnresolvedFormat("unknown")}, "unknown"},
+	}
+	for idx, testEntry := range testData {
+		if name := newOptions(testEntry.Opts).unresolvedName(0x4a2f10); name != testEntry.Expected {
+			t.Errorf("%d. unresolved name = %q, expected %q", idx, name, testEntry.Expected)
+		}
+	}
+}
+
+// fakeSymbolizer resolves exactly the addresses it contains.
+type fakeSymbolizer map[uint64]string
+
+func (s fakeSymbolizer) Resolve(addr uint64) (string, bool) {
+	name, ok := s[addr]
+	return name, ok
+}
+
+func TestCPUProfileCollectorLogger(t *testing.T) {
+	type logEntry struct {
+		level, msg string
+		keyvals    []interface{}
+	}
+	var entries []logEntry
+	logger := func(level, msg string, keyvals ...interface{}) {
+		entries = append(entries, logEntry{level, msg, keyvals})
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols([]Symbol{}), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	c.addData([]byte("this is not a profile"))
+
+	if len(entries) != 1 {
+		t.Fatalf("got %d log entries, expected 1: %v", len(entries), entries)
+	}
+	if entries[0].level != LevelError {
+		t.Errorf("parse error was logged at level %q, expected %q", entries[0].level, LevelError)
+	}
+	if len(entries[0].keyvals)%2 != 0 {
+		t.Errorf("odd number of keys and values: %v", entries[0].keyvals)
+	}
+}
+
+func TestCPUProfileCollectorTrimPrefix(t *testing.T) {
+	const prefix = "github.com/example/monorepo/"
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: prefix + "pkg.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithTrimPrefix(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	c.addProfile(testProfile(t, symbols, []string{prefix + "pkg.compute", "main.main"}))
+
+	if value := counterValue(t, c.timeUsed.WithLabelValues("pkg.compute")); value != 10 {
+		t.Errorf("time used by pkg.compute = %f, expected 10", value)
+	}
+	if value := counterValue(t, c.timeUsedCum.WithLabelValues("main.main")); value != 10 {
+		t.Errorf("cumulated time used by main.main = %f, expected 10", value)
+	}
+	for _, m := range collectMetrics(c.timeUsedCum) {
+		if fn, _ := functionLabel(t, m); strings.HasPrefix(fn, prefix) {
+			t.Errorf("function %q wasn't trimmed", fn)
+		}
+	}
+}
+
+func TestCPUProfileCollectorEnableMetrics(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	p := testProfile(t, symbols, []string{"main.compute", "main.main"})
+
+	// series returns the number of series of the metric v that are collected.
+	series := func(v *prometheus.CounterVec) int {
+		descs := make(chan *prometheus.Desc, 1)
+		v.Describe(descs)
+		desc := (<-descs).String()
+
+		n := 0
+		for _, m := range collectMetrics(c) {
+			if m.Desc().String() == desc {
+				n++
+			}
+		}
+		return n
+	}
+
+	c.addProfile(p)
+	if n := series(c.timeUsedCum); n != 2 {
+		t.Errorf("%d cumulated series with default settings, expected 2", n)
+	}
+	if n := series(c.samples); n != 0 {
+		t.Errorf("%d sample count series with default settings, expected 0", n)
+	}
+
+	c.EnableCumulative(false)
+	c.EnableSampleCount(true)
+	c.addProfile(p)
+	if n := series(c.timeUsedCum); n != 0 {
+		t.Errorf("%d cumulated series while disabled, expected 0", n)
+	}
+	if n := series(c.samples); n != 1 {
+		t.Errorf("%d sample count series while enabled, expected 1", n)
+	}
+	if value := counterValue(t, c.samples.WithLabelValues("main.compute")); value != 1 {
+		t.Errorf("sample count of main.compute = %f, expected 1", value)
+	}
+
+	c.EnableCumulative(true)
+	c.EnableSampleCount(false)
+	c.addProfile(p)
+	if n := series(c.timeUsedCum); n != 2 {
+		t.Errorf("%d cumulated series after re-enabling, expected 2", n)
+	}
+	if value := counterValue(t, c.timeUsedCum.WithLabelValues("main.main")); value != 10 {
+		t.Errorf("cumulated time used by main.main = %f after re-enabling, expected 10", value)
+	}
+	if n := series(c.samples); n != 0 {
+		t.Errorf("%d sample count series after disabling, expected 0", n)
+	}
+}
+
+func TestCPUProfileCollectorStackDepth(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.handle", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1200, Size: 0x100, Code: 'T'},
+	}
+	p := testProfile(t, symbols,
+		[]string{"main.main"},
+		[]string{"main.handle", "main.main"},
+		[]string{"main.compute", "main.handle", "main.main"},
+	)
+
+	for _, enabled := range []bool{false, true} {
+		opts := []Option{WithSymbols(symbols)}
+		if enabled {
+			opts = append(opts, WithStackDepthSummary())
+		}
+		profileCollector, err := NewCPUProfileCollector(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := profileCollector.(*cpuProfileCollector)
+		c.addProfile(p)
+
+		desc := collectMetrics(c.stackDepth)[0].Desc()
+		var summary *dto.Summary
+		for _, m := range collectMetrics(c) {
+			if m.Desc() != desc {
+				continue
+			}
+			var metric dto.Metric
+			if err := m.Write(&metric); err != nil {
+				t.Fatal(err)
+			}
+			summary = metric.GetSummary()
+		}
+
+		if !enabled {
+			if summary != nil {
+				t.Errorf("stack depth summary exported without WithStackDepthSummary")
+			}
+			continue
+		}
+		if summary == nil {
+			t.Fatalf("no stack depth summary exported")
+		}
+		if summary.GetSampleCount() != 3 || summary.GetSampleSum() != 6 {
+			t.Errorf("stack depth count = %d, sum = %f, expected 3 and 6", summary.GetSampleCount(), summary.GetSampleSum())
+		}
+		for _, q := range summary.GetQuantile() {
+			if q.GetQuantile() == 0.5 && q.GetValue() != 2 {
+				t.Errorf("median stack depth = %f, expected 2", q.GetValue())
+			}
+		}
+	}
+}
+
+func TestCPUProfileCollectorStartStopMetricsDisabled(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+	c, _ := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Unix(0, 0)}, WithStartStopMetricsDisabled())
+
+	c.Start()
+
+	foundTimeUsed := false
+	for _, m := range collectMetrics(c) {
+		desc := m.Desc().String()
+		if strings.Contains(desc, `"pprof_cpu_started"`) || strings.Contains(desc, `"pprof_cpu_stopped"`) {
+			t.Errorf("unexpected metric %s", desc)
+		}
+		if strings.Contains(desc, `"pprof_cpu_time_used_ms"`) {
+			foundTimeUsed = true
+		}
+	}
+	if !foundTimeUsed {
+		t.Errorf("no time metrics collected")
+	}
+
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(descs)
+		close(descs)
+	}()
+	for desc := range descs {
+		if strings.Contains(desc.String(), `"pprof_cpu_started"`) {
+			t.Errorf("unexpected description %s", desc)
+		}
+	}
+
+	c.Stop()
+}
+
+func TestCPUProfileCollectorNaming(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(
+		WithSymbols(symbols),
+		WithNamespace("acme"),
+		WithSubsystem("profiling"),
+		WithConstLabels(prometheus.Labels{"collector": "main"}),
+		WithHelpText("time_used_ms", "CPU time in milliseconds"),
+		WithFunctionLabel("func"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.addProfile(testProfile(t, symbols, []string{"main.main"}))
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, f := range families {
+		if !strings.HasPrefix(f.GetName(), "acme_profiling_") {
+			t.Errorf("unexpected metric %s", f.GetName())
+		}
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["collector"] != "main" {
+				t.Errorf("metric %s lacks the constant label: %v", f.GetName(), labels)
+			}
+			if f.GetName() == "acme_profiling_time_used_ms" && labels["func"] == "main.main" {
+				found = true
+			}
+		}
+		if f.GetName() == "acme_profiling_time_used_ms" && f.GetHelp() != "CPU time in milliseconds" {
+			t.Errorf("help of %s = %q", f.GetName(), f.GetHelp())
+		}
+	}
+	if !found {
+		t.Errorf("no time metric for main.main found")
+	}
+}
+
+func TestCPUProfileCollectorInlinedFunctions(t *testing.T) {
+	const prefix = "github.com/example/project/"
+
+	// main.handle has been inlined into main.main, and main.parse into
+	// main.handle.
+	inlined := func() *profile.Profile {
+		p := testProfile(t, nil)
+		var lines []profile.Line
+		for i, name := range []string{"strings.Index", prefix + "parse", prefix + "handle", "main.main"} {
+			f := &profile.Function{ID: uint64(i + 1), Name: name}
+			p.Function = append(p.Function, f)
+			lines = append(lines, profile.Line{Function: f})
+		}
+		p.Location = []*profile.Location{{ID: 1, Address: 0x1000, Line: lines}}
+		p.Sample = []*profile.Sample{{Location: p.Location, Value: []int64{1, 10000000}}}
+		return p
+	}
+
+	for _, tt := range []struct {
+		name   string
+		opts   []Option
+		flat   map[string]float64
+		cumAll []string
+	}{
+		{
+			name:   "unfiltered",
+			flat:   map[string]float64{"strings.Index": 10},
+			cumAll: []string{"strings.Index", prefix + "parse", prefix + "handle", "main.main"},
+		},
+		{
+			// the innermost function is outside of the prefix, so the sample
+			// only contributes to the cumulated metric and to otherFunction.
+			name:   "module prefix",
+			opts:   []Option{WithModulePrefix(prefix), WithTrimPrefix(prefix)},
+			flat:   map[string]float64{otherFunction: 10},
+			cumAll: []string{"parse", "handle"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			profileCollector, err := NewCPUProfileCollector(append(tt.opts, WithSymbolizer(fakeSymbolizer{}))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := profileCollector.(*cpuProfileCollector)
+			c.addProfile(inlined())
+
+			flat := make(map[string]float64)
+			for _, m := range collectMetrics(c.timeUsed) {
+				fn, _ := functionLabel(t, m)
+				flat[fn] = counterValue(t, m)
+			}
+			if !reflect.DeepEqual(flat, tt.flat) {
+				t.Errorf("time used = %v, expected %v", flat, tt.flat)
+			}
+
+			var cum []string
+			for _, m := range collectMetrics(c.timeUsedCum) {
+				fn, _ := functionLabel(t, m)
+				if value := counterValue(t, m); value != 10 {
+					t.Errorf("cumulated time used by %s = %f, expected 10", fn, value)
+				}
+				cum = append(cum, fn)
+			}
+			sort.Strings(cum)
+			expected := append([]string{}, tt.cumAll...)
+			sort.Strings(expected)
+			if !reflect.DeepEqual(cum, expected) {
+				t.Errorf("cumulated time used by %v, expected %v", cum, expected)
+			}
+		})
+	}
+}
+
+func TestCPUProfileCollectorFrameSymbolizer(t *testing.T) {
+	symbolizer := fakeFrameSymbolizer{0x1000: {"main.handle", "main.main"}}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbolizer(symbolizer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	p := testProfile(t, nil)
+	p.Location = []*profile.Location{{ID: 1, Address: 0x1000}}
+	p.Sample = []*profile.Sample{{Location: p.Location, Value: []int64{1, 10000000}}}
+	c.addProfile(p)
+
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.handle")); value != 10 {
+		t.Errorf("time used by main.handle = %f, expected 10", value)
+	}
+	for _, name := range []string{"main.handle", "main.main"} {
+		if value := counterValue(t, c.timeUsedCum.WithLabelValues(name)); value != 10 {
+			t.Errorf("cumulated time used by %s = %f, expected 10", name, value)
+		}
+	}
+}
+
+// fakeFrameSymbolizer resolves exactly the addresses it contains to the
+// functions inlined at them.
+type fakeFrameSymbolizer map[uint64][]string
+
+func (s fakeFrameSymbolizer) Resolve(addr uint64) (string, bool) {
+	names, ok := s[addr]
+	if !ok {
+		return "", false
+	}
+	return names[0], true
+}
+
+func (s fakeFrameSymbolizer) ResolveFrames(addr uint64) ([]string, bool) {
+	names, ok := s[addr]
+	return names, ok
+}
+
+func TestCPUProfileCollectorSourceLabels(t *testing.T) {
+	profileCollector, err := NewCPUProfileCollector(WithSymbolizer(fakeSymbolizer{0x2000: "main.main"}), WithSourceLabels(), WithLineLabel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	// (*a).String has been inlined into main.main, the location at 0x2000
+	// lacks line information.
+	p := testProfile(t, nil)
+	stringA := &profile.Function{ID: 1, Name: "main.(*a).String", Filename: "example.com/a.go"}
+	p.Function = []*profile.Function{stringA}
+	mainMain := &profile.Function{ID: 2, Name: "main.main", Filename: "example.com/main.go"}
+	p.Function = append(p.Function, mainMain)
+	p.Location = []*profile.Location{
+		{ID: 1, Address: 0x1000, Line: []profile.Line{{Function: stringA, Line: 12}, {Function: mainMain, Line: 40}}},
+		{ID: 2, Address: 0x2000},
+	}
+	p.Sample = []*profile.Sample{{Location: p.Location, Value: []int64{1, 10000000}}}
+	c.addProfile(p)
+
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.(*a).String", "example.com/a.go", "12")); value != 10 {
+		t.Errorf("time used by main.(*a).String = %f, expected 10", value)
+	}
+	for _, labels := range [][]string{
+		{"main.(*a).String", "example.com/a.go", "12"},
+		{"main.main", "example.com/main.go", "40"},
+		{"main.main", "unknown", "unknown"},
+	} {
+		if value := counterValue(t, c.timeUsedCum.WithLabelValues(labels...)); value != 10 {
+			t.Errorf("cumulated time used by %v = %f, expected 10", labels, value)
+		}
+	}
+}
+
+func TestCPUProfileCollectorProfileLabels(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithProfileLabels("tenant"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	p := testProfile(t, symbols, []string{"main.main"}, []string{"main.main"}, []string{"main.main"})
+	p.Sample[0].Label = map[string][]string{"tenant": {"acme"}, "request": {"42"}}
+	p.Sample[1].Label = map[string][]string{"tenant": {"acme"}}
+	c.addProfile(p)
+
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.main", "acme")); value != 20 {
+		t.Errorf("time used by tenant acme = %f, expected 20", value)
+	}
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.main", "")); value != 10 {
+		t.Errorf("time used without tenant = %f, expected 10", value)
+	}
+}
+
+func TestCPUProfileCollectorEdgeMetric(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.handle", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.walk", Addr: 0x1200, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithEdgeMetric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.addProfile(testProfile(t, symbols,
+		[]string{"main.walk", "main.walk", "main.handle", "main.main"},
+		[]string{"main.handle", "main.main"},
+	))
+
+	edges := make(map[[2]string]float64)
+	for _, m := range collectMetrics(c.edgeTime) {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		labels := make(map[string]string)
+		for _, l := range metric.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		edges[[2]string{labels["caller"], labels["callee"]}] = metric.GetCounter().GetValue()
+	}
+
+	expected := map[[2]string]float64{
+		{"main.main", "main.handle"}: 20,
+		{"main.handle", "main.walk"}: 10,
+	}
+	if !reflect.DeepEqual(edges, expected) {
+		t.Errorf("edges = %v, expected %v", edges, expected)
+	}
+}
+
+func TestCPUProfileCollectorFunctionFilter(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "github.com/example/project/pkg.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "github.com/example/project/pkg.wait", Addr: 0x1200, Size: 0x100, Code: 'T'},
+		{Name: "runtime.mallocgc", Addr: 0x1300, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols),
+		WithFunctionFilter(regexp.MustCompile(`^github\.com/example/|^runtime\.`), regexp.MustCompile(`\.wait$|^runtime\.`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.addProfile(testProfile(t, symbols,
+		[]string{"runtime.mallocgc", "github.com/example/project/pkg.compute", "main.main"},
+		[]string{"github.com/example/project/pkg.wait", "main.main"},
+	))
+
+	var functions []string
+	for _, m := range collectMetrics(c.timeUsedCum) {
+		fn, _ := functionLabel(t, m)
+		functions = append(functions, fn)
+	}
+	if !reflect.DeepEqual(functions, []string{"github.com/example/project/pkg.compute"}) {
+		t.Errorf("got functions %v, expected only github.com/example/project/pkg.compute", functions)
+	}
+	// both samples end in filtered functions.
+	if n := len(collectMetrics(c.timeUsed)); n != 1 {
+		t.Errorf("got %d flat series, expected only %s", n, otherFunction)
+	}
+	if value := counterValue(t, c.timeUsed.WithLabelValues(otherFunction)); value != 20 {
+		t.Errorf("time used by %s = %f, expected 20", otherFunction, value)
+	}
+	if value := counterValue(t, c.droppedSamples.WithLabelValues(reasonFiltered)); value != 2 {
+		t.Errorf("filtered samples = %f, expected 2", value)
+	}
+}
+
+func TestCPUProfileCollectorReset(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+	c, profiler := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Unix(0, 0)})
+
+	c.Start()
+	collectMetrics(c)
+	if n := len(collectMetrics(c.timeUsedCum)); n != 1 {
+		t.Fatalf("got %d cumulated series before reset, expected 1", n)
+	}
+
+	stops := profiler.stops
+	c.Reset()
+
+	for _, v := range []*prometheus.CounterVec{c.timeUsed, c.timeUsedCum, c.samples, c.edgeTime, c.droppedSamples} {
+		if n := len(collectMetrics(v)); n != 0 {
+			t.Errorf("got %d series after reset, expected none", n)
+		}
+	}
+	if profiler.stops != stops+1 || !profiler.running {
+		t.Error("pending profile data wasn't discarded by restarting the profiler")
+	}
+
+	// samples recorded after the reset are accounted again.
+	collectMetrics(c)
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != 10 {
+		t.Errorf("time used by main.main after reset = %f, expected 10", value)
+	}
+	c.Stop()
+}
+
+func TestCPUProfileCollectorIntervalGauges(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithIntervalGauges())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	// intervalValue returns the value of the flat interval gauge of main.main
+	// on a scrape, and whether the counters were exported as well.
+	intervalValue := func() (float64, bool) {
+		value, counters := 0.0, false
+		for _, m := range collectMetrics(c) {
+			desc := m.Desc().String()
+			if strings.Contains(desc, `"pprof_cpu_time_used_ms"`) || strings.Contains(desc, `"pprof_cpu_time_used_cum_ms"`) {
+				counters = true
+			}
+			if strings.Contains(desc, `"pprof_cpu_time_ms_interval"`) {
+				var metric dto.Metric
+				if err := m.Write(&metric); err != nil {
+					t.Fatal(err)
+				}
+				value = metric.GetGauge().GetValue()
+			}
+		}
+		return value, counters
+	}
+
+	for i, samples := range []int{2, 1, 0} {
+		var stacks [][]string
+		for j := 0; j < samples; j++ {
+			stacks = append(stacks, []string{"main.main"})
+		}
+		c.addProfile(testProfile(t, symbols, stacks...))
+
+		value, counters := intervalValue()
+		if expected := float64(10 * samples); value != expected {
+			t.Errorf("%d. interval time of main.main = %f, expected %f", i, value, expected)
+		}
+		if counters {
+			t.Errorf("%d. counters were exported in interval mode", i)
+		}
+	}
+}
+
+func TestCPUProfileCollectorFractionMetric(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithFractionMetric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	c.addProfile(testProfile(t, symbols, []string{"main.main"}, []string{"main.main"}))
+	c.addProfile(testProfile(t, symbols,
+		[]string{"main.compute", "main.main"},
+		[]string{"main.compute", "main.main"},
+		[]string{"main.compute", "main.main"},
+		[]string{"main.main"},
+	))
+
+	// only the most recent profile is taken into account.
+	fractions := make(map[string]float64)
+	for _, m := range collectMetrics(c.fraction) {
+		fn, _ := functionLabel(t, m)
+		fractions[fn] = gaugeValue(t, m)
+	}
+	if expected := map[string]float64{"main.compute": 0.75, "main.main": 0.25}; !reflect.DeepEqual(fractions, expected) {
+		t.Errorf("fractions = %v, expected %v", fractions, expected)
+	}
+}
+
+func TestCPUProfileCollectorBaseUnits(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithBaseUnits(), WithMinTime(15*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.addProfile(testProfile(t, symbols, []string{"main.main"}, []string{"main.main"}))
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, f := range families {
+		if strings.HasSuffix(f.GetName(), "_ms") {
+			t.Errorf("unexpected metric %s", f.GetName())
+		}
+		if f.GetName() != "pprof_cpu_time_used_seconds_total" {
+			continue
+		}
+		found = true
+		if help := f.GetHelp(); strings.Contains(help, "milliseconds") {
+			t.Errorf("help of %s = %q", f.GetName(), help)
+		}
+		if value := f.GetMetric()[0].GetCounter().GetValue(); value != 0.02 {
+			t.Errorf("time used = %v, expected 0.02", value)
+		}
+	}
+	if !found {
+		t.Errorf("no pprof_cpu_time_used_seconds_total found")
+	}
+
+	if stats := c.Snapshot()["main.main"]; stats.Flat != 20*time.Millisecond {
+		t.Errorf("flat = %v, expected 20ms", stats.Flat)
+	}
+}
+
+func TestCPUProfileCollectorErrorHandler(t *testing.T) {
+	var errs []error
+	c, prof := newFakeCollector(t, []Symbol{}, nil, &fakeClock{now: time.Unix(0, 0)}, WithErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	prof.data = []byte("garbage")
+	c.Start()
+	defer c.Stop()
+
+	// the scrape neither panics nor fails, and still exports the metrics
+	// that are valid.
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Gather(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "parsing CPU profile failed") {
+		t.Errorf("errors = %v", errs)
+	}
+	if value := counterValue(t, c.parseErrors); value != 1 {
+		t.Errorf("parse errors = %f, expected 1", value)
+	}
+}
+
+func TestCPUProfileCollectorSampleRate(t *testing.T) {
+	if _, err := NewCPUProfileCollector(WithSymbols([]Symbol{}), WithSampleRate(0)); err == nil {
+		t.Errorf("sampling rate 0 was accepted")
+	}
+	// the runtime's profiler would print a warning on every start.
+	if _, err := NewCPUProfileCollector(WithSymbols([]Symbol{}), WithSampleRate(250)); err == nil {
+		t.Errorf("sampling rate 250 was accepted for the runtime's profiler")
+	}
+	if _, err := NewCPUProfileCollector(WithSymbols([]Symbol{}), WithBroker(NewBroker()), WithSampleRate(250)); err == nil {
+		t.Errorf("sampling rate 250 was accepted for a broker")
+	}
+	if _, err := NewCPUProfileCollector(WithSymbols([]Symbol{}), WithSampleRate(250), WithNonDefaultSampleRate()); err != nil {
+		t.Errorf("sampling rate 250 was rejected with WithNonDefaultSampleRate: %v", err)
+	}
+
+	c, prof := newFakeCollector(t, []Symbol{}, nil, &fakeClock{now: time.Unix(0, 0)}, WithSampleRate(250), WithConstLabels(prometheus.Labels{"collector": "main"}))
+	c.Start()
+	defer c.Stop()
+
+	if prof.hz != 250 {
+		t.Errorf("profiler was started at %d Hz, expected 250", prof.hz)
+	}
+
+	metrics := collectMetrics(c.collectorInfo.gauge)
+	if len(metrics) != 1 {
+		t.Fatalf("got %d info metrics, expected 1", len(metrics))
+	}
+	var metric dto.Metric
+	if err := metrics[0].Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string]string)
+	for _, l := range metric.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if labels["collector"] != "main" || labels["sample_rate_hz"] != "250" {
+		t.Errorf("labels = %v, expected collector main and sample_rate_hz 250", labels)
+	}
+	if value := metric.GetGauge().GetValue(); value != 1 {
+		t.Errorf("value = %v, expected 1", value)
+	}
+}
+
+func TestCPUProfileCollectorSetSampleRate(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Unix(0, 0)})
+
+	if err := c.SetSampleRate(-1); err == nil {
+		t.Errorf("sampling rate -1 was accepted")
+	}
+
+	runtimeCollector := newCPUProfileCollector(newSymbolTable(symbols), newOptions([]Option{WithSymbols(symbols)}))
+	if err := runtimeCollector.SetSampleRate(500); err == nil {
+		t.Errorf("sampling rate 500 was accepted for the runtime's profiler")
+	}
+	if err := runtimeCollector.SetSampleRate(cpuProfileRate); err != nil {
+		t.Errorf("default sampling rate was rejected: %v", err)
+	}
+
+	c.Start()
+	defer c.Stop()
+	if err := c.SetSampleRate(500); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data recorded before the change is accounted.
+	if prof.hz != 500 || prof.stops != 1 {
+		t.Errorf("profiler runs at %d Hz after %d stops", prof.hz, prof.stops)
+	}
+	metrics := collectMetrics(c.timeUsed)
+	if len(metrics) != 1 || counterValue(t, metrics[0]) != 10 {
+		t.Errorf("time used wasn't accounted: %v", metrics)
+	}
+	metrics = collectMetrics(c.collectorInfo.gauge)
+	if len(metrics) != 1 {
+		t.Fatalf("got %d info metrics, expected 1", len(metrics))
+	}
+	var metric dto.Metric
+	if err := metrics[0].Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string]string)
+	for _, l := range metric.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if value := labels[sampleRateLabel]; value != "500" {
+		t.Errorf("sample rate label = %q, expected 500", value)
+	}
+}
+
+func TestCPUProfileCollectorStartContext(t *testing.T) {
+	c, _ := newFakeCollector(t, []Symbol{}, nil, &fakeClock{now: time.Unix(0, 0)})
+
+	running := func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return c.running
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.StartContext(ctx)
+	if !running() {
+		t.Fatalf("collector isn't running")
+	}
+	cancel()
+	waitFor(t, func() bool { return !running() })
+
+	// a context of a previous run doesn't stop the next one.
+	ctx, cancel = context.WithCancel(context.Background())
+	c.StartContext(ctx)
+	c.Stop()
+	c.Start()
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if !running() {
+		t.Errorf("the context of the previous run stopped the collector")
+	}
+	c.Stop()
+}
+
+func TestCPUProfileCollectorStartFor(t *testing.T) {
+	clk := &fakeClock{now: time.Unix(0, 0), ticker: newFakeTicker()}
+	c, _ := newFakeCollector(t, []Symbol{}, nil, clk)
+
+	running := func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return c.running
+	}
+
+	c.StartFor(time.Minute)
+	if !running() {
+		t.Fatalf("collector isn't running")
+	}
+
+	clk.ticker.c <- time.Time{}
+	waitFor(t, func() bool { return !running() })
+	if value := counterValue(t, c.stopped); value != 1 {
+		t.Errorf("stopped = %f, expected 1", value)
+	}
+}
+
+func TestCPUProfileCollectorAutoStart(t *testing.T) {
+	c, prof := newFakeCollector(t, []Symbol{}, nil, &fakeClock{now: time.Unix(0, 0)}, WithAutoStart())
+
+	if err := prometheus.NewRegistry().Register(c); err != nil {
+		t.Fatal(err)
+	}
+	if value := counterValue(t, c.started); value != 1 || !prof.running {
+		t.Fatalf("collector wasn't started on registration: started = %f", value)
+	}
+
+	c.Stop()
+	if err := prometheus.NewRegistry().Register(c); err != nil {
+		t.Fatal(err)
+	}
+	if value := counterValue(t, c.started); value != 1 || prof.running {
+		t.Errorf("collector was started again on registration: started = %f", value)
+	}
+}
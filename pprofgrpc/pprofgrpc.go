@@ -0,0 +1,58 @@
+// Package pprofgrpc provides gRPC server interceptors that attribute the CPU
+// time of RPCs to their methods in the metrics of pprofetheus.
+//
+// The interceptors run the handlers with the profiler label "grpc_method" set
+// to the full method name, which the CPU profile collector exports as label
+// with pprofetheus.WithProfileLabels:
+//
+//	collector, err := pprofetheus.NewCPUProfileCollector(pprofetheus.WithProfileLabels(pprofgrpc.MethodLabel))
+//	/* ... */
+//	server := grpc.NewServer(
+//		grpc.UnaryInterceptor(pprofgrpc.UnaryServerInterceptor()),
+//		grpc.StreamInterceptor(pprofgrpc.StreamServerInterceptor()),
+//	)
+package pprofgrpc
+
+import (
+	"context"
+	"runtime/pprof"
+
+	"google.golang.org/grpc"
+)
+
+// MethodLabel is the key of the profiler label that the interceptors set to
+// the full method name of the RPC, e.g. "/helloworld.Greeter/SayHello".
+const MethodLabel = "grpc_method"
+
+// UnaryServerInterceptor returns an interceptor that runs unary RPCs with the
+// profiler label MethodLabel set to their full method name.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		pprof.Do(ctx, pprof.Labels(MethodLabel, info.FullMethod), func(ctx context.Context) {
+			resp, err = handler(ctx, req)
+		})
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns an interceptor that runs streaming RPCs with
+// the profiler label MethodLabel set to their full method name.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		pprof.Do(ss.Context(), pprof.Labels(MethodLabel, info.FullMethod), func(ctx context.Context) {
+			err = handler(srv, labeledServerStream{ss, ctx})
+		})
+		return err
+	}
+}
+
+// labeledServerStream is a grpc.ServerStream whose context carries the
+// profiler labels.
+type labeledServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s labeledServerStream) Context() context.Context {
+	return s.ctx
+}
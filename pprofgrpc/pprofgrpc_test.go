@@ -0,0 +1,49 @@
+package pprofgrpc
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+const testMethod = "/helloworld.Greeter/SayHello"
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+	resp, err := UnaryServerInterceptor()(context.Background(), "request", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		method, _ := pprof.Label(ctx, MethodLabel)
+		return method, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != testMethod {
+		t.Errorf("method label = %v, expected %s", resp, testMethod)
+	}
+}
+
+// fakeServerStream is a grpc.ServerStream that only provides a context.
+type fakeServerStream struct {
+	grpc.ServerStream
+}
+
+func (fakeServerStream) Context() context.Context {
+	return context.Background()
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	var method string
+	info := &grpc.StreamServerInfo{FullMethod: testMethod}
+	err := StreamServerInterceptor()(nil, fakeServerStream{}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		method, _ = pprof.Label(ss.Context(), MethodLabel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != testMethod {
+		t.Errorf("method label = %q, expected %s", method, testMethod)
+	}
+}
@@ -0,0 +1,116 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// profiler is the source of CPU profile data.
+type profiler interface {
+	// Start starts profiling with a rate of hz samples per second. It
+	// returns an error if the profiler can't be used.
+	Start(hz int) error
+	// Stop stops profiling and returns the profile data that was recorded
+	// since the last call to Start.
+	Stop() []byte
+}
+
+// runtimeProfiler is the profiler that is built into the Go runtime. It
+// records the profile with runtime/pprof into a buffer, which is read and
+// replaced by stopping and starting the profile.
+type runtimeProfiler struct {
+	buf *bytes.Buffer
+	// running is true if Start succeeded, so that Stop doesn't stop a
+	// profile that was started by other code.
+	running bool
+}
+
+func newRuntimeProfiler() *runtimeProfiler {
+	return &runtimeProfiler{buf: new(bytes.Buffer)}
+}
+
+func (p *runtimeProfiler) Start(hz int) error {
+	// StartCPUProfile always sets the rate to 100 samples per second. If
+	// the rate has been set before, its own attempt fails and the profile
+	// is recorded with the rate hz, though the runtime prints a warning
+	// about the failed attempt. Other rates are therefore only used with
+	// WithNonDefaultSampleRate, see checkSampleRate.
+	if hz != cpuProfileRate {
+		runtime.SetCPUProfileRate(hz)
+	}
+	err := pprof.StartCPUProfile(p.buf)
+	p.running = err == nil
+	return err
+}
+
+func (p *runtimeProfiler) Stop() []byte {
+	if !p.running {
+		return nil
+	}
+	pprof.StopCPUProfile()
+	p.running = false
+	data := p.buf.Bytes()
+	// the data is passed on, so the next profile needs a new buffer.
+	p.buf = new(bytes.Buffer)
+	return data
+}
+
+// usesRuntimeProfiler returns true if the profile data of p is recorded by
+// the CPU profiler of the runtime, directly or through a Broker.
+func usesRuntimeProfiler(p profiler) bool {
+	switch p := p.(type) {
+	case *runtimeProfiler:
+		return true
+	case *brokerProfiler:
+		_, ok := p.broker.profiler.(*runtimeProfiler)
+		return ok
+	}
+	return false
+}
+
+// checkSampleRate returns an error if the profiler can't be started at hz
+// samples per second. The runtime's profiler prints a warning whenever it is
+// started at a rate other than cpuProfileRate, so such rates must be
+// allowed with WithNonDefaultSampleRate.
+func (o *options) checkSampleRate(hz int) error {
+	if hz <= 0 {
+		return fmt.Errorf("invalid sampling rate %d", hz)
+	}
+	if hz != cpuProfileRate && !o.nonDefaultSampleRate && usesRuntimeProfiler(o.profiler) {
+		return fmt.Errorf("sampling rate %d requires WithNonDefaultSampleRate, the runtime only supports %d without printing a warning on every start", hz, cpuProfileRate)
+	}
+	return nil
+}
+
+// clock is the source of the current time and of tickers.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker delivers ticks on its channel at intervals.
+type ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
@@ -0,0 +1,60 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func TestRuntimeProfiler(t *testing.T) {
+	p := newRuntimeProfiler()
+	if err := p.Start(cpuProfileRate); err != nil {
+		t.Fatal(err)
+	}
+	// only one CPU profile can be recorded at a time.
+	if err := newRuntimeProfiler().Start(cpuProfileRate); err == nil {
+		t.Error("starting a second profiler succeeded")
+	}
+
+	for i := 0; i < 2; i++ {
+		data := p.Stop()
+		prof, err := profile.ParseData(data)
+		if err != nil {
+			t.Fatalf("%d. parsing profile failed: %v", i, err)
+		}
+		if _, ok := valueIndex(prof, cpuSampleType); !ok {
+			t.Errorf("%d. profile has no sample type %q", i, cpuSampleType)
+		}
+		if i == 0 {
+			// the profile is rotated by starting the profiler again.
+			if err := p.Start(cpuProfileRate); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRuntimeProfilerFailedStart(t *testing.T) {
+	// a profile started by other code, e.g. net/http/pprof.
+	var other bytes.Buffer
+	if err := pprof.StartCPUProfile(&other); err != nil {
+		t.Fatal(err)
+	}
+	defer pprof.StopCPUProfile()
+
+	p := newRuntimeProfiler()
+	if err := p.Start(cpuProfileRate); err == nil {
+		t.Fatal("starting the profiler succeeded while another profile is running")
+	}
+	if data := p.Stop(); data != nil {
+		t.Errorf("Stop returned %d bytes of a profile that wasn't started", len(data))
+	}
+
+	// the other profile is still running.
+	if err := pprof.StartCPUProfile(new(bytes.Buffer)); err == nil {
+		pprof.StopCPUProfile()
+		t.Error("the other profile was stopped")
+	}
+}
@@ -0,0 +1,120 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+const (
+	// reasonBusy is the reason of a push error for a profile that has been
+	// skipped because the previous push hasn't finished yet.
+	reasonBusy   = "busy"
+	reasonEncode = "encode"
+)
+
+// profilePusher pushes profiles to a profiling backend, one at a time.
+type profilePusher struct {
+	backend string
+	// send sends the profile data covering from until until, recorded with
+	// a sampling rate of hz unless it is 0. On failure, it also returns the
+	// reason that the failure is metered with.
+	send    func(from, until time.Time, hz int, data []byte) (string, error)
+	log     Logger
+	pushing int32
+	pushes  prometheus.Counter
+	errors  *prometheus.CounterVec
+}
+
+// newProfilePusher creates a pusher to the backend with the given name, e.g.
+// "Pyroscope", whose metrics are prefixed with prefix, e.g. "pyroscope".
+func newProfilePusher(o *options, prefix, backend string, send func(from, until time.Time, hz int, data []byte) (string, error)) *profilePusher {
+	return &profilePusher{
+		backend: backend,
+		send:    send,
+		log:     o.log,
+		pushes: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        prefix + "_pushes_total",
+				Help:        o.help(prefix+"_pushes_total", "number of CPU profiles pushed to "+backend),
+				ConstLabels: o.constLabels,
+			},
+		),
+		errors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        prefix + "_push_errors_total",
+				Help:        o.help(prefix+"_push_errors_total", "number of CPU profiles that couldn't be pushed to "+backend+", by reason"),
+				ConstLabels: o.constLabels,
+			},
+			[]string{"reason"},
+		),
+	}
+}
+
+func (p *profilePusher) Describe(ch chan<- *prometheus.Desc) {
+	p.pushes.Describe(ch)
+	p.errors.Describe(ch)
+}
+
+func (p *profilePusher) Collect(ch chan<- prometheus.Metric) {
+	p.pushes.Collect(ch)
+	p.errors.Collect(ch)
+}
+
+// push pushes the profile prof, read at time now with a sampling rate of hz
+// unless it is unknown, in the background. data is its raw data, which is
+// pushed as it is unless the profile needs to be symbolized with symbolizer.
+func (p *profilePusher) push(now time.Time, hz int, prof *profile.Profile, data []byte, symbolizer Symbolizer) {
+	if !atomic.CompareAndSwapInt32(&p.pushing, 0, 1) {
+		p.errors.WithLabelValues(reasonBusy).Inc()
+		return
+	}
+
+	if !symbolized(prof) {
+		// the profile may change after this, e.g. by being merged into a
+		// dump, so it is written right away.
+		symbolize(prof, symbolizer)
+		var buf bytes.Buffer
+		if err := prof.Write(&buf); err != nil {
+			atomic.StoreInt32(&p.pushing, 0)
+			p.errors.WithLabelValues(reasonEncode).Inc()
+			p.log(LevelWarn, "writing profile for "+p.backend+" failed", "err", err)
+			return
+		}
+		data = buf.Bytes()
+	}
+
+	from := now.Add(-time.Duration(prof.DurationNanos))
+	if prof.TimeNanos != 0 {
+		from = time.Unix(0, prof.TimeNanos)
+	}
+
+	go func() {
+		reason, err := p.send(from, now, hz, data)
+		atomic.StoreInt32(&p.pushing, 0)
+		if err != nil {
+			p.errors.WithLabelValues(reason).Inc()
+			p.log(LevelWarn, "pushing profile to "+p.backend+" failed", "err", err)
+			return
+		}
+		p.pushes.Inc()
+	}()
+}
+
+// symbolized returns true if all locations of the profile p have function
+// information.
+func symbolized(p *profile.Profile) bool {
+	for _, l := range p.Location {
+		if len(l.Line) == 0 {
+			return false
+		}
+	}
+	return true
+}
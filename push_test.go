@@ -0,0 +1,21 @@
+package pprofetheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func TestProfilePusherBusy(t *testing.T) {
+	p := newProfilePusher(newOptions(nil), "test", "test", func(from, until time.Time, hz int, data []byte) (string, error) {
+		t.Error("busy pusher sent a profile")
+		return "", nil
+	})
+	p.pushing = 1
+
+	p.push(time.Unix(1500000000, 0), 100, &profile.Profile{}, nil, nil)
+	if value := counterValue(t, p.errors.WithLabelValues(reasonBusy)); value != 1 {
+		t.Errorf("busy push errors = %f, expected 1", value)
+	}
+}
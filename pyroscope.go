@@ -0,0 +1,131 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPyroscopeTimeout is the timeout for pushing a profile to Pyroscope.
+const defaultPyroscopeTimeout = 10 * time.Second
+
+// PyroscopeConfig configures pushing profiles to a Pyroscope server with
+// WithPyroscope.
+type PyroscopeConfig struct {
+	// URL is the base URL of the server, e.g. "http://pyroscope:4040".
+	URL string
+	// AppName is the name of the application that the profiles are stored
+	// under, e.g. "checkout".
+	AppName string
+	// Labels are added to the profiles, e.g. {"env": "production"}.
+	Labels map[string]string
+	// AuthToken is sent as bearer token unless it is empty.
+	AuthToken string
+	// BasicAuthUser and BasicAuthPassword are sent as basic authentication
+	// unless the user is empty, e.g. for Grafana Cloud.
+	BasicAuthUser     string
+	BasicAuthPassword string
+}
+
+// WithPyroscope makes the collector push every profile that it reads to the
+// Pyroscope server configured by cfg in addition to exporting the metrics, for
+// continuous profiling with a single integration. Profiles are pushed in the
+// background and symbolized if they lack function names. A push that is still
+// in progress when the next profile is read makes the collector skip that
+// profile, so that a slow server can't hold it up. Pushes and their errors are
+// counted in pprof_cpu_pyroscope_pushes_total and
+// pprof_cpu_pyroscope_push_errors_total. The HTTP client set with
+// WithHTTPClient is used if any.
+func WithPyroscope(cfg PyroscopeConfig) Option {
+	return func(o *options) {
+		o.pyroscope = &cfg
+	}
+}
+
+// pyroscopeSender sends profiles to a Pyroscope server.
+type pyroscopeSender struct {
+	cfg    PyroscopeConfig
+	name   string
+	client *http.Client
+}
+
+func newPyroscopePusher(o *options) *profilePusher {
+	client := o.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultPyroscopeTimeout}
+	}
+	s := &pyroscopeSender{
+		cfg:    *o.pyroscope,
+		name:   pyroscopeName(o.pyroscope.AppName, o.pyroscope.Labels),
+		client: client,
+	}
+	return newProfilePusher(o, "pyroscope", "Pyroscope", s.send)
+}
+
+// pyroscopeName returns the name that Pyroscope stores profiles of the
+// application app with the labels under, e.g. "checkout{env=production}".
+func pyroscopeName(app string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return app
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return app + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// send sends the profile data to the server, see profilePusher.send.
+func (p *pyroscopeSender) send(from, until time.Time, hz int, data []byte) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return reasonRequest, err
+	}
+	part.Write(data)
+	if err := w.Close(); err != nil {
+		return reasonRequest, err
+	}
+
+	query := url.Values{}
+	query.Set("name", p.name)
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	if hz > 0 {
+		query.Set("sampleRate", strconv.Itoa(hz))
+	}
+	query.Set("spyName", "gospy")
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(p.cfg.URL, "/")+"/ingest?"+query.Encode(), &body)
+	if err != nil {
+		return reasonRequest, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if p.cfg.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.AuthToken)
+	}
+	if p.cfg.BasicAuthUser != "" {
+		req.SetBasicAuth(p.cfg.BasicAuthUser, p.cfg.BasicAuthPassword)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return reasonRequest, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return reasonStatus, fmt.Errorf("pushing to %s failed: %s", p.cfg.URL, resp.Status)
+	}
+	return "", nil
+}
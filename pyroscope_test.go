@@ -0,0 +1,71 @@
+package pprofetheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func TestCPUProfileCollectorPyroscope(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	requests := make(chan *http.Request, 1)
+	profiles := make(chan *profile.Profile, 1)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, _, err := r.FormFile("profile")
+		if err != nil {
+			t.Errorf("reading profile failed: %v", err)
+			return
+		}
+		p, err := profile.Parse(f)
+		if err != nil {
+			t.Errorf("parsing profile failed: %v", err)
+		}
+		w.WriteHeader(status)
+		requests <- r
+		profiles <- p
+	}))
+	defer server.Close()
+
+	c, _ := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Unix(1500000000, 0)}, WithPyroscope(PyroscopeConfig{
+		URL:       server.URL + "/",
+		AppName:   "checkout",
+		Labels:    map[string]string{"region": "eu", "env": "production"},
+		AuthToken: "secret",
+	}))
+
+	c.Start()
+	defer c.Stop()
+	c.Flush()
+
+	r := <-requests
+	if r.URL.Path != "/ingest" {
+		t.Errorf("path = %s, expected /ingest", r.URL.Path)
+	}
+	if name := r.URL.Query().Get("name"); name != "checkout{env=production,region=eu}" {
+		t.Errorf("name = %s, expected checkout{env=production,region=eu}", name)
+	}
+	if until := r.URL.Query().Get("until"); until != "1500000000" {
+		t.Errorf("until = %s, expected 1500000000", until)
+	}
+	if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("authorization = %q, expected bearer token", auth)
+	}
+	// the profile has been symbolized before it was pushed.
+	if p := <-profiles; len(p.Function) != 1 || p.Function[0].Name != "main.main" {
+		t.Errorf("pushed profile has functions %v, expected main.main", p.Function)
+	}
+	waitFor(t, func() bool { return counterValue(t, c.pushers[0].pushes) == 1 })
+
+	status = http.StatusUnauthorized
+	c.Flush()
+	<-requests
+	<-profiles
+	waitFor(t, func() bool { return counterValue(t, c.pushers[0].errors.WithLabelValues(reasonStatus)) == 1 })
+}
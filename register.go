@@ -0,0 +1,33 @@
+package pprofetheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WithRegisterer sets the registerer that MustRegisterAndStart registers the
+// collector with, which is prometheus.DefaultRegisterer by default. This
+// allows keeping the collector in a registry of its own, e.g. one per
+// listener or an isolated one in tests.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registerer = reg
+	}
+}
+
+// MustRegisterAndStart creates a CPU profile collector like
+// NewCPUProfileCollector, registers it with the registerer set with
+// WithRegisterer and starts it. It panics if the collector can't be created
+// or registered.
+func MustRegisterAndStart(opts ...Option) ProfileCollector {
+	c, err := NewCPUProfileCollector(opts...)
+	if err != nil {
+		panic(err)
+	}
+	reg := newOptions(opts).registerer
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	reg.MustRegister(c)
+	c.Start()
+	return c
+}
@@ -0,0 +1,44 @@
+package pprofetheus
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMustRegisterAndStart(t *testing.T) {
+	prof := &fakeProfiler{}
+	reg := prometheus.NewRegistry()
+	opts := []Option{
+		WithSymbols([]Symbol{}),
+		WithDrainInterval(0),
+		WithRegisterer(reg),
+		func(o *options) { o.profiler = prof },
+	}
+
+	c := MustRegisterAndStart(opts...)
+	defer c.Stop()
+	if !prof.running {
+		t.Error("profiler not running after MustRegisterAndStart")
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started float64
+	for _, f := range families {
+		if f.GetName() == "pprof_cpu_started" {
+			started = f.Metric[0].GetCounter().GetValue()
+		}
+	}
+	if started != 1 {
+		t.Errorf("pprof_cpu_started = %f, expected 1", started)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MustRegisterAndStart didn't panic on a second registration with the same registerer")
+		}
+	}()
+	MustRegisterAndStart(opts...)
+}
@@ -0,0 +1,153 @@
+package pprofetheus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultRemoteTimeout is the timeout for fetching a remote CPU profile. It has
+// to exceed the profiling duration requested from the remote process.
+const defaultRemoteTimeout = time.Minute
+
+const (
+	reasonRequest = "request"
+	reasonStatus  = "status"
+	reasonRead    = "read"
+)
+
+// NewRemoteCPUProfileCollector creates a collector that exports the CPU
+// profile of another process, e.g. an application exposing net/http/pprof
+// next to a sidecar. On every scrape, a CPU profile is fetched from profileURL,
+// e.g. "http://app:6060/debug/pprof/profile?seconds=10", and resolved using the
+// symbols of the binary at binaryPath. The same metrics as the ones of
+// NewCPUProfileCollector are exported. Options are applied after binaryPath.
+func NewRemoteCPUProfileCollector(profileURL string, binaryPath string, opts ...Option) (prometheus.Collector, error) {
+	o := newOptions(append([]Option{WithBinaryPath(binaryPath)}, opts...))
+	// the runtime only knows the functions of the current process.
+	o.runtimeSymbols = false
+
+	symbolizer, err := newSymbolizer(o)
+	if err != nil {
+		return nil, err
+	}
+
+	client := o.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultRemoteTimeout}
+	}
+
+	return &remoteCPUProfileCollector{
+		cpuProfileCollector: newCPUProfileCollector(symbolizer, o),
+		profileURL:          profileURL,
+		client:              client,
+		fetchErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "remote_fetch_errors_total",
+				Help:        o.help("remote_fetch_errors_total", "number of failed attempts to fetch a remote CPU profile, by reason"),
+				ConstLabels: o.constLabels,
+			},
+			[]string{"reason"},
+		),
+	}, nil
+}
+
+type remoteCPUProfileCollector struct {
+	*cpuProfileCollector
+	profileURL  string
+	client      *http.Client
+	fetchErrors *prometheus.CounterVec
+}
+
+func (c *remoteCPUProfileCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.cpuProfileCollector.Describe(ch)
+	c.fetchErrors.Describe(ch)
+}
+
+func (c *remoteCPUProfileCollector) Collect(ch chan<- prometheus.Metric) {
+	if data, reason, err := c.fetch(); err != nil {
+		c.fetchErrors.WithLabelValues(reason).Inc()
+		c.opts.log(LevelWarn, "fetching remote profile failed", "url", c.profileURL, "err", err)
+	} else {
+		c.Lock()
+		c.addData(data)
+		c.Unlock()
+	}
+
+	c.cpuProfileCollector.Collect(ch)
+	c.fetchErrors.Collect(ch)
+}
+
+// fetch fetches the remote CPU profile. On failure, it also returns the reason
+// that the failure is metered with.
+func (c *remoteCPUProfileCollector) fetch() ([]byte, string, error) {
+	return fetchProfile(c.client, c.profileURL)
+}
+
+// remoteProfileMetrics are the metrics of the profiles that
+// NewRemoteProfileCollector supports, by name.
+var remoteProfileMetrics = map[string]runtimeProfileMetric{
+	"allocs":       allocsProfileMetric,
+	"block":        blockProfileMetric,
+	"mutex":        mutexProfileMetric,
+	"threadcreate": threadCreateProfileMetric,
+}
+
+var allocsProfileMetric = runtimeProfileMetric{
+	subsystem: heapSubsystem,
+	name:      "alloc_bytes_total",
+	help:      "counter of bytes of heap memory allocated",
+	valueType: "alloc_space",
+	divisor:   1,
+}
+
+// NewRemoteProfileCollector creates a collector that exports a profile that
+// the runtime of another process maintains since its start, like
+// NewRemoteCPUProfileCollector does for the CPU profile. name is the name of
+// the profile, "allocs", "block", "mutex" or "threadcreate", which is fetched
+// from profileURL on every scrape, e.g. "http://app:6060/debug/pprof/block".
+// The metrics are the ones of NewBlockProfileCollector and the like; the
+// allocations are exported as pprof_heap_alloc_bytes_total. Functions are
+// resolved using the profile's own function information, which the runtime
+// always writes, unless a symbolizer is set with WithSymbolizer.
+func NewRemoteProfileCollector(profileURL, name string, opts ...Option) (prometheus.Collector, error) {
+	m, ok := remoteProfileMetrics[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported profile %q", name)
+	}
+	o := newOptions(opts)
+
+	client := o.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultRemoteTimeout}
+	}
+	return newRuntimeProfileCollector(func() ([]byte, error) {
+		data, _, err := fetchProfile(client, profileURL)
+		return data, err
+	}, m, o), nil
+}
+
+// fetchProfile fetches the profile at profileURL with client. On failure, it
+// also returns the reason that the failure is metered with.
+func fetchProfile(client *http.Client, profileURL string) ([]byte, string, error) {
+	resp, err := client.Get(profileURL)
+	if err != nil {
+		return nil, reasonRequest, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, reasonStatus, fmt.Errorf("fetching %s failed: %s", profileURL, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, reasonRead, err
+	}
+	return data, "", nil
+}
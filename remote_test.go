@@ -0,0 +1,130 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+func TestRemoteCPUProfileCollector(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	var data bytes.Buffer
+	p := testProfile(t, symbols, []string{"main.compute", "main.main"}, []string{"main.main"})
+	if err := p.Write(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/pprof/profile" || r.URL.Query().Get("seconds") != "1" {
+			t.Errorf("unexpected request for %s", r.URL)
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write(data.Bytes())
+		}
+	}))
+	defer ts.Close()
+
+	collector, err := NewRemoteCPUProfileCollector(ts.URL+"/debug/pprof/profile?seconds=1", "/nonexistent", WithSymbols(symbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := collector.(*remoteCPUProfileCollector)
+
+	collectMetrics(c)
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.compute")); value != 10 {
+		t.Errorf("time used by main.compute = %f, expected 10", value)
+	}
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != 10 {
+		t.Errorf("time used by main.main = %f, expected 10", value)
+	}
+	if value := counterValue(t, c.timeUsedCum.WithLabelValues("main.main")); value != 20 {
+		t.Errorf("cumulated time used by main.main = %f, expected 20", value)
+	}
+
+	status = http.StatusInternalServerError
+	collectMetrics(c)
+	if value := counterValue(t, c.fetchErrors.WithLabelValues(reasonStatus)); value != 1 {
+		t.Errorf("fetch errors due to status = %f, expected 1", value)
+	}
+	if value := counterValue(t, c.timeUsedCum.WithLabelValues("main.main")); value != 20 {
+		t.Errorf("cumulated time used by main.main = %f after failed fetch, expected 20", value)
+	}
+}
+
+func TestRemoteCPUProfileCollectorTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+	collector, err := NewRemoteCPUProfileCollector(ts.URL, "/nonexistent", WithSymbols([]Symbol{}), WithHTTPClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := collector.(*remoteCPUProfileCollector)
+
+	collectMetrics(c)
+	if value := counterValue(t, c.fetchErrors.WithLabelValues(reasonRequest)); value != 1 {
+		t.Errorf("fetch errors due to request = %f, expected 1", value)
+	}
+}
+
+func TestRemoteProfileCollector(t *testing.T) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "contentions", Unit: "count"},
+			{Type: "delay", Unit: "nanoseconds"},
+		},
+		Function: []*profile.Function{{ID: 1, Name: "main.main"}},
+	}
+	p.Location = []*profile.Location{{ID: 1, Address: 0x1000, Line: []profile.Line{{Function: p.Function[0]}}}}
+	p.Sample = []*profile.Sample{{Location: p.Location, Value: []int64{1, 20000000}}}
+
+	var data bytes.Buffer
+	if err := p.Write(&data); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/pprof/mutex" {
+			t.Errorf("unexpected request for %s", r.URL)
+		}
+		w.Write(data.Bytes())
+	}))
+	defer ts.Close()
+
+	collector, err := NewRemoteProfileCollector(ts.URL+"/debug/pprof/mutex", "mutex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := collector.(*runtimeProfileCollector)
+
+	var found bool
+	for _, m := range collectMetrics(c) {
+		if m.Desc() == c.value {
+			found = true
+			if value := counterValue(t, m); value != 20 {
+				t.Errorf("wait time of main.main = %f, expected 20", value)
+			}
+		}
+	}
+	if !found {
+		t.Error("no wait time reported")
+	}
+
+	if _, err := NewRemoteProfileCollector(ts.URL, "goroutine"); err == nil {
+		t.Error("NewRemoteProfileCollector succeeded with an unsupported profile")
+	}
+}
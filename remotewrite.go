@@ -0,0 +1,249 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// defaultRemoteWriteTimeout is the timeout for sending the metrics to a
+// remote write endpoint.
+const defaultRemoteWriteTimeout = 30 * time.Second
+
+// WithRemoteWrite makes the collector send its metrics to the Prometheus remote
+// write endpoint at url, e.g. "http://prometheus:9090/api/v1/write", when it is
+// stopped and every interval while it is running unless interval is 0. It is
+// meant for batch jobs and short-lived workers that don't live long enough to
+// be scraped, which should call Stop before they exit. Sending the metrics has
+// the same effect on them as a scrape, e.g. on the interval gauges. The HTTP
+// client set with WithHTTPClient is used if any, e.g. to authenticate. Writes
+// and their errors are counted in pprof_cpu_remote_writes_total and
+// pprof_cpu_remote_write_errors_total.
+func WithRemoteWrite(url string, interval time.Duration) Option {
+	return func(o *options) {
+		o.remoteWriteURL = url
+		o.remoteWriteInterval = interval
+	}
+}
+
+// remoteWriter sends metrics to a remote write endpoint.
+type remoteWriter struct {
+	url     string
+	client  *http.Client
+	log     Logger
+	writing int32
+	writes  prometheus.Counter
+	errors  prometheus.Counter
+}
+
+func newRemoteWriter(o *options) *remoteWriter {
+	client := o.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultRemoteWriteTimeout}
+	}
+	return &remoteWriter{
+		url:    o.remoteWriteURL,
+		client: client,
+		log:    o.log,
+		writes: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "remote_writes_total",
+				Help:        o.help("remote_writes_total", "number of times the metrics of the CPU profile collector have been sent to the remote write endpoint"),
+				ConstLabels: o.constLabels,
+			},
+		),
+		errors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(cpuSubsystem),
+				Name:        "remote_write_errors_total",
+				Help:        o.help("remote_write_errors_total", "number of times the metrics of the CPU profile collector couldn't be sent to the remote write endpoint"),
+				ConstLabels: o.constLabels,
+			},
+		),
+	}
+}
+
+func (w *remoteWriter) Describe(ch chan<- *prometheus.Desc) {
+	w.writes.Describe(ch)
+	w.errors.Describe(ch)
+}
+
+func (w *remoteWriter) Collect(ch chan<- prometheus.Metric) {
+	w.writes.Collect(ch)
+	w.errors.Collect(ch)
+}
+
+// remoteWriteMetrics collects the metrics of the collector to be sent to the
+// remote write endpoint. It has to be called with the collector locked.
+func (c *cpuProfileCollector) remoteWriteMetrics() []prometheus.Metric {
+	return snapshotMetrics(c.collect)
+}
+
+// remoteWriteInBackground sends the metrics to the remote write endpoint in the
+// background, unless the previous write is still in progress. It has to be
+// called with the collector locked.
+func (c *cpuProfileCollector) remoteWriteInBackground() {
+	w := c.remoteWriter
+	if !atomic.CompareAndSwapInt32(&w.writing, 0, 1) {
+		return
+	}
+	metrics := c.remoteWriteMetrics()
+	now := c.opts.clock.Now()
+	go func() {
+		defer atomic.StoreInt32(&w.writing, 0)
+		w.write(now, metrics)
+	}()
+}
+
+// write sends the metrics, stamped with the time now, to the remote write
+// endpoint.
+func (w *remoteWriter) write(now time.Time, metrics []prometheus.Metric) {
+	if err := w.send(now, metrics); err != nil {
+		w.errors.Inc()
+		w.log(LevelWarn, "sending metrics to remote write endpoint failed", "url", w.url, "err", err)
+		return
+	}
+	w.writes.Inc()
+}
+
+func (w *remoteWriter) send(now time.Time, metrics []prometheus.Metric) error {
+	families, err := gatherMetrics(metrics)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(snappyEncode(encodeWriteRequest(families, now))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sending to %s failed: %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+// metricList is an unchecked collector of previously collected metrics.
+type metricList []prometheus.Metric
+
+func (l metricList) Describe(ch chan<- *prometheus.Desc) {}
+
+func (l metricList) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range l {
+		ch <- m
+	}
+}
+
+// encodeWriteRequest encodes the metric families as a WriteRequest of the
+// remote write protocol, with all samples at time now.
+func encodeWriteRequest(families []*dto.MetricFamily, now time.Time) []byte {
+	ts := now.UnixNano() / int64(time.Millisecond)
+
+	var req []byte
+	series := func(name string, labels []*dto.LabelPair, value float64, extra ...string) {
+		pairs := [][2]string{{"__name__", name}}
+		for _, l := range labels {
+			pairs = append(pairs, [2]string{l.GetName(), l.GetValue()})
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			pairs = append(pairs, [2]string{extra[i], extra[i+1]})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+
+		var s []byte
+		for _, p := range pairs {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, p[0])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, p[1])
+			s = protowire.AppendTag(s, 1, protowire.BytesType)
+			s = protowire.AppendBytes(s, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(ts))
+		s = protowire.AppendTag(s, 2, protowire.BytesType)
+		s = protowire.AppendBytes(s, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, s)
+	}
+
+	for _, f := range families {
+		name := f.GetName()
+		for _, m := range f.Metric {
+			switch {
+			case m.Counter != nil:
+				series(name, m.Label, m.Counter.GetValue())
+			case m.Gauge != nil:
+				series(name, m.Label, m.Gauge.GetValue())
+			case m.Untyped != nil:
+				series(name, m.Label, m.Untyped.GetValue())
+			case m.Histogram != nil:
+				for _, b := range m.Histogram.Bucket {
+					series(name+"_bucket", m.Label, float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				series(name+"_bucket", m.Label, float64(m.Histogram.GetSampleCount()), "le", "+Inf")
+				series(name+"_sum", m.Label, m.Histogram.GetSampleSum())
+				series(name+"_count", m.Label, float64(m.Histogram.GetSampleCount()))
+			case m.Summary != nil:
+				for _, q := range m.Summary.Quantile {
+					series(name, m.Label, q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				series(name+"_sum", m.Label, m.Summary.GetSampleSum())
+				series(name+"_count", m.Label, float64(m.Summary.GetSampleCount()))
+			}
+		}
+	}
+	return req
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// snappyEncode encodes data in the block format of snappy, as required by the
+// remote write protocol. It doesn't compress the data but stores it as
+// literals, which every snappy decoder accepts.
+func snappyEncode(data []byte) []byte {
+	out := protowire.AppendVarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		// a literal of up to 2^16 bytes has its length minus one in the
+		// two bytes after the tag.
+		out = append(out, 61<<2, byte(n-1), byte((n-1)>>8))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
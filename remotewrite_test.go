@@ -0,0 +1,144 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeSnappyLiterals decodes data in the snappy block format that consists
+// of literals only, as written by snappyEncode.
+func decodeSnappyLiterals(t *testing.T, data []byte) []byte {
+	length, n := protowire.ConsumeVarint(data)
+	if n < 0 {
+		t.Fatal("invalid snappy length")
+	}
+	data = data[n:]
+	var out []byte
+	for len(data) >= 3 {
+		if data[0] != 61<<2 {
+			t.Fatalf("unexpected snappy tag %x", data[0])
+		}
+		l := int(data[1]) | int(data[2])<<8 + 1
+		out = append(out, data[3:3+l]...)
+		data = data[3+l:]
+	}
+	if uint64(len(out)) != length {
+		t.Fatalf("decoded %d bytes, expected %d", len(out), length)
+	}
+	return out
+}
+
+// decodeWriteRequest decodes the series of a WriteRequest into a map from
+// their labels, formatted as name{label="value",...}, to their value.
+func decodeWriteRequest(t *testing.T, data []byte) map[string]float64 {
+	fields := func(b []byte, f func(num protowire.Number, b []byte, v uint64)) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			b = b[n:]
+			switch typ {
+			case protowire.BytesType:
+				v, n := protowire.ConsumeBytes(b)
+				f(num, v, 0)
+				b = b[n:]
+			case protowire.Fixed64Type:
+				v, n := protowire.ConsumeFixed64(b)
+				f(num, nil, v)
+				b = b[n:]
+			case protowire.VarintType:
+				v, n := protowire.ConsumeVarint(b)
+				f(num, nil, v)
+				b = b[n:]
+			default:
+				t.Fatalf("unexpected wire type %d", typ)
+			}
+		}
+	}
+
+	series := make(map[string]float64)
+	fields(data, func(_ protowire.Number, ts []byte, _ uint64) {
+		var name, labels string
+		var value float64
+		fields(ts, func(num protowire.Number, b []byte, _ uint64) {
+			switch num {
+			case 1:
+				var k, v string
+				fields(b, func(num protowire.Number, b []byte, _ uint64) {
+					if num == 1 {
+						k = string(b)
+					} else {
+						v = string(b)
+					}
+				})
+				if k == "__name__" {
+					name = v
+				} else {
+					if labels != "" {
+						labels += ","
+					}
+					labels += k + "=\"" + v + "\""
+				}
+			case 2:
+				fields(b, func(num protowire.Number, _ []byte, v uint64) {
+					if num == 1 {
+						value = math.Float64frombits(v)
+					}
+				})
+			}
+		})
+		series[name+"{"+labels+"}"] = value
+	})
+	return series
+}
+
+func TestCPUProfileCollectorRemoteWrite(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	requests := make(chan map[string]float64, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("content encoding = %q, expected snappy", r.Header.Get("Content-Encoding"))
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests <- decodeWriteRequest(t, decodeSnappyLiterals(t, body))
+	}))
+	defer server.Close()
+
+	clk := &fakeClock{now: time.Unix(1500000000, 0), ticker: newFakeTicker()}
+	c, _ := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), clk, WithRemoteWrite(server.URL, time.Minute))
+
+	c.Start()
+	clk.ticker.c <- time.Time{}
+	series := <-requests
+	if value := series[`pprof_cpu_time_used_ms{function="main.main"}`]; value != 10 {
+		t.Errorf("time used by main.main = %f, expected 10 in %v", value, series)
+	}
+
+	c.Stop()
+	series = <-requests
+	if value := series[`pprof_cpu_time_used_ms{function="main.main"}`]; value != 20 {
+		t.Errorf("time used by main.main after stop = %f, expected 20", value)
+	}
+	if _, ok := series[`pprof_cpu_collect_duration_seconds_bucket{le="+Inf"}`]; !ok {
+		t.Error("histogram buckets are missing")
+	}
+	waitFor(t, func() bool { return counterValue(t, c.remoteWriter.writes) == 2 })
+}
+
+func TestSnappyEncode(t *testing.T) {
+	data := bytes.Repeat([]byte("pprofetheus"), 10000)
+	if decoded := decodeSnappyLiterals(t, snappyEncode(data)); !bytes.Equal(decoded, data) {
+		t.Error("decoded data differs")
+	}
+}
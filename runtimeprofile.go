@@ -0,0 +1,164 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/pprof"
+	"sync"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// runtimeProfileMetric describes the metrics that a runtimeProfileCollector
+// exports for the values of one sample type of a runtime profile.
+type runtimeProfileMetric struct {
+	subsystem string
+	name      string
+	help      string
+	valueType string
+	divisor   float64
+}
+
+// newRuntimeProfileCollector creates a collector that exports the values of a
+// profile that is maintained by the runtime since the start of the process,
+// such as the block or mutex profile, by function. The profile is read with
+// read on every scrape.
+func newRuntimeProfileCollector(read func() ([]byte, error), m runtimeProfileMetric, o *options) *runtimeProfileCollector {
+	// the runtime writes its profiles with function information, so there is
+	// no need for symbols unless explicitly configured.
+	symbolizer := o.symbolizer
+	if symbolizer == nil {
+		symbolizer = o.sharedSymbolizer
+	}
+	if symbolizer == nil {
+		symbolizer = symbolTable{}
+	}
+	if name := o.timeName(m.name); name != m.name {
+		m.name, m.help, m.divisor = name, o.timeHelp(m.help), m.divisor*o.timeScale()
+	}
+
+	return &runtimeProfileCollector{
+		read:        read,
+		metric:      m,
+		opts:        o,
+		symbolCache: newSymbolCache(symbolizer),
+		value: prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, o.subsystemOr(m.subsystem), m.name),
+			o.help(m.name, m.help+" by function"),
+			o.functionLabels(), o.constLabels,
+		),
+		valueCum: prometheus.NewDesc(
+			prometheus.BuildFQName(o.namespace, o.subsystemOr(m.subsystem), cumName(m.name)),
+			o.help(cumName(m.name), m.help+" by function (cumulated)"),
+			o.functionLabels(), o.constLabels,
+		),
+	}
+}
+
+type runtimeProfileCollector struct {
+	sync.Mutex
+	read        func() ([]byte, error)
+	metric      runtimeProfileMetric
+	opts        *options
+	symbolCache *symbolCache
+	value       *prometheus.Desc
+	valueCum    *prometheus.Desc
+}
+
+// lookupProfile returns a function that reads the runtime/pprof profile with
+// the given name.
+func lookupProfile(name string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		p := pprof.Lookup(name)
+		if p == nil {
+			return nil, fmt.Errorf("unknown profile %s", name)
+		}
+		var data bytes.Buffer
+		if err := p.WriteTo(&data, 0); err != nil {
+			return nil, err
+		}
+		return data.Bytes(), nil
+	}
+}
+
+func (c *runtimeProfileCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.value
+	ch <- c.valueCum
+}
+
+func (c *runtimeProfileCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	values, valuesCum, err := c.aggregate()
+	if err != nil {
+		c.opts.log(LevelError, "reading profile failed", "subsystem", c.metric.subsystem, "err", err)
+		c.opts.errorHandler(fmt.Errorf("reading %s profile failed: %v", c.metric.subsystem, err))
+		return
+	}
+
+	for name, v := range values {
+		ch <- prometheus.MustNewConstMetric(c.value, prometheus.CounterValue, v, name)
+	}
+	for name, v := range valuesCum {
+		ch <- prometheus.MustNewConstMetric(c.valueCum, prometheus.CounterValue, v, name)
+	}
+}
+
+// aggregate reads the profile and sums up its values by leaf function and by
+// every function of the call stacks.
+func (c *runtimeProfileCollector) aggregate() (values, valuesCum map[string]float64, err error) {
+	data, err := c.read()
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, ok := valueIndex(p, c.metric.valueType)
+	if !ok {
+		return nil, nil, fmt.Errorf("profile contains no sample type %q", c.metric.valueType)
+	}
+
+	values = make(map[string]float64)
+	valuesCum = make(map[string]float64)
+	locations := mapLocations(p.Location, c.symbolCache, c.opts)
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Value) <= idx {
+			continue
+		}
+
+		value := float64(s.Value[idx]) / c.metric.divisor
+
+		if f, ok := c.opts.locationName(locations, s.Location[0].ID); ok {
+			values[f.Function] += value
+		}
+
+		// recursive functions are only accounted once per sample.
+		seen := make(map[string]bool)
+		for _, l := range s.Location {
+			for _, f := range c.opts.locationNames(locations, l.ID) {
+				if !seen[f.Function] {
+					seen[f.Function] = true
+					valuesCum[f.Function] += value
+				}
+			}
+		}
+	}
+
+	return values, valuesCum, nil
+}
+
+// cumName returns the name of the cumulated metric for the metric name, e.g.
+// "time_cum_ms" for "time_ms".
+func cumName(name string) string {
+	for _, unit := range []string{"_ms", "_seconds_total", "_total"} {
+		if len(name) > len(unit) && name[len(name)-len(unit):] == unit {
+			return name[:len(name)-len(unit)] + "_cum" + unit
+		}
+	}
+	return name + "_cum"
+}
@@ -0,0 +1,179 @@
+package pprofetheus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SampleCollectorConfig configures a collector created by NewSampleCollector.
+type SampleCollectorConfig struct {
+	// Profile returns the raw data of the profile to export. It is called on
+	// every scrape, and the sample values of every profile are added to the
+	// exported counters, so it should only return the data recorded since
+	// the previous call.
+	Profile func() ([]byte, error)
+	// ValueType is the type of the sample values to export, e.g. "cpu". If
+	// it is empty, the values at ValueIndex are exported instead.
+	ValueType string
+	// ValueIndex is the index of the sample values to export.
+	ValueIndex int
+	// Options adjust the symbolization and labels of the exported metrics
+	// like for NewCPUProfileCollector.
+	Options []Option
+}
+
+// NewSampleCollector creates a collector that exports the sample values of
+// arbitrary profiles by function. The names of the metrics are derived from
+// the type and unit of the exported sample values, i.e. pprof_<type>_<unit>
+// for the values of the leaf functions and pprof_<type>_<unit>_cum for the
+// cumulated values. As these are only known once a profile has been read, the
+// collector is an unchecked collector.
+func NewSampleCollector(cfg SampleCollectorConfig) (prometheus.Collector, error) {
+	if cfg.Profile == nil {
+		return nil, errors.New("no profile source configured")
+	}
+
+	o := newOptions(cfg.Options)
+	symbolizer, err := newSymbolizer(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sampleCollector{
+		cfg:         cfg,
+		opts:        o,
+		symbolCache: newSymbolCache(symbolizer),
+	}, nil
+}
+
+type sampleCollector struct {
+	sync.Mutex
+	cfg         SampleCollectorConfig
+	opts        *options
+	symbolCache *symbolCache
+	valueType   *profile.ValueType
+	values      *prometheus.CounterVec
+	valuesCum   *prometheus.CounterVec
+}
+
+// Describe sends no descriptors, which makes the collector an unchecked
+// collector, as the metric names depend on the profiles read.
+func (c *sampleCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *sampleCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.collectProfile(); err != nil {
+		c.opts.log(LevelError, "reading profile failed", "err", err)
+		c.opts.errorHandler(fmt.Errorf("reading profile failed: %v", err))
+	}
+
+	if c.values != nil {
+		c.values.Collect(ch)
+		c.valuesCum.Collect(ch)
+	}
+}
+
+// collectProfile reads a profile from the configured source and adds its
+// sample values to the metrics.
+func (c *sampleCollector) collectProfile() error {
+	data, err := c.cfg.Profile()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return err
+	}
+
+	idx := c.cfg.ValueIndex
+	if c.cfg.ValueType != "" {
+		var ok bool
+		if idx, ok = valueIndex(p, c.cfg.ValueType); !ok {
+			return fmt.Errorf("profile contains no sample type %q", c.cfg.ValueType)
+		}
+	}
+	if idx < 0 || idx >= len(p.SampleType) || p.SampleType[idx] == nil {
+		return fmt.Errorf("profile contains no sample type at index %d", idx)
+	}
+
+	if c.values == nil {
+		c.newMetrics(p.SampleType[idx])
+	} else if st := p.SampleType[idx]; st.Type != c.valueType.Type || st.Unit != c.valueType.Unit {
+		return fmt.Errorf("sample type %s/%s differs from previous profiles' %s/%s", st.Type, st.Unit, c.valueType.Type, c.valueType.Unit)
+	}
+
+	locations := mapLocations(p.Location, c.symbolCache, c.opts)
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Value) <= idx {
+			continue
+		}
+
+		value := float64(s.Value[idx])
+
+		if f, ok := c.opts.locationName(locations, s.Location[0].ID); ok {
+			c.values.WithLabelValues(c.opts.labelValues(f, s, s.Location[0])...).Add(value)
+		}
+
+		seen := c.opts.newSeenSet()
+		for _, l := range s.Location {
+			for _, f := range c.opts.locationNames(locations, l.ID) {
+				if seen.add(f.Function) {
+					c.valuesCum.WithLabelValues(c.opts.labelValues(f, s, l)...).Add(value)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// newMetrics creates the metrics for sample values of the type st.
+func (c *sampleCollector) newMetrics(st *profile.ValueType) {
+	name := metricName(st.Type) + "_" + metricName(st.Unit)
+	o := c.opts
+	labelNames := o.labelNames()
+
+	c.valueType = st
+	c.values = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Subsystem:   o.subsystem,
+			Name:        name,
+			Help:        o.help(name, fmt.Sprintf("%s by function in %s", st.Type, st.Unit)),
+			ConstLabels: o.constLabels,
+		},
+		labelNames,
+	)
+	c.valuesCum = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Subsystem:   o.subsystem,
+			Name:        name + "_cum",
+			Help:        o.help(name+"_cum", fmt.Sprintf("%s by function in %s (cumulated)", st.Type, st.Unit)),
+			ConstLabels: o.constLabels,
+		},
+		labelNames,
+	)
+}
+
+// metricName replaces all characters of s that aren't allowed in metric names.
+func metricName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,89 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSampleCollector(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.handle", Addr: 0x1200, Size: 0x100, Code: 'T'},
+	}
+
+	var data bytes.Buffer
+	p := testProfile(t, symbols,
+		[]string{"main.compute", "main.main"},
+		[]string{"main.compute", "main.handle", "main.main"},
+		[]string{"main.handle", "main.main"},
+	)
+	if err := p.Write(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	collector, err := NewSampleCollector(SampleCollectorConfig{
+		Profile: func() ([]byte, error) {
+			return data.Bytes(), nil
+		},
+		ValueIndex: 1,
+		Options:    []Option{WithSymbols(symbols)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	if err := c.Ingest(bytes.NewReader(data.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(collectMetrics(collector)); n != 5 {
+		t.Errorf("collected %d metrics, expected 5", n)
+	}
+	sc := collector.(*sampleCollector)
+
+	for _, tt := range []struct {
+		name     string
+		values   *prometheus.CounterVec
+		expected *prometheus.CounterVec
+	}{
+		{"pprof_cpu_nanoseconds", sc.values, c.timeUsed},
+		{"pprof_cpu_nanoseconds_cum", sc.valuesCum, c.timeUsedCum},
+	} {
+		values := vecValues(t, tt.values, 1)
+		expected := vecValues(t, tt.expected, nanoToMilliDivisor)
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("%s = %v, expected %v", tt.name, values, expected)
+		}
+
+		for _, m := range collectMetrics(tt.values) {
+			if desc := m.Desc().String(); !strings.Contains(desc, `"`+tt.name+`"`) {
+				t.Errorf("unexpected metric %s, expected %s", desc, tt.name)
+			}
+		}
+	}
+
+	if _, err := NewSampleCollector(SampleCollectorConfig{Options: []Option{WithSymbols(symbols)}}); err == nil {
+		t.Errorf("creating a collector without profile source succeeded")
+	}
+}
+
+// vecValues returns the values of the metric v by function, multiplied by
+// factor.
+func vecValues(t *testing.T, v *prometheus.CounterVec, factor float64) map[string]float64 {
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(v) {
+		fn, _ := functionLabel(t, m)
+		values[fn] = counterValue(t, m) * factor
+	}
+	return values
+}
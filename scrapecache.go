@@ -0,0 +1,78 @@
+package pprofetheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// scrapeCache holds the metrics of the most recent scrape, so that scrapes
+// within its freshness window get the same snapshot instead of reading the
+// profile again.
+type scrapeCache struct {
+	window  time.Duration
+	time    time.Time
+	metrics []prometheus.Metric
+}
+
+// get returns the cached metrics if they are fresh at now, and otherwise
+// snapshots the metrics that collect emits at now.
+func (s *scrapeCache) get(now time.Time, collect func(ch chan<- prometheus.Metric)) []prometheus.Metric {
+	if s.metrics != nil && now.Sub(s.time) < s.window {
+		return s.metrics
+	}
+
+	s.metrics = snapshotMetrics(collect)
+	s.time = now
+	return s.metrics
+}
+
+// snapshotMetrics returns the metrics that collect emits, with the values they
+// have now.
+func snapshotMetrics(collect func(ch chan<- prometheus.Metric)) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collect(ch)
+		close(ch)
+	}()
+
+	metrics := []prometheus.Metric{}
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			// an invalid metric fails the scrape when it is written by
+			// the registry, too.
+			metrics = append(metrics, prometheus.NewInvalidMetric(m.Desc(), err))
+			continue
+		}
+		metrics = append(metrics, cachedMetric{m.Desc(), &metric})
+	}
+	return metrics
+}
+
+// invalidate drops the cached metrics.
+func (s *scrapeCache) invalidate() {
+	s.metrics = nil
+}
+
+// cachedMetric is a metric with the value it had when it was collected.
+type cachedMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func (m cachedMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m cachedMetric) Write(out *dto.Metric) error {
+	out.Label = m.metric.Label
+	out.Counter = m.metric.Counter
+	out.Gauge = m.metric.Gauge
+	out.Summary = m.metric.Summary
+	out.Untyped = m.metric.Untyped
+	out.Histogram = m.metric.Histogram
+	out.TimestampMs = m.metric.TimestampMs
+	return nil
+}
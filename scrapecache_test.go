@@ -0,0 +1,53 @@
+package pprofetheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCPUProfileCollectorScrapeCache(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	clk := &fakeClock{now: time.Unix(0, 0)}
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), clk, WithIntervalGauges(), WithScrapeCache(5*time.Second))
+	c.Start()
+	defer c.Stop()
+
+	intervalTime := func() float64 {
+		registry := prometheus.NewPedanticRegistry()
+		if err := registry.Register(c); err != nil {
+			t.Fatal(err)
+		}
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range families {
+			if f.GetName() == "pprof_cpu_time_ms_interval" {
+				return f.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		return 0
+	}
+
+	// both scrapes within the window get the interval, and the profile is
+	// only read once.
+	for i := 0; i < 2; i++ {
+		if value := intervalTime(); value != 10 {
+			t.Errorf("%d. interval time = %f, expected 10", i, value)
+		}
+		clk.Advance(time.Second)
+	}
+	if prof.stops != 1 {
+		t.Errorf("profile was read %d times, expected 1", prof.stops)
+	}
+
+	clk.Advance(5 * time.Second)
+	if value := intervalTime(); value != 10 || prof.stops != 2 {
+		t.Errorf("interval time = %f after %d reads, expected 10 after 2", value, prof.stops)
+	}
+}
@@ -0,0 +1,204 @@
+package pprofetheus
+
+import (
+	"debug/elf"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/travelaudience/pprofetheus/internal/objfile"
+)
+
+// sharedObject is the executable mapping of a shared object with the symbols
+// of its functions at their runtime addresses.
+type sharedObject struct {
+	start      uint64
+	end        uint64
+	symbolizer Symbolizer
+}
+
+// sharedObjectSymbolizer resolves addresses within the shared objects, e.g.
+// libc or libraries used via cgo, that are mapped into the address space of
+// the current process. It is sorted by address.
+type sharedObjectSymbolizer []sharedObject
+
+// newSharedObjectSymbolizer returns a Symbolizer for the shared objects among
+// the mappings of the current process, i.e. all mapped files except for the
+// binary exe. Shared objects that can't be read are skipped. Shared objects
+// that are loaded later, e.g. Go plugins or libraries opened with dlopen, are
+// picked up once a profile maps them. It returns nil if the mappings can't be
+// read.
+func newSharedObjectSymbolizer(o *options, exe string) Symbolizer {
+	mappings, err := readMappings()
+	if err != nil {
+		o.log(LevelWarn, "reading mappings failed", "err", err)
+		return nil
+	}
+	if path, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = path
+	}
+
+	s := &dynamicSharedObjects{
+		o:            o,
+		exe:          exe,
+		readMappings: readMappings,
+		known:        map[string]bool{exe: true},
+		tables:       make(map[string]Symbolizer),
+	}
+	s.update(mappings)
+	return s
+}
+
+// readSharedObjects returns the shared objects among mappings. The symbol
+// tables of the shared objects are taken from tables, and those read from the
+// files are added to it.
+func readSharedObjects(o *options, mappings []mapping, exe string, tables map[string]Symbolizer) sharedObjectSymbolizer {
+	var s sharedObjectSymbolizer
+	files := make(map[string]*elf.File)
+	for _, m := range mappings {
+		if m.path == exe {
+			continue
+		}
+
+		f, ok := files[m.path]
+		if !ok {
+			var err error
+			if f, err = elf.Open(m.path); err != nil {
+				o.log(LevelDebug, "reading shared object failed", "path", m.path, "err", err)
+			} else {
+				defer f.Close()
+				if _, ok := tables[m.path]; !ok {
+					functions := elfFunctions(f)
+					tables[m.path] = newBinarySymbolTable(o, functions)
+					o.log(LevelInfo, "read shared object symbols", "path", m.path, "symbols", len(functions))
+				}
+			}
+			files[m.path] = f
+		}
+		if f == nil {
+			continue
+		}
+
+		bias, ok := elfBias(f, m)
+		if !ok {
+			continue
+		}
+		s = append(s, sharedObject{
+			start:      m.start,
+			end:        m.end,
+			symbolizer: biasedSymbolizer{symbolizer: tables[m.path], bias: bias},
+		})
+	}
+
+	sort.Slice(s, func(i, j int) bool { return s[i].start < s[j].start })
+	return s
+}
+
+// elfFunctions returns the functions among the symbols of the ELF file f. The
+// dynamic symbols are included, as shared objects are usually stripped of all
+// others.
+func elfFunctions(f *elf.File) []objfile.Sym {
+	var functions []objfile.Sym
+	seen := make(map[uint64]bool)
+	for _, read := range []func() ([]elf.Symbol, error){f.Symbols, f.DynamicSymbols} {
+		// missing symbol tables are reported as errors.
+		symbols, _ := read()
+		for _, s := range symbols {
+			if elf.ST_TYPE(s.Info) != elf.STT_FUNC || s.Section == elf.SHN_UNDEF || seen[s.Value] {
+				continue
+			}
+			seen[s.Value] = true
+			functions = append(functions, objfile.Sym{Addr: s.Value, Name: s.Name, Size: int64(s.Size), Code: 'T'})
+		}
+	}
+	return functions
+}
+
+func (s sharedObjectSymbolizer) Resolve(addr uint64) (string, bool) {
+	i := sort.Search(len(s), func(i int) bool { return s[i].end > addr })
+	if i == len(s) || addr < s[i].start {
+		return "", false
+	}
+	return s[i].symbolizer.Resolve(addr)
+}
+
+func (s sharedObjectSymbolizer) memoryBytes() uint64 {
+	var bytes uint64
+	for _, object := range s {
+		bytes += symbolizerMemory(object.symbolizer)
+	}
+	return bytes
+}
+
+// dynamicSharedObjects is a Symbolizer for the shared objects of the current
+// process that picks up the shared objects loaded after it has been created.
+// It is safe for concurrent use.
+type dynamicSharedObjects struct {
+	o            *options
+	exe          string
+	readMappings func() ([]mapping, error)
+
+	mu      sync.RWMutex
+	objects sharedObjectSymbolizer
+	// known are the mapped files that the mappings have been read for.
+	known  map[string]bool
+	tables map[string]Symbolizer
+}
+
+// update replaces the shared objects by those among mappings. It must be
+// called with the lock held.
+func (s *dynamicSharedObjects) update(mappings []mapping) {
+	for _, m := range mappings {
+		s.known[m.path] = true
+	}
+	s.objects = readSharedObjects(s.o, mappings, s.exe, s.tables)
+}
+
+func (s *dynamicSharedObjects) Resolve(addr uint64) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.objects.Resolve(addr)
+}
+
+// refreshMappings reads the mappings of the current process again if any of
+// the files is unknown, i.e. has been mapped since they were last read.
+// Files that still aren't mapped, e.g. those of profiles of other processes,
+// aren't looked for again.
+func (s *dynamicSharedObjects) refreshMappings(files []string) bool {
+	s.mu.RLock()
+	unknown := false
+	for _, file := range files {
+		if !s.known[file] {
+			unknown = true
+			break
+		}
+	}
+	s.mu.RUnlock()
+	if !unknown {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, file := range files {
+		s.known[file] = true
+	}
+	mappings, err := s.readMappings()
+	if err != nil {
+		s.o.log(LevelWarn, "reading mappings failed", "err", err)
+		return false
+	}
+	before := len(s.objects)
+	s.update(mappings)
+	s.o.log(LevelInfo, "refreshed shared objects", "before", before, "after", len(s.objects))
+	return true
+}
+
+func (s *dynamicSharedObjects) memoryBytes() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return symbolizerMemory(s.objects)
+}
@@ -0,0 +1,108 @@
+package pprofetheus
+
+import (
+	"debug/elf"
+	"path/filepath"
+	"testing"
+)
+
+// libcMapping returns a mapping of the executable segment of libc at the
+// address start like the dynamic loader would create it, the bias of its
+// addresses and the address of malloc in libc.
+func libcMapping(t *testing.T, start uint64) (mapping, uint64, uint64) {
+	paths, _ := filepath.Glob("/lib*/*-linux-gnu/libc.so.6")
+	if len(paths) == 0 {
+		t.Skip("libc not found")
+	}
+	path := paths[0]
+
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var (
+		m    mapping
+		bias uint64
+	)
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_X != 0 {
+			offset := prog.Off &^ 0xfff
+			m = mapping{start: start, end: start + prog.Off - offset + prog.Filesz, offset: offset, path: path}
+			bias = start - (prog.Vaddr - (prog.Off - offset))
+			break
+		}
+	}
+
+	var malloc uint64
+	for _, sym := range elfFunctions(f) {
+		if sym.Name == "malloc" {
+			malloc = sym.Addr
+		}
+	}
+	if malloc == 0 {
+		t.Fatal("malloc not found")
+	}
+	return m, bias, malloc
+}
+
+func TestSharedObjectSymbolizer(t *testing.T) {
+	const start = 0x7f0000000000
+	m, bias, malloc := libcMapping(t, start)
+
+	s := readSharedObjects(newOptions(nil), []mapping{m, {start: 0x400000, end: 0x500000, path: "/proc/self/exe"}}, "/proc/self/exe", make(map[string]Symbolizer))
+	if len(s) != 1 {
+		t.Fatalf("got %d shared objects, expected 1", len(s))
+	}
+	if name, ok := s.Resolve(malloc + bias); !ok || name != "malloc" {
+		t.Errorf("Resolve(%#x) = %q, %t, expected malloc", malloc+bias, name, ok)
+	}
+	if name, ok := s.Resolve(start - 1); ok {
+		t.Errorf("Resolve(%#x) = %q, expected no function", uint64(start-1), name)
+	}
+}
+
+func TestDynamicSharedObjects(t *testing.T) {
+	const start = 0x7f0000000000
+	m, bias, malloc := libcMapping(t, start)
+
+	exe := mapping{start: 0x400000, end: 0x500000, path: "/proc/self/exe"}
+	mappings := []mapping{exe}
+	reads := 0
+	s := &dynamicSharedObjects{
+		o:   newOptions(nil),
+		exe: exe.path,
+		readMappings: func() ([]mapping, error) {
+			reads++
+			return mappings, nil
+		},
+		known:  map[string]bool{exe.path: true},
+		tables: make(map[string]Symbolizer),
+	}
+	s.update(mappings)
+	if name, ok := s.Resolve(malloc + bias); ok {
+		t.Errorf("Resolve(%#x) = %q before libc has been loaded", malloc+bias, name)
+	}
+
+	if s.refreshMappings([]string{exe.path}) || reads != 0 {
+		t.Errorf("mappings refreshed %d times for the known binary", reads)
+	}
+
+	// libc is loaded, e.g. with dlopen, and appears in a profile.
+	mappings = append(mappings, m)
+	if !s.refreshMappings([]string{exe.path, m.path}) || reads != 1 {
+		t.Errorf("mappings refreshed %d times after libc has been loaded, expected 1", reads)
+	}
+	if name, ok := s.Resolve(malloc + bias); !ok || name != "malloc" {
+		t.Errorf("Resolve(%#x) = %q, %t, expected malloc", malloc+bias, name, ok)
+	}
+
+	// files that aren't mapped into the process are only looked for once.
+	for i := 0; i < 2; i++ {
+		s.refreshMappings([]string{"/usr/lib/other.so"})
+	}
+	if reads != 2 {
+		t.Errorf("mappings read %d times, expected 2", reads)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package pprofetheus
+
+// newSharedObjectSymbolizer returns a Symbolizer for the shared objects mapped
+// into the address space of the current process. Only Linux exposes the
+// mappings, so it returns nil elsewhere.
+func newSharedObjectSymbolizer(o *options, exe string) Symbolizer {
+	return nil
+}
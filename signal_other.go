@@ -0,0 +1,15 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package pprofetheus
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// HandleSignals makes SIGUSR1 start and SIGUSR2 stop the collector c. There are
+// no such signals on this platform, so it only returns an error.
+func HandleSignals(c ProfileCollector) (func(), error) {
+	return nil, fmt.Errorf("signals are not supported on %s", runtime.GOOS)
+}
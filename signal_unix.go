@@ -0,0 +1,41 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package pprofetheus
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleSignals makes SIGUSR1 start and SIGUSR2 stop the collector c, e.g. to
+// toggle profiling in environments where signals can be sent to a process but
+// its HTTP endpoints aren't reachable. The returned function removes the
+// signal handlers again. Signals aren't available on all platforms; there,
+// HandleSignals returns an error.
+func HandleSignals(c ProfileCollector) (func(), error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				if sig == syscall.SIGUSR1 {
+					c.Start()
+				} else {
+					c.Stop()
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}, nil
+}
@@ -0,0 +1,39 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package pprofetheus
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleSignals(t *testing.T) {
+	c, _ := newFakeCollector(t, []Symbol{}, nil, &fakeClock{now: time.Unix(0, 0)})
+
+	stop, err := HandleSignals(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	running := func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return c.running
+	}
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, running)
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !running() })
+
+	if started, stopped := counterValue(t, c.started), counterValue(t, c.stopped); started != 1 || stopped != 1 {
+		t.Errorf("started = %f, stopped = %f, expected 1 each", started, stopped)
+	}
+}
@@ -0,0 +1,84 @@
+package pprofetheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// FunctionStats are the profiling data of a function as returned by
+// Snapshot.
+type FunctionStats struct {
+	// Flat is the CPU time spent in the function itself.
+	Flat time.Duration
+	// Cum is the CPU time spent in the function and the functions it called.
+	Cum time.Duration
+	// Samples is the number of samples in the function itself. It is only
+	// counted while EnableSampleCount is enabled.
+	Samples uint64
+}
+
+// Snapshot reads the profile data recorded so far, like Flush, and returns the
+// accumulated data per function label value, e.g. to log the hottest
+// functions on shutdown. Series that differ only by additional labels, e.g.
+// from WithMappingLabel, are summed up.
+func (c *cpuProfileCollector) Snapshot() map[string]FunctionStats {
+	c.Lock()
+	defer c.Unlock()
+
+	c.drain()
+
+	stats := make(map[string]FunctionStats)
+	label := c.opts.functionLabels()[0]
+	for fn, value := range functionValues(c.timeUsed, label) {
+		s := stats[fn]
+		s.Flat = msDuration(value * c.opts.timeScale())
+		stats[fn] = s
+	}
+	if c.cumulativeEnabled {
+		for fn, value := range functionValues(c.timeUsedCum, label) {
+			s := stats[fn]
+			s.Cum = msDuration(value * c.opts.timeScale())
+			stats[fn] = s
+		}
+	}
+	if c.samplesEnabled {
+		for fn, value := range functionValues(c.samples, label) {
+			s := stats[fn]
+			s.Samples = uint64(value)
+			stats[fn] = s
+		}
+	}
+	return stats
+}
+
+// functionValues returns the sums of the values of the series of the counter
+// vector v by the value of their label.
+func functionValues(v *prometheus.CounterVec, label string) map[string]float64 {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		v.Collect(metrics)
+		close(metrics)
+	}()
+
+	values := make(map[string]float64)
+	for m := range metrics {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			continue
+		}
+		for _, l := range metric.Label {
+			if l.GetName() == label {
+				values[l.GetValue()] += metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+// msDuration converts the milliseconds ms to a time.Duration.
+func msDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
@@ -0,0 +1,34 @@
+package pprofetheus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCPUProfileCollectorSnapshot(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.compute", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithMappingLabel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.EnableSampleCount(true)
+	c.addProfile(testProfile(t, symbols,
+		[]string{"main.compute", "main.main"},
+		[]string{"main.compute", "main.main"},
+		[]string{"main.main"},
+	))
+
+	expected := map[string]FunctionStats{
+		"main.compute": {Flat: 20 * time.Millisecond, Cum: 20 * time.Millisecond, Samples: 2},
+		"main.main":    {Flat: 10 * time.Millisecond, Cum: 30 * time.Millisecond, Samples: 1},
+	}
+	if stats := profileCollector.Snapshot(); !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Snapshot() = %v, expected %v", stats, expected)
+	}
+}
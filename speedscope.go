@@ -0,0 +1,119 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+// speedscopeFile is a file in the format of speedscope, as described by
+// speedscopeSchema.
+type speedscopeFile struct {
+	Schema   string              `json:"$schema"`
+	Exporter string              `json:"exporter"`
+	Shared   speedscopeShared    `json:"shared"`
+	Profiles []speedscopeProfile `json:"profiles"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int64  `json:"line,omitempty"`
+}
+
+// speedscopeProfile is a sampled profile, whose samples are the indexes of the
+// frames of their stacks, outermost first.
+type speedscopeProfile struct {
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	Unit       string    `json:"unit"`
+	StartValue float64   `json:"startValue"`
+	EndValue   float64   `json:"endValue"`
+	Samples    [][]int   `json:"samples"`
+	Weights    []float64 `json:"weights"`
+}
+
+// WriteSpeedscope writes the profiles, e.g. as returned by Profiles(), to w in
+// the JSON format of speedscope (https://www.speedscope.app), with the CPU
+// time of the samples in milliseconds.
+func WriteSpeedscope(w io.Writer, profiles []RecordedProfile) error {
+	file := speedscopeFile{
+		Schema:   speedscopeSchema,
+		Exporter: "pprofetheus",
+		Shared:   speedscopeShared{Frames: []speedscopeFrame{}},
+		Profiles: []speedscopeProfile{},
+	}
+	frames := make(map[stackFrame]int)
+
+	for _, rp := range profiles {
+		p, err := profile.ParseData(rp.Data)
+		if err != nil {
+			return fmt.Errorf("parsing profile %s failed: %v", rp.Name, err)
+		}
+
+		sp := speedscopeProfile{
+			Type:    "sampled",
+			Name:    rp.Name,
+			Unit:    "milliseconds",
+			Samples: [][]int{},
+			Weights: []float64{},
+		}
+		forEachStack(p, func(stack []stackFrame, value float64) {
+			sample := make([]int, len(stack))
+			for i, f := range stack {
+				idx, ok := frames[f]
+				if !ok {
+					idx = len(file.Shared.Frames)
+					frames[f] = idx
+					file.Shared.Frames = append(file.Shared.Frames, speedscopeFrame{Name: f.name, File: f.file, Line: f.line})
+				}
+				sample[i] = idx
+			}
+			sp.Samples = append(sp.Samples, sample)
+			sp.Weights = append(sp.Weights, value)
+			sp.EndValue += value
+		})
+		file.Profiles = append(file.Profiles, sp)
+	}
+
+	return json.NewEncoder(w).Encode(file)
+}
+
+// SpeedscopeHandler returns an http.Handler that serves the profiles kept by
+// the collector c with WithProfileHistory in the format of speedscope, one
+// speedscope profile per kept profile. The parameter "profile" restricts it
+// to the profile of that name. The file can be opened on speedscope.app or
+// with the speedscope command line tool.
+func SpeedscopeHandler(c ProfileCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		profiles := keptProfiles(c, r.FormValue("profile"))
+		if len(profiles) == 0 {
+			http.Error(w, "no CPU profiles recorded", http.StatusNotFound)
+			return
+		}
+
+		var data bytes.Buffer
+		if err := WriteSpeedscope(&data, profiles); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="profile.speedscope.json"`)
+		w.Write(data.Bytes())
+	})
+}
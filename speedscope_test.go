@@ -0,0 +1,92 @@
+package pprofetheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriteSpeedscope(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.handle", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	p := testProfile(t, symbols, []string{"main.handle", "main.main"}, []string{"main.main"})
+	symbolize(p, newSymbolTable(symbols))
+	var data bytes.Buffer
+	if err := p.Write(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := WriteSpeedscope(&out, []RecordedProfile{{Name: "cpu.pprof", Data: data.Bytes()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var file speedscopeFile
+	if err := json.Unmarshal(out.Bytes(), &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Schema != speedscopeSchema {
+		t.Errorf("schema = %q, expected %q", file.Schema, speedscopeSchema)
+	}
+	if len(file.Profiles) != 1 {
+		t.Fatalf("got %d profiles, expected 1", len(file.Profiles))
+	}
+	sp := file.Profiles[0]
+	if sp.Name != "cpu.pprof" || sp.Type != "sampled" || sp.EndValue != 20 {
+		t.Errorf("unexpected profile %+v", sp)
+	}
+
+	var stacks [][]string
+	for _, sample := range sp.Samples {
+		var stack []string
+		for _, idx := range sample {
+			stack = append(stack, file.Shared.Frames[idx].Name)
+		}
+		stacks = append(stacks, stack)
+	}
+	if len(stacks) != 2 || len(stacks[0]) != 2 || stacks[0][0] != "main.main" || stacks[0][1] != "main.handle" || len(stacks[1]) != 1 {
+		t.Errorf("stacks = %v, expected [[main.main main.handle] [main.main]]", stacks)
+	}
+}
+
+func TestWriteSpeedscopeInvalidProfile(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteSpeedscope(&out, []RecordedProfile{{Name: "cpu.pprof", Data: []byte("garbage")}}); err == nil {
+		t.Error("WriteSpeedscope succeeded with an invalid profile")
+	}
+}
+
+func TestSpeedscopeHandler(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	c, _ := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.main"}), &fakeClock{now: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)}, WithProfileHistory(10, 0))
+	h := SpeedscopeHandler(c)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("without profiles: status %d, expected %d", w.Code, http.StatusNotFound)
+	}
+
+	c.Start()
+	defer c.Stop()
+	c.Flush()
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var file speedscopeFile
+	if err := json.Unmarshal(w.Body.Bytes(), &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Profiles) != 1 || file.Profiles[0].Name != "cpu-20170601T120000Z.pprof" {
+		t.Errorf("unexpected profiles %+v", file.Profiles)
+	}
+}
@@ -0,0 +1,75 @@
+package pprofetheus
+
+import (
+	"strings"
+)
+
+// stackFunction returns the name of the innermost function of the stack of
+// return addresses, as recorded by the runtime's profiles, and whether metrics
+// shall be emitted for it at all.
+func (o *options) stackFunction(stack []uintptr, symbolizer Symbolizer) (string, bool) {
+	if len(stack) == 0 {
+		return "", !o.filtered()
+	}
+	return o.returnAddrFunction(stack[0], symbolizer)
+}
+
+// entryFunction returns the name of the outermost function of the stack of
+// return addresses, i.e. the function that a goroutine was started with, and
+// whether metrics shall be emitted for it at all.
+func (o *options) entryFunction(stack []uintptr, symbolizer Symbolizer) (string, bool) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if isGoexit(stack[i], symbolizer) {
+			continue
+		}
+		return o.returnAddrFunction(stack[i], symbolizer)
+	}
+	return "", !o.filtered()
+}
+
+// stackFunctions returns the names of the distinct functions of the stack of
+// return addresses that metrics shall be emitted for.
+func (o *options) stackFunctions(stack []uintptr, symbolizer Symbolizer) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, pc := range stack {
+		if isGoexit(pc, symbolizer) {
+			continue
+		}
+		if name, ok := o.returnAddrFunction(pc, symbolizer); ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isGoexit returns true if the return address pc belongs to runtime.goexit.
+// Goroutines return to runtime.goexit, which is thus recorded as the outermost
+// frame of their stacks.
+func isGoexit(pc uintptr, symbolizer Symbolizer) bool {
+	name, ok := symbolizer.Resolve(uint64(pc) - 1)
+	return ok && strings.HasPrefix(name, "runtime.goexit")
+}
+
+// returnAddrFunction returns the name of the function that contains the
+// return address pc. Like for profile locations, unresolved functions are
+// reported with their address unless the collector is restricted to a subset
+// of the binary.
+func (o *options) returnAddrFunction(pc uintptr, symbolizer Symbolizer) (string, bool) {
+	// return addresses point to the instruction after the call, which may
+	// already belong to the next function.
+	addr := uint64(pc) - 1
+	name, ok := symbolizer.Resolve(addr)
+	if !ok {
+		return o.unresolvedName(addr), !o.filtered()
+	}
+	if !o.keep(name, addr) {
+		return "", false
+	}
+	name = o.displayName(name)
+	if o.labelMapper != nil {
+		return o.labelMapper(Frame{Function: name})
+	}
+	return name, true
+}
@@ -0,0 +1,256 @@
+package pprofetheus
+
+import (
+	"runtime"
+	"sort"
+	"unsafe"
+
+	"github.com/travelaudience/pprofetheus/internal/objfile"
+)
+
+// Symbolizer resolves addresses of the profiled program to function names.
+type Symbolizer interface {
+	// Resolve returns the name of the function that contains the address
+	// addr, and false if the address can't be resolved.
+	Resolve(addr uint64) (name string, ok bool)
+}
+
+// FrameSymbolizer is a Symbolizer that also resolves the functions that have
+// been inlined at an address. Collectors use it to attribute samples to
+// inlined functions and to account the functions they have been inlined into
+// in the cumulated metrics.
+type FrameSymbolizer interface {
+	Symbolizer
+	// ResolveFrames returns the names of the functions at the address addr,
+	// starting with the innermost one whose code is at addr followed by the
+	// ones it has been inlined into, and false if the address can't be
+	// resolved.
+	ResolveFrames(addr uint64) (names []string, ok bool)
+}
+
+// resolveFrames returns the names of the functions at the address addr,
+// innermost first. Only a FrameSymbolizer resolves more than one.
+func resolveFrames(symbolizer Symbolizer, addr uint64) ([]string, bool) {
+	if fs, ok := symbolizer.(FrameSymbolizer); ok {
+		return fs.ResolveFrames(addr)
+	}
+	name, ok := symbolizer.Resolve(addr)
+	if !ok {
+		return nil, false
+	}
+	return []string{name}, true
+}
+
+// symbolTable is a Symbolizer that resolves addresses using the symbol table
+// of a binary. Its symbols are sorted by address. The zero value resolves no
+// addresses.
+type symbolTable struct {
+	symbols []objfile.Sym
+	// bytes is the estimated memory held by the table, which never changes
+	// after it has been built.
+	bytes uint64
+}
+
+// newSymbolTable returns a symbol table of a sorted copy of symbols.
+func newSymbolTable(symbols []objfile.Sym) symbolTable {
+	sorted := append([]objfile.Sym(nil), symbols...)
+	// symbols at the same address are sorted by size, so that the largest of
+	// them is found for an address.
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Addr != sorted[j].Addr {
+			return sorted[i].Addr < sorted[j].Addr
+		}
+		return sorted[i].Size < sorted[j].Size
+	})
+	return symbolTable{symbols: sorted, bytes: symbolTableBytes(sorted)}
+}
+
+func (t symbolTable) Resolve(addr uint64) (string, bool) {
+	s, ok := resolve(addr, t.symbols)
+	return s.Name, ok
+}
+
+// isEmptySymbolTable returns true if the symbolizer is a symbol table without
+// any symbols, e.g. of a stripped binary, even if it is relocated or falls
+// back to the shared objects of the binary.
+func isEmptySymbolTable(symbolizer Symbolizer) bool {
+	switch s := symbolizer.(type) {
+	case biasedSymbolizer:
+		return isEmptySymbolTable(s.symbolizer)
+	case fallbackSymbolizer:
+		// the symbolizers after the first one only resolve the addresses
+		// that it doesn't know, e.g. those of shared objects.
+		return len(s) > 0 && isEmptySymbolTable(s[0])
+	case symbolTable:
+		return len(s.symbols) == 0
+	case *compactSymbolTable:
+		return len(s.symbols) == 0
+	}
+	return false
+}
+
+// biasedSymbolizer is a Symbolizer for a binary that has been loaded bias
+// bytes after the address that it was linked at.
+type biasedSymbolizer struct {
+	symbolizer Symbolizer
+	bias       uint64
+}
+
+func (s biasedSymbolizer) Resolve(addr uint64) (string, bool) {
+	if addr < s.bias {
+		return "", false
+	}
+	return s.symbolizer.Resolve(addr - s.bias)
+}
+
+func (s biasedSymbolizer) ResolveFrames(addr uint64) ([]string, bool) {
+	if addr < s.bias {
+		return nil, false
+	}
+	return resolveFrames(s.symbolizer, addr-s.bias)
+}
+
+// resolve returns the symbol that contains the address addr, i.e. the symbol
+// with the greatest address not after addr, provided that addr lies before the
+// end of that symbol. Symbols without a size extend up to the next symbol.
+// symbols must be sorted by address.
+func resolve(addr uint64, symbols []objfile.Sym) (objfile.Sym, bool) {
+	i, ok := resolveIndex(addr, len(symbols), func(i int) (uint64, uint64) {
+		return symbols[i].Addr, uint64(symbols[i].Size)
+	})
+	if !ok {
+		return objfile.Sym{}, false
+	}
+	return symbols[i], true
+}
+
+// resolveIndex returns the index of the symbol that contains the address addr
+// like resolve, among n symbols sorted by address whose address and size are
+// returned by symbol.
+func resolveIndex(addr uint64, n int, symbol func(i int) (addr, size uint64)) (int, bool) {
+	i := sort.Search(n, func(i int) bool {
+		start, _ := symbol(i)
+		return start > addr
+	}) - 1
+	if i < 0 {
+		return 0, false
+	}
+
+	start, size := symbol(i)
+	end := start + size
+	if size == 0 {
+		if i+1 == n {
+			return 0, false
+		}
+		end, _ = symbol(i + 1)
+	}
+	if addr >= end {
+		return 0, false
+	}
+	return i, true
+}
+
+// runtimeSymbolizer is a FrameSymbolizer that resolves addresses of the
+// current process using the runtime's function table, which doesn't require
+// access to the binary and knows about inlined calls.
+type runtimeSymbolizer struct{}
+
+func (runtimeSymbolizer) Resolve(addr uint64) (string, bool) {
+	f := runtime.FuncForPC(uintptr(addr))
+	if f == nil {
+		return "", false
+	}
+	return f.Name(), true
+}
+
+func (runtimeSymbolizer) ResolveFrames(addr uint64) ([]string, bool) {
+	// the frames are looked up at the address before the given one, like for
+	// the return addresses that they are usually created from.
+	frames := runtime.CallersFrames([]uintptr{uintptr(addr) + 1})
+	var names []string
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" {
+			names = append(names, frame.Function)
+		}
+		if !more {
+			break
+		}
+	}
+	return names, len(names) > 0
+}
+
+// fallbackSymbolizer is a Symbolizer that resolves addresses with the first of
+// its Symbolizers that can resolve them.
+type fallbackSymbolizer []Symbolizer
+
+func (s fallbackSymbolizer) Resolve(addr uint64) (string, bool) {
+	for _, symbolizer := range s {
+		if name, ok := symbolizer.Resolve(addr); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (s fallbackSymbolizer) ResolveFrames(addr uint64) ([]string, bool) {
+	for _, symbolizer := range s {
+		if names, ok := resolveFrames(symbolizer, addr); ok {
+			return names, true
+		}
+	}
+	return nil, false
+}
+
+// symbolCache is a Symbolizer that caches the results of another Symbolizer.
+// As the symbols of a running program don't change, cached results never need
+// to be invalidated. It is not safe for concurrent use.
+type symbolCache struct {
+	symbolizer Symbolizer
+	names      map[uint64]cachedNames
+	hits       uint64
+	misses     uint64
+	// bytes is the estimated memory held by names.
+	bytes uint64
+}
+
+type cachedNames struct {
+	names []string
+	ok    bool
+}
+
+func newSymbolCache(symbolizer Symbolizer) *symbolCache {
+	return &symbolCache{
+		symbolizer: symbolizer,
+		names:      make(map[uint64]cachedNames),
+	}
+}
+
+func (c *symbolCache) Resolve(addr uint64) (string, bool) {
+	names, ok := c.ResolveFrames(addr)
+	if !ok || len(names) == 0 {
+		return "", false
+	}
+	return names[0], true
+}
+
+func (c *symbolCache) ResolveFrames(addr uint64) ([]string, bool) {
+	if n, ok := c.names[addr]; ok {
+		c.hits++
+		return n.names, n.ok
+	}
+	c.misses++
+
+	names, ok := resolveFrames(c.symbolizer, addr)
+	c.names[addr] = cachedNames{names, ok}
+	c.bytes += cacheEntryBytes + uint64(len(names))*uint64(unsafe.Sizeof(""))
+	return names, ok
+}
+
+// hitRatio returns the ratio of cache hits to all lookups.
+func (c *symbolCache) hitRatio() float64 {
+	if c.hits+c.misses == 0 {
+		return 0
+	}
+	return float64(c.hits) / float64(c.hits+c.misses)
+}
@@ -0,0 +1,6 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package pprofetheus
+
+const defaultRuntimeSymbols = false
@@ -0,0 +1,9 @@
+//go:build windows || darwin
+// +build windows darwin
+
+package pprofetheus
+
+// Windows and macOS binaries are loaded at a dynamic base address, so the
+// addresses in their symbol tables don't match the addresses at runtime.
+// Addresses are thus resolved using the runtime's function table by default.
+const defaultRuntimeSymbols = true
@@ -0,0 +1,288 @@
+package pprofetheus
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/travelaudience/pprofetheus/internal/pprof/profile"
+)
+
+// countingSymbolizer counts the lookups that are passed on to a Symbolizer.
+type countingSymbolizer struct {
+	Symbolizer
+	lookups int
+}
+
+func (s *countingSymbolizer) Resolve(addr uint64) (string, bool) {
+	s.lookups++
+	return s.Symbolizer.Resolve(addr)
+}
+
+func TestSymbolCache(t *testing.T) {
+	symbolizer := &countingSymbolizer{Symbolizer: fakeSymbolizer{
+		0x1000: "main.main",
+		0x2000: "main.handle",
+	}}
+	cache := newSymbolCache(symbolizer)
+
+	for i := 0; i < 3; i++ {
+		for _, addr := range []uint64{0x1000, 0x2000, 0x3000} {
+			name, ok := cache.Resolve(addr)
+			expectedName, expectedOK := symbolizer.Symbolizer.Resolve(addr)
+			if name != expectedName || ok != expectedOK {
+				t.Errorf("Resolve(%#x) = %q, %t, expected %q, %t", addr, name, ok, expectedName, expectedOK)
+			}
+		}
+	}
+
+	if symbolizer.lookups != 3 {
+		t.Errorf("%d lookups were passed on, expected 3", symbolizer.lookups)
+	}
+	if ratio := cache.hitRatio(); ratio != 6.0/9.0 {
+		t.Errorf("hit ratio = %f, expected %f", ratio, 6.0/9.0)
+	}
+}
+
+func TestCPUProfileCollectorSymbolCacheHitRatio(t *testing.T) {
+	profileCollector, err := NewCPUProfileCollector(WithSymbolizer(fakeSymbolizer{0x1000: "main.main"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+
+	p := testProfile(t, nil)
+	p.Location = []*profile.Location{{ID: 1, Address: 0x1000}}
+	p.Sample = []*profile.Sample{{Location: p.Location, Value: []int64{1, 10000000}}}
+	for i := 0; i < 4; i++ {
+		c.addProfile(p)
+	}
+
+	collectMetrics(c)
+	if ratio := gaugeValue(t, c.symbolCacheHitRatio); ratio != 0.75 {
+		t.Errorf("symbol cache hit ratio = %f, expected 0.75", ratio)
+	}
+}
+
+func BenchmarkMapLocations(b *testing.B) {
+	symbols := fakeSymbolizer{}
+	var locations []*profile.Location
+	for i := uint64(1); i <= 100; i++ {
+		symbols[i*0x100] = "main.f"
+		locations = append(locations, &profile.Location{ID: i, Address: i * 0x100})
+	}
+	o := newOptions(nil)
+
+	run := func(b *testing.B, symbolizer *countingSymbolizer, resolver Symbolizer) {
+		for i := 0; i < b.N; i++ {
+			mapLocations(locations, resolver, o)
+		}
+		b.ReportMetric(float64(symbolizer.lookups)/float64(b.N), "lookups/op")
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		symbolizer := &countingSymbolizer{Symbolizer: symbols}
+		run(b, symbolizer, symbolizer)
+	})
+	b.Run("cached", func(b *testing.B) {
+		symbolizer := &countingSymbolizer{Symbolizer: symbols}
+		run(b, symbolizer, newSymbolCache(symbolizer))
+	})
+}
+
+func TestSymbolTableBoundaries(t *testing.T) {
+	// the symbols are deliberately out of order.
+	symbols := newSymbolTable([]Symbol{
+		{Name: "main.c", Addr: 0x1200, Size: 0},
+		{Name: "main.b", Addr: 0x1100, Size: 0x100},
+		{Name: "main.a", Addr: 0x1000, Size: 0x100},
+		{Name: "main.d", Addr: 0x1300, Size: 0x10},
+		{Name: "main.e", Addr: 0x1400, Size: 0},
+		{Name: "main.alias", Addr: 0x1000, Size: 0},
+	})
+
+	for _, tt := range []struct {
+		addr uint64
+		name string
+		ok   bool
+	}{
+		{0xfff, "", false},
+		{0x1000, "main.a", true},
+		{0x10ff, "main.a", true},
+		{0x1100, "main.b", true},
+		{0x11ff, "main.b", true},
+		{0x1200, "main.c", true},
+		{0x12ff, "main.c", true},
+		{0x1300, "main.d", true},
+		{0x130f, "main.d", true},
+		{0x1310, "", false},
+		{0x1400, "", false},
+	} {
+		name, ok := symbols.Resolve(tt.addr)
+		if name != tt.name || ok != tt.ok {
+			t.Errorf("Resolve(%#x) = %q, %t, expected %q, %t", tt.addr, name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+// linearSymbolTable resolves addresses by scanning all symbols, as a baseline
+// for the binary search of symbolTable.
+type linearSymbolTable []Symbol
+
+func (t linearSymbolTable) Resolve(addr uint64) (string, bool) {
+	for _, s := range t {
+		if addr >= s.Addr && addr < s.Addr+uint64(s.Size) {
+			return s.Name, true
+		}
+	}
+	return "", false
+}
+
+func BenchmarkSymbolTable(b *testing.B) {
+	// a large binary with 50k symbols and a profile with 1000 locations
+	// spread across them.
+	var symbols []Symbol
+	for i := 0; i < 50000; i++ {
+		symbols = append(symbols, Symbol{Name: fmt.Sprintf("main.f%d", i), Addr: uint64(0x1000 + i*0x100), Size: 0x100, Code: 'T'})
+	}
+	var locations []*profile.Location
+	for i := 0; i < 1000; i++ {
+		locations = append(locations, &profile.Location{ID: uint64(i + 1), Address: symbols[i*50].Addr + 0x80})
+	}
+	o := newOptions(nil)
+
+	for _, bc := range []struct {
+		name       string
+		symbolizer Symbolizer
+	}{
+		{"binary_search", newSymbolTable(symbols)},
+		{"linear_scan", linearSymbolTable(symbols)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if functions := mapLocations(locations, bc.symbolizer, o); len(functions) != len(locations) {
+					b.Fatalf("resolved %d of %d locations", len(functions), len(locations))
+				}
+			}
+		})
+	}
+}
+
+func TestRuntimeSymbolizer(t *testing.T) {
+	addr := uint64(reflect.ValueOf(TestRuntimeSymbolizer).Pointer())
+
+	profileCollector, err := NewCPUProfileCollector(
+		WithRuntimeSymbolizer(),
+		WithBinaryPath("/nonexistent"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	if name, ok := c.symbolizer.Resolve(addr); !ok || name != "github.com/travelaudience/pprofetheus.TestRuntimeSymbolizer" {
+		t.Errorf("Resolve(%#x) = %q, %t", addr, name, ok)
+	}
+	if name, ok := c.symbolizer.Resolve(0x10); ok {
+		t.Errorf("Resolve(0x10) = %q, expected no function", name)
+	}
+
+	// addresses unknown to the runtime are resolved using the fallback
+	// symbols.
+	profileCollector, err = NewCPUProfileCollector(
+		WithRuntimeSymbolizer(),
+		WithSymbols([]Symbol{{Name: "SSL_read", Addr: 0x10, Size: 0x10, Code: 'T'}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = profileCollector.(*cpuProfileCollector)
+	if name, ok := c.symbolizer.Resolve(0x10); !ok || name != "SSL_read" {
+		t.Errorf("Resolve(0x10) = %q, %t, expected SSL_read", name, ok)
+	}
+	if name, ok := c.symbolizer.Resolve(addr); !ok || name != "github.com/travelaudience/pprofetheus.TestRuntimeSymbolizer" {
+		t.Errorf("Resolve(%#x) = %q, %t", addr, name, ok)
+	}
+}
+
+func TestReadBinarySymbols(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, symbols, err := readBinarySymbols(newOptions(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != exe {
+		t.Errorf("read symbols of %s, expected %s", path, exe)
+	}
+	if len(symbols) == 0 {
+		t.Errorf("read no symbols from %s", path)
+	}
+}
+
+func TestRuntimeSymbolizerResolveFrames(t *testing.T) {
+	addr := uint64(reflect.ValueOf(TestRuntimeSymbolizerResolveFrames).Pointer())
+
+	names, ok := runtimeSymbolizer{}.ResolveFrames(addr)
+	if !ok || len(names) == 0 {
+		t.Fatalf("ResolveFrames(%#x) = %v, %t", addr, names, ok)
+	}
+	if name, _ := (runtimeSymbolizer{}).Resolve(addr); names[0] != name {
+		t.Errorf("innermost frame %q differs from resolved function %q", names[0], name)
+	}
+}
+
+func TestSymbolCacheResolveFrames(t *testing.T) {
+	cache := newSymbolCache(fallbackSymbolizer{
+		fakeSymbolizer{0x2000: "main.main"},
+		fakeFrameSymbolizer{0x1000: {"main.handle", "main.main"}},
+	})
+
+	for _, tt := range []struct {
+		addr  uint64
+		names []string
+	}{
+		{0x1000, []string{"main.handle", "main.main"}},
+		{0x2000, []string{"main.main"}},
+		{0x3000, nil},
+	} {
+		names, ok := cache.ResolveFrames(tt.addr)
+		if ok != (tt.names != nil) || !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("ResolveFrames(%#x) = %v, %t, expected %v", tt.addr, names, ok, tt.names)
+		}
+	}
+	if name, ok := cache.Resolve(0x1000); !ok || name != "main.handle" {
+		t.Errorf("Resolve(0x1000) = %q, %t, expected main.handle", name, ok)
+	}
+}
+
+func TestIsEmptySymbolTable(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	testData := []struct {
+		Symbolizer    Symbolizer
+		ExpectedEmpty bool
+	}{
+		{symbolTable{}, true},
+		{newSymbolTable(symbols), false},
+		{newCompactSymbolTable(nil), true},
+		{newCompactSymbolTable(symbols), false},
+		{biasedSymbolizer{symbolizer: symbolTable{}, bias: 0x1000}, true},
+		// the shared objects don't make up for the symbols of the binary.
+		{fallbackSymbolizer{biasedSymbolizer{symbolizer: newCompactSymbolTable(nil)}, newSymbolTable(symbols)}, true},
+		{fallbackSymbolizer{newSymbolTable(symbols), symbolTable{}}, false},
+		{fallbackSymbolizer{runtimeSymbolizer{}, symbolTable{}}, false},
+		{runtimeSymbolizer{}, false},
+	}
+
+	for idx, testEntry := range testData {
+		if empty := isEmptySymbolTable(testEntry.Symbolizer); empty != testEntry.ExpectedEmpty {
+			t.Errorf("%d. isEmptySymbolTable(%T) = %t, expected %t", idx, testEntry.Symbolizer, empty, testEntry.ExpectedEmpty)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package pprofetheus
+
+import (
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/travelaudience/pprofetheus/internal/objfile"
+)
+
+// WithCompactSymbols stores the symbols read from binaries in a compact form:
+// their names are deduplicated and kept in a single string arena, and only
+// the address, size and position of the name are kept per symbol. This
+// reduces the memory held for the symbol tables of large binaries, which is
+// exported as pprof_symbolizer_memory_bytes.
+func WithCompactSymbols() Option {
+	return func(o *options) {
+		o.compactSymbols = true
+	}
+}
+
+// compactSymbol is a symbol of a compactSymbolTable.
+type compactSymbol struct {
+	addr uint64
+	size uint64
+	// name and nameLen are the position of the name in the arena.
+	name    uint32
+	nameLen uint32
+}
+
+// compactSymbolTable is a Symbolizer like symbolTable that keeps the names of
+// its symbols in a single string. Its symbols are sorted by address.
+type compactSymbolTable struct {
+	arena   string
+	symbols []compactSymbol
+	// bytes is the estimated memory held by the table, like for a
+	// symbolTable.
+	bytes uint64
+}
+
+// newCompactSymbolTable returns a compact symbol table of symbols, which are
+// sorted like by newSymbolTable.
+func newCompactSymbolTable(symbols []objfile.Sym) *compactSymbolTable {
+	sorted := newSymbolTable(symbols)
+
+	var arena strings.Builder
+	offsets := make(map[string]uint32)
+	t := &compactSymbolTable{symbols: make([]compactSymbol, len(sorted.symbols))}
+	for i, s := range sorted.symbols {
+		off, ok := offsets[s.Name]
+		if !ok {
+			off = uint32(arena.Len())
+			arena.WriteString(s.Name)
+			offsets[s.Name] = off
+		}
+		t.symbols[i] = compactSymbol{addr: s.Addr, size: uint64(s.Size), name: off, nameLen: uint32(len(s.Name))}
+	}
+	t.arena = arena.String()
+	t.bytes = uint64(len(t.arena)) + uint64(len(t.symbols))*uint64(unsafe.Sizeof(compactSymbol{}))
+	return t
+}
+
+func (t *compactSymbolTable) Resolve(addr uint64) (string, bool) {
+	i, ok := resolveIndex(addr, len(t.symbols), func(i int) (uint64, uint64) {
+		return t.symbols[i].addr, t.symbols[i].size
+	})
+	if !ok {
+		return "", false
+	}
+	s := t.symbols[i]
+	return t.arena[s.name : s.name+s.nameLen], true
+}
+
+// newBinarySymbolTable returns a symbol table of the symbols read from a
+// binary, which is compact if WithCompactSymbols has been set.
+func newBinarySymbolTable(o *options, symbols []objfile.Sym) Symbolizer {
+	if o.compactSymbols {
+		return newCompactSymbolTable(symbols)
+	}
+	return newSymbolTable(symbols)
+}
+
+// memorySizer is implemented by the Symbolizers that can estimate the memory
+// that they hold. It is called on every scrape, so the symbol tables, which
+// never change, estimate it once when they are built.
+type memorySizer interface {
+	memoryBytes() uint64
+}
+
+// symbolizerMemory returns the estimated memory in bytes held by symbolizer,
+// or 0 if it can't be estimated, e.g. for custom Symbolizers.
+func symbolizerMemory(symbolizer Symbolizer) uint64 {
+	if m, ok := symbolizer.(memorySizer); ok {
+		return m.memoryBytes()
+	}
+	return 0
+}
+
+// symbolTableBytes returns the estimated memory held by the symbols of a
+// symbolTable.
+func symbolTableBytes(symbols []objfile.Sym) uint64 {
+	bytes := uint64(len(symbols)) * uint64(unsafe.Sizeof(objfile.Sym{}))
+	for _, s := range symbols {
+		bytes += uint64(len(s.Name) + len(s.Type))
+	}
+	return bytes
+}
+
+func (t symbolTable) memoryBytes() uint64 {
+	return t.bytes
+}
+
+func (t *compactSymbolTable) memoryBytes() uint64 {
+	return t.bytes
+}
+
+func (s *lazySymbolizer) memoryBytes() uint64 {
+	// the index doesn't exist before the first lookup.
+	return atomic.LoadUint64(&s.indexBytes)
+}
+
+func (s biasedSymbolizer) memoryBytes() uint64 {
+	return symbolizerMemory(s.symbolizer)
+}
+
+func (s fallbackSymbolizer) memoryBytes() uint64 {
+	var bytes uint64
+	for _, symbolizer := range s {
+		bytes += symbolizerMemory(symbolizer)
+	}
+	return bytes
+}
+
+// cacheEntryBytes is the estimated size of an entry of a symbolCache, without
+// the names, which are mostly shared with the symbol table.
+const cacheEntryBytes = uint64(unsafe.Sizeof(uint64(0)) + unsafe.Sizeof(cachedNames{}))
+
+func (c *symbolCache) memoryBytes() uint64 {
+	return c.bytes + symbolizerMemory(c.symbolizer)
+}
@@ -0,0 +1,65 @@
+package pprofetheus
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/travelaudience/pprofetheus/internal/objfile"
+)
+
+func TestCompactSymbolTable(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.c", Addr: 0x1200, Size: 0},
+		{Name: "main.b", Addr: 0x1100, Size: 0x100},
+		{Name: "main.a", Addr: 0x1000, Size: 0x100},
+		{Name: "main.d", Addr: 0x1300, Size: 0x10},
+		{Name: "main.a", Addr: 0x1400, Size: 0x10},
+	}
+	table := newSymbolTable(symbols)
+	compact := newCompactSymbolTable(symbols)
+
+	for addr := uint64(0xf00); addr < 0x1500; addr += 0x8 {
+		expectedName, expectedOK := table.Resolve(addr)
+		if name, ok := compact.Resolve(addr); name != expectedName || ok != expectedOK {
+			t.Errorf("%#x resolved to %q, %t, expected %q, %t", addr, name, ok, expectedName, expectedOK)
+		}
+	}
+
+	// the name main.a is only stored once.
+	if compact.arena != "main.amain.bmain.cmain.d" {
+		t.Errorf("arena = %q", compact.arena)
+	}
+	if bytes, tableBytes := compact.memoryBytes(), table.memoryBytes(); bytes >= tableBytes {
+		t.Errorf("compact symbol table holds %d bytes, expected less than the %d bytes of the symbol table", bytes, tableBytes)
+	}
+}
+
+func TestSymbolizerMemory(t *testing.T) {
+	table := newSymbolTable([]Symbol{{Name: "main.main", Addr: 0x1000, Size: 0x100}})
+	tableBytes := uint64(unsafe.Sizeof(objfile.Sym{})) + uint64(len("main.main"))
+	if bytes := symbolizerMemory(table); bytes != tableBytes {
+		t.Errorf("symbol table holds %d bytes, expected %d", bytes, tableBytes)
+	}
+	if bytes := symbolizerMemory(fallbackSymbolizer{runtimeSymbolizer{}, biasedSymbolizer{symbolizer: table}}); bytes != tableBytes {
+		t.Errorf("fallback symbolizer holds %d bytes, expected %d", bytes, tableBytes)
+	}
+
+	cache := newSymbolCache(table)
+	cache.Resolve(0x1000)
+	cache.Resolve(0x1000)
+	cache.Resolve(0x2000)
+	if bytes, expected := symbolizerMemory(cache), tableBytes+2*cacheEntryBytes+uint64(unsafe.Sizeof("")); bytes != expected {
+		t.Errorf("symbol cache holds %d bytes, expected %d", bytes, expected)
+	}
+}
+
+func TestCPUProfileCollectorSymbolizerMemory(t *testing.T) {
+	symbols := []Symbol{{Name: "main.main", Addr: 0x1000, Size: 0x100}}
+	c, _ := newFakeCollector(t, symbols, nil, &fakeClock{now: time.Unix(0, 0)})
+
+	collectMetrics(c)
+	if bytes := gaugeValue(t, c.symbolizerMemory); bytes != float64(newSymbolTable(symbols).memoryBytes()) {
+		t.Errorf("pprof_symbolizer_memory_bytes = %f, expected %d", bytes, newSymbolTable(symbols).memoryBytes())
+	}
+}
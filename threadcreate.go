@@ -0,0 +1,21 @@
+package pprofetheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewThreadCreateProfileCollector creates a collector that exports the number
+// of OS threads created by function, as recorded by the runtime's threadcreate
+// profile, e.g. to find the code paths that spawn threads in cgo-heavy
+// programs.
+func NewThreadCreateProfileCollector(opts ...Option) prometheus.Collector {
+	return newRuntimeProfileCollector(lookupProfile("threadcreate"), threadCreateProfileMetric, newOptions(opts))
+}
+
+var threadCreateProfileMetric = runtimeProfileMetric{
+	subsystem: "threadcreate",
+	name:      "threads_total",
+	help:      "counter of OS threads created",
+	valueType: "threadcreate",
+	divisor:   1,
+}
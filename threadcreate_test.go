@@ -0,0 +1,21 @@
+package pprofetheus
+
+import (
+	"testing"
+)
+
+func TestThreadCreateProfileCollector(t *testing.T) {
+	c := NewThreadCreateProfileCollector().(*runtimeProfileCollector)
+
+	// the runtime has created threads by now, at least for the main
+	// goroutine and the test's goroutines.
+	total := 0.0
+	for _, m := range collectMetrics(c) {
+		if m.Desc() == c.value {
+			total += counterValue(t, m)
+		}
+	}
+	if total < 1 {
+		t.Errorf("%f threads created, expected at least 1", total)
+	}
+}
@@ -0,0 +1,117 @@
+package pprofetheus
+
+import (
+	"math"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// seriesWindow accumulates the values of the series of a metric over one
+// profile, so that series below the minimum time can be dropped before they
+// are added to the metric.
+type seriesWindow struct {
+	values    map[string]float64
+	labels    map[string][]string
+	exemplars map[string]prometheus.Labels
+}
+
+func newSeriesWindow() *seriesWindow {
+	return &seriesWindow{
+		values:    make(map[string]float64),
+		labels:    make(map[string][]string),
+		exemplars: make(map[string]prometheus.Labels),
+	}
+}
+
+// reset removes all series from w, keeping the memory allocated for them. It
+// returns w.
+func (w *seriesWindow) reset() *seriesWindow {
+	for key := range w.values {
+		delete(w.values, key)
+	}
+	for key := range w.labels {
+		delete(w.labels, key)
+	}
+	for key := range w.exemplars {
+		delete(w.exemplars, key)
+	}
+	return w
+}
+
+// add adds value to the series with the label values labels.
+func (w *seriesWindow) add(labels []string, value float64) {
+	w.addWithExemplar(labels, value, nil)
+}
+
+// addWithExemplar adds value to the series with the label values labels, and
+// makes exemplar the exemplar of the series unless it is nil.
+func (w *seriesWindow) addWithExemplar(labels []string, value float64, exemplar prometheus.Labels) {
+	key := strings.Join(labels, "\x00")
+	if _, ok := w.labels[key]; !ok {
+		w.labels[key] = labels
+	}
+	w.values[key] += value
+	if exemplar != nil {
+		w.exemplars[key] = exemplar
+	}
+}
+
+// remove removes the series with the key key.
+func (w *seriesWindow) remove(key string) {
+	delete(w.values, key)
+	delete(w.labels, key)
+	delete(w.exemplars, key)
+}
+
+// flush adds the accumulated values of the series for which keep returns true
+// to the metric v along with their exemplars, and records the update of those
+// series in ttl.
+func (w *seriesWindow) flush(v *prometheus.CounterVec, keep func(key string) bool, ttl *seriesTTL) {
+	for key, value := range w.values {
+		if keep(key) {
+			counter := v.WithLabelValues(w.labels[key]...)
+			if exemplar, ok := w.exemplars[key]; ok {
+				counter.(prometheus.ExemplarAdder).AddWithExemplar(value, exemplar)
+			} else {
+				counter.Add(value)
+			}
+			ttl.touch(v, key, w.labels[key])
+		}
+	}
+}
+
+// flushGauge adds the accumulated values of the series for which keep returns
+// true to the gauge vector v.
+func (w *seriesWindow) flushGauge(v *prometheus.GaugeVec, keep func(key string) bool) {
+	for key, value := range w.values {
+		if keep(key) {
+			v.WithLabelValues(w.labels[key]...).Add(value)
+		}
+	}
+}
+
+// setShares sets the series of the gauge vector v for which keep returns true
+// to their share of the total value total.
+func (w *seriesWindow) setShares(v *prometheus.GaugeVec, total float64, keep func(key string) bool) {
+	if total <= 0 {
+		return
+	}
+	for key, value := range w.values {
+		if keep(key) {
+			v.WithLabelValues(w.labels[key]...).Set(value / total)
+		}
+	}
+}
+
+// keepAll is a function for flush that keeps all series.
+func keepAll(key string) bool {
+	return true
+}
+
+// minTime returns the CPU time in the unit of the time metrics that a function
+// needs to have used in a profile with the total CPU time total to be accounted
+// at all.
+func (o *options) minTime(total float64) float64 {
+	return math.Max(o.minTimeMS/o.timeScale(), o.minShare*total)
+}
@@ -0,0 +1,60 @@
+package pprofetheus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCPUProfileCollectorMinTime(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.hot", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.cold", Addr: 0x1200, Size: 0x100, Code: 'T'},
+	}
+	stacks := [][]string{
+		{"main.hot", "main.main"},
+		{"main.hot", "main.main"},
+		{"main.hot", "main.main"},
+		{"main.cold", "main.main"},
+	}
+
+	for _, tt := range []struct {
+		name string
+		opt  Option
+	}{
+		{"time", WithMinTime(20 * time.Millisecond)},
+		{"share", WithMinShare(0.5)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := profileCollector.(*cpuProfileCollector)
+			c.EnableSampleCount(true)
+
+			// main.cold stays below the threshold in each profile.
+			for i := 0; i < 3; i++ {
+				c.addProfile(testProfile(t, symbols, stacks...))
+			}
+
+			flat := make(map[string]float64)
+			for _, m := range collectMetrics(c.timeUsed) {
+				fn, _ := functionLabel(t, m)
+				flat[fn] = counterValue(t, m)
+			}
+			if len(flat) != 2 || flat["main.hot"] != 90 || flat[otherFunction] != 30 {
+				t.Errorf("time used = %v, expected main.hot with 90 and %s with 30", flat, otherFunction)
+			}
+			if value := counterValue(t, c.samples.WithLabelValues(otherFunction)); value != 3 {
+				t.Errorf("samples of %s = %f, expected 3", otherFunction, value)
+			}
+			if value := counterValue(t, c.droppedSamples.WithLabelValues(reasonMinTime)); value != 3 {
+				t.Errorf("samples dropped below the minimum time = %f, expected 3", value)
+			}
+			if value := counterValue(t, c.timeUsedCum.WithLabelValues("main.main")); value != 120 {
+				t.Errorf("cumulated time used by main.main = %f, expected 120", value)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package pprofetheus
+
+import (
+	"sort"
+	"strings"
+)
+
+// otherFunction is the label value of the series that the series beyond the
+// limit set with WithMaxFunctions are summed up in, as well as the time of the
+// samples that were filtered or below the minimum time. It is reserved, so
+// that it can't be mistaken for a function.
+const otherFunction = "_other_"
+
+// otherLabels returns the label values of the series otherFunction, which has
+// all labels set to otherFunction.
+func (o *options) otherLabels() []string {
+	values := make([]string, len(o.labelNames()))
+	for i := range values {
+		values[i] = otherFunction
+	}
+	return values
+}
+
+// seriesLimit is the set of at most n series of a metric that are exported on
+// their own with WithMaxFunctions. Series are admitted once, when they first
+// appear while there is room, and stay admitted until they are deleted, so
+// that the values of all exported series, including otherFunction, only
+// increase. A nil seriesLimit admits all series.
+type seriesLimit struct {
+	n        int
+	admitted map[string]bool
+}
+
+func newSeriesLimit(n int) *seriesLimit {
+	if n <= 0 {
+		return nil
+	}
+	return &seriesLimit{n: n, admitted: make(map[string]bool)}
+}
+
+// admit admits the series of w that aren't admitted yet while there is room,
+// those with the greatest values first.
+func (l *seriesLimit) admit(w *seriesWindow, other []string) {
+	if l == nil || len(l.admitted) >= l.n {
+		return
+	}
+	otherKey := strings.Join(other, "\x00")
+	var candidates []string
+	for key := range w.values {
+		if !l.admitted[key] && key != otherKey {
+			candidates = append(candidates, key)
+		}
+	}
+	// ties are broken by labels, so that the same series are admitted for
+	// the same profile.
+	sort.Slice(candidates, func(i, j int) bool {
+		if vi, vj := w.values[candidates[i]], w.values[candidates[j]]; vi != vj {
+			return vi > vj
+		}
+		return candidates[i] < candidates[j]
+	})
+	for _, key := range candidates {
+		if len(l.admitted) >= l.n {
+			break
+		}
+		l.admitted[key] = true
+	}
+}
+
+// fold moves the values of the series of w that aren't admitted into the
+// series with the label values other.
+func (l *seriesLimit) fold(w *seriesWindow, other []string) {
+	if l == nil {
+		return
+	}
+	otherKey := strings.Join(other, "\x00")
+	var sum float64
+	folded := false
+	for key, value := range w.values {
+		if !l.admitted[key] && key != otherKey {
+			sum += value
+			folded = true
+			w.remove(key)
+		}
+	}
+	if folded {
+		w.add(other, sum)
+	}
+}
+
+// forget makes room for another series after the series key was deleted.
+func (l *seriesLimit) forget(key string) {
+	if l == nil {
+		return
+	}
+	delete(l.admitted, key)
+}
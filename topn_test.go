@@ -0,0 +1,163 @@
+package pprofetheus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCPUProfileCollectorMaxFunctions(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.a", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.b", Addr: 0x1200, Size: 0x100, Code: 'T'},
+		{Name: "main.c", Addr: 0x1300, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithMaxFunctions(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.EnableCumulative(false)
+	c.addProfile(testProfile(t, symbols,
+		[]string{"main.a", "main.main"},
+		[]string{"main.a", "main.main"},
+		[]string{"main.a", "main.main"},
+		[]string{"main.b", "main.main"},
+		[]string{"main.b", "main.main"},
+		[]string{"main.c", "main.main"},
+		[]string{"main.main"},
+	))
+
+	timeUsed := collectMetrics(c.timeUsed)[0].Desc()
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(c) {
+		if fn, ok := functionLabel(t, m); ok && m.Desc() == timeUsed {
+			values[fn] = counterValue(t, m)
+		}
+	}
+
+	expected := map[string]float64{"main.a": 30, "main.b": 20, otherFunction: 20}
+	if len(values) != len(expected) {
+		t.Errorf("got series %v, expected %v", values, expected)
+	}
+	for fn, value := range expected {
+		if values[fn] != value {
+			t.Errorf("time used by %s = %f, expected %f", fn, values[fn], value)
+		}
+	}
+}
+
+func TestCPUProfileCollectorMaxFunctionsOther(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.a", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.b", Addr: 0x1200, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithMaxFunctions(1), WithMinTime(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.EnableCumulative(false)
+	c.addProfile(testProfile(t, symbols,
+		[]string{"main.a", "main.main"},
+		[]string{"main.a", "main.main"},
+		[]string{"main.a", "main.main"},
+		[]string{"main.b", "main.main"},
+		[]string{"main.b", "main.main"},
+		[]string{"main.main"},
+	))
+
+	// main.main is below the minimum time, main.b beyond the limit, and both
+	// are exported as a single series.
+	timeUsed := collectMetrics(c.timeUsed)[0].Desc()
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(c) {
+		if fn, ok := functionLabel(t, m); ok && m.Desc() == timeUsed {
+			if _, ok := values[fn]; ok {
+				t.Errorf("duplicate series for %s", fn)
+			}
+			values[fn] = counterValue(t, m)
+		}
+	}
+	expected := map[string]float64{"main.a": 30, otherFunction: 30}
+	if len(values) != len(expected) || values["main.a"] != 30 || values[otherFunction] != 30 {
+		t.Errorf("got series %v, expected %v", values, expected)
+	}
+}
+
+func TestCPUProfileCollectorMaxFunctionsRankSwap(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.a", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.b", Addr: 0x1200, Size: 0x100, Code: 'T'},
+	}
+
+	profileCollector, err := NewCPUProfileCollector(WithSymbols(symbols), WithMaxFunctions(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := profileCollector.(*cpuProfileCollector)
+	c.EnableCumulative(false)
+
+	// main.a has the most CPU time in the first profile, and main.b in the
+	// second one and overall.
+	profiles := [][][]string{
+		{{"main.a"}, {"main.a"}, {"main.a"}, {"main.b"}, {"main.b"}},
+		{{"main.a"}, {"main.b"}, {"main.b"}, {"main.b"}, {"main.b"}, {"main.b"}},
+	}
+	expected := []map[string]float64{
+		{"main.a": 30, otherFunction: 20},
+		{"main.a": 40, otherFunction: 70},
+	}
+	previous := make(map[string]float64)
+	for i, stacks := range profiles {
+		c.addProfile(testProfile(t, symbols, stacks...))
+
+		timeUsed := collectMetrics(c.timeUsed)[0].Desc()
+		values := make(map[string]float64)
+		for _, m := range collectMetrics(c) {
+			if fn, ok := functionLabel(t, m); ok && m.Desc() == timeUsed {
+				values[fn] = counterValue(t, m)
+			}
+		}
+		if len(values) != len(expected[i]) || values["main.a"] != expected[i]["main.a"] || values[otherFunction] != expected[i][otherFunction] {
+			t.Errorf("%d. got series %v, expected %v", i, values, expected[i])
+		}
+		for fn, value := range previous {
+			if values[fn] < value {
+				t.Errorf("%d. time used by %s decreased from %f to %f", i, fn, value, values[fn])
+			}
+		}
+		previous = values
+	}
+}
+
+func TestCPUProfileCollectorMaxFunctionsSeriesTTL(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.init", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.init"}), &fakeClock{now: time.Unix(0, 0), ticker: newFakeTicker()}, WithMaxFunctions(1), WithSeriesTTL(1))
+	c.EnableCumulative(false)
+	c.Start()
+	defer c.Stop()
+	drain := func() {
+		c.Lock()
+		defer c.Unlock()
+		c.drain()
+	}
+
+	// main.init takes the only series, until it is deleted for not being
+	// sampled anymore.
+	drain()
+	prof.setProfile(t, testProfile(t, symbols, []string{"main.main"}))
+	drain()
+	drain()
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != 10 {
+		t.Errorf("time used by main.main = %f, expected 10 once main.init was deleted", value)
+	}
+}
@@ -0,0 +1,62 @@
+package pprofetheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// seriesTTL tracks in which collection cycle the series of metrics have last
+// been updated, so that series that haven't been updated for a number of
+// cycles can be deleted. A nil seriesTTL never deletes any series.
+type seriesTTL struct {
+	cycles   int
+	cycle    int
+	lastSeen map[*prometheus.CounterVec]map[string]seenSeries
+}
+
+type seenSeries struct {
+	labels []string
+	cycle  int
+}
+
+func newSeriesTTL(cycles int) *seriesTTL {
+	if cycles <= 0 {
+		return nil
+	}
+	return &seriesTTL{
+		cycles:   cycles,
+		lastSeen: make(map[*prometheus.CounterVec]map[string]seenSeries),
+	}
+}
+
+// touch records that the series of v with the label values labels, which
+// are joined to key, has been updated in the current cycle.
+func (t *seriesTTL) touch(v *prometheus.CounterVec, key string, labels []string) {
+	if t == nil {
+		return
+	}
+	series, ok := t.lastSeen[v]
+	if !ok {
+		series = make(map[string]seenSeries)
+		t.lastSeen[v] = series
+	}
+	series[key] = seenSeries{labels: labels, cycle: t.cycle}
+}
+
+// expire deletes all series that haven't been updated for the configured
+// number of cycles, passing each of them to deleted, and starts the next
+// cycle.
+func (t *seriesTTL) expire(deleted func(v *prometheus.CounterVec, key string)) {
+	if t == nil {
+		return
+	}
+	for v, series := range t.lastSeen {
+		for key, s := range series {
+			if t.cycle-s.cycle >= t.cycles {
+				v.DeleteLabelValues(s.labels...)
+				delete(series, key)
+				deleted(v, key)
+			}
+		}
+	}
+	t.cycle++
+}
@@ -0,0 +1,74 @@
+package pprofetheus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCPUProfileCollectorSeriesTTL(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "main.init", Addr: 0x1100, Size: 0x100, Code: 'T'},
+	}
+
+	clk := &fakeClock{now: time.Unix(0, 0), ticker: newFakeTicker()}
+	c, prof := newFakeCollector(t, symbols, testProfile(t, symbols, []string{"main.init"}), clk, WithSeriesTTL(2))
+	c.Start()
+	defer c.Stop()
+
+	functions := func() map[string]bool {
+		result := make(map[string]bool)
+		for _, m := range collectMetrics(c.timeUsed) {
+			fn, _ := functionLabel(t, m)
+			result[fn] = true
+		}
+		return result
+	}
+	drain := func() {
+		c.Lock()
+		defer c.Unlock()
+		c.drain()
+	}
+
+	drain()
+	prof.setProfile(t, testProfile(t, symbols, []string{"main.main"}))
+	for cycle := 1; cycle <= 4; cycle++ {
+		drain()
+
+		fns := functions()
+		if !fns["main.main"] {
+			t.Errorf("drain %d: main.main was deleted although it is sampled", cycle)
+		}
+		// main.init is only sampled up to the first drain, so it is deleted
+		// on the second drain after that.
+		if expected := cycle < 2; fns["main.init"] != expected {
+			t.Errorf("drain %d: main.init exported = %t, expected %t", cycle, fns["main.init"], expected)
+		}
+	}
+}
+
+func TestCPUProfileCollectorSeriesTTLScrapes(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+
+	clk := &fakeClock{now: time.Unix(0, 0), ticker: newFakeTicker()}
+	c, _ := newFakeCollector(t, symbols, nil, clk, WithSeriesTTL(1), WithDrainInterval(defaultDrainInterval))
+	c.Start()
+	defer c.Stop()
+
+	// scrapes between two drains of the background profiler don't count as
+	// cycles, so the series isn't deleted before the profiler was read again.
+	c.addProfile(testProfile(t, symbols, []string{"main.main"}))
+	for scrape := 1; scrape <= 3; scrape++ {
+		found := false
+		for _, m := range collectMetrics(c) {
+			if fn, _ := functionLabel(t, m); fn == "main.main" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("scrape %d: main.main was deleted without a drain", scrape)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package pprofetheus
+
+import "strings"
+
+// millisPerSecond converts milliseconds to seconds.
+const millisPerSecond = 1000
+
+// timeName returns the name of the time metric name, which is given in
+// milliseconds, in the unit selected by WithBaseUnits, e.g.
+// "time_used_seconds_total" for "time_used_ms" and "time_seconds_interval" for
+// "time_ms_interval".
+func (o *options) timeName(name string) string {
+	if !o.baseUnits {
+		return name
+	}
+	if strings.HasSuffix(name, "_ms") {
+		return strings.TrimSuffix(name, "_ms") + "_seconds_total"
+	}
+	return strings.Replace(name, "_ms_", "_seconds_", 1)
+}
+
+// timeHelp returns the help text help of a time metric in the unit selected by
+// WithBaseUnits.
+func (o *options) timeHelp(help string) string {
+	if !o.baseUnits {
+		return help
+	}
+	return strings.Replace(help, "milliseconds", "seconds", 1)
+}
+
+// timeScale returns the number of milliseconds in the unit of the time
+// metrics, by which their values in milliseconds are divided.
+func (o *options) timeScale() float64 {
+	if o.baseUnits {
+		return millisPerSecond
+	}
+	return 1
+}
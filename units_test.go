@@ -0,0 +1,30 @@
+package pprofetheus
+
+import "testing"
+
+func TestTimeName(t *testing.T) {
+	testData := []struct {
+		name      string
+		baseUnits bool
+		expected  string
+	}{
+		{"time_used_ms", false, "time_used_ms"},
+		{"time_used_ms", true, "time_used_seconds_total"},
+		{"time_used_cum_ms", true, "time_used_cum_seconds_total"},
+		{"time_ms_interval", true, "time_seconds_interval"},
+		{"samples_total", true, "samples_total"},
+	}
+
+	for _, tt := range testData {
+		o := &options{baseUnits: tt.baseUnits}
+		if name := o.timeName(tt.name); name != tt.expected {
+			t.Errorf("timeName(%q) with base units %t = %q, expected %q", tt.name, tt.baseUnits, name, tt.expected)
+		}
+	}
+}
+
+func TestCumNameBaseUnits(t *testing.T) {
+	if name := cumName("wait_time_seconds_total"); name != "wait_time_cum_seconds_total" {
+		t.Errorf("cumName = %q", name)
+	}
+}
@@ -0,0 +1,165 @@
+package pprofetheus
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	wallClockSubsystem = "wallclock"
+)
+
+// WallClockCollector describes a collector of wall-clock time. It can act as a
+// prometheus.Collector plus it can be Start()ed and Stop()ed to limit sampling
+// to only desired time periods.
+type WallClockCollector interface {
+	prometheus.Collector
+	Start()
+	Stop()
+}
+
+// NewWallClockCollector creates a collector that exports the wall-clock time
+// spent in functions, whether on or off CPU, e.g. waiting for I/O or locks.
+// While it is running, it samples the stacks of all goroutines hz times per
+// second and accounts 1/hz seconds to the functions of each stack. Like
+// reading the goroutine profile, every sample stops the world, so hz should
+// be kept low in programs with many goroutines.
+func NewWallClockCollector(hz int, opts ...Option) (WallClockCollector, error) {
+	if hz <= 0 {
+		return nil, fmt.Errorf("invalid sampling rate %d", hz)
+	}
+	o := newOptions(opts)
+
+	symbolizer, err := newSymbolizer(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWallClockCollector(hz, symbolizer, o, func() []runtime.StackRecord {
+		// the runtime records the calling goroutine, i.e. the sampling
+		// goroutine, first.
+		return readGoroutineProfile()[1:]
+	}), nil
+}
+
+func newWallClockCollector(hz int, symbolizer Symbolizer, o *options, records func() []runtime.StackRecord) *wallClockCollector {
+	return &wallClockCollector{
+		hz:          hz,
+		symbolCache: newSymbolCache(symbolizer),
+		opts:        o,
+		records:     records,
+		timeUsed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(wallClockSubsystem),
+				Name:        o.timeName("time_ms"),
+				Help:        o.help(o.timeName("time_ms"), o.timeHelp("wall-clock time spent by function in milliseconds")),
+				ConstLabels: o.constLabels,
+			},
+			o.functionLabels(),
+		),
+		timeUsedCum: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystemOr(wallClockSubsystem),
+				Name:        o.timeName("time_cum_ms"),
+				Help:        o.help(o.timeName("time_cum_ms"), o.timeHelp("wall-clock time spent by function in milliseconds (cumulated)")),
+				ConstLabels: o.constLabels,
+			},
+			o.functionLabels(),
+		),
+	}
+}
+
+type wallClockCollector struct {
+	sync.Mutex
+	hz          int
+	symbolCache *symbolCache
+	opts        *options
+	records     func() []runtime.StackRecord
+	timeUsed    *prometheus.CounterVec
+	timeUsedCum *prometheus.CounterVec
+	running     bool
+	// stop is closed to stop run, which closes done once it returned.
+	stop, done chan struct{}
+}
+
+func (c *wallClockCollector) Start() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.running {
+		return
+	}
+	c.running = true
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go c.run(c.stop, c.done)
+}
+
+// Stop stops the sampling and returns once the last sample has been accounted.
+func (c *wallClockCollector) Stop() {
+	c.Lock()
+	if !c.running {
+		c.Unlock()
+		return
+	}
+	c.running = false
+	close(c.stop)
+	done := c.done
+	// run takes the lock to account a sample, so it is waited for after
+	// releasing it.
+	c.Unlock()
+	<-done
+}
+
+func (c *wallClockCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.timeUsed.Describe(ch)
+	c.timeUsedCum.Describe(ch)
+}
+
+func (c *wallClockCollector) Collect(ch chan<- prometheus.Metric) {
+	c.timeUsed.Collect(ch)
+	c.timeUsedCum.Collect(ch)
+}
+
+// run samples the goroutine stacks until stop is closed, and closes done
+// when it returns.
+func (c *wallClockCollector) run(stop, done chan struct{}) {
+	defer close(done)
+	t := c.opts.clock.NewTicker(time.Second / time.Duration(c.hz))
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.Chan():
+			c.sample()
+		}
+	}
+}
+
+// sample accounts one sampling interval to the functions of every goroutine's
+// stack.
+func (c *wallClockCollector) sample() {
+	records := c.records()
+
+	c.Lock()
+	defer c.Unlock()
+
+	value := 1000 / float64(c.hz) / c.opts.timeScale()
+	for _, r := range records {
+		stack := r.Stack()
+		if name, ok := c.opts.stackFunction(stack, c.symbolCache); ok {
+			c.timeUsed.WithLabelValues(name).Add(value)
+		}
+		for _, name := range c.opts.stackFunctions(stack, c.symbolCache) {
+			c.timeUsedCum.WithLabelValues(name).Add(value)
+		}
+	}
+}
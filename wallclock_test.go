@@ -0,0 +1,90 @@
+package pprofetheus
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWallClockCollector(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "runtime.gopark", Addr: 0x1000, Size: 0x100, Code: 'T'},
+		{Name: "runtime.goexit.abi0", Addr: 0x1100, Size: 0x100, Code: 'T'},
+		{Name: "main.serve", Addr: 0x1200, Size: 0x100, Code: 'T'},
+		{Name: "main.read", Addr: 0x1300, Size: 0x100, Code: 'T'},
+	}
+	records := func() []runtime.StackRecord {
+		return []runtime.StackRecord{
+			{Stack0: [32]uintptr{0x1010, 0x1310, 0x1210, 0x1110}},
+			{Stack0: [32]uintptr{0x1010, 0x1210, 0x1110}},
+		}
+	}
+
+	ticker := newFakeTicker()
+	o := newOptions(nil)
+	o.clock = &fakeClock{now: time.Unix(0, 0), ticker: ticker}
+	c := newWallClockCollector(10, newSymbolTable(symbols), o, records)
+
+	c.Start()
+	for i := 0; i < 3; i++ {
+		ticker.c <- time.Unix(0, 0)
+	}
+	// Stop waits for the last sample to be accounted.
+	c.Stop()
+
+	c.Lock()
+	defer c.Unlock()
+	if value := counterValue(t, c.timeUsedCum.WithLabelValues("main.serve")); value != 600 {
+		t.Errorf("cumulated wall-clock time of main.serve = %f, expected 600", value)
+	}
+	if value := counterValue(t, c.timeUsed.WithLabelValues("runtime.gopark")); value != 600 {
+		t.Errorf("wall-clock time of runtime.gopark = %f, expected 600", value)
+	}
+	if value := counterValue(t, c.timeUsedCum.WithLabelValues("main.read")); value != 300 {
+		t.Errorf("cumulated wall-clock time of main.read = %f, expected 300", value)
+	}
+	if n := len(collectMetrics(c.timeUsedCum)); n != 3 {
+		t.Errorf("%d cumulated series, expected 3 without runtime.goexit", n)
+	}
+}
+
+func TestWallClockCollectorStopDuringSample(t *testing.T) {
+	symbols := []Symbol{
+		{Name: "main.main", Addr: 0x1000, Size: 0x100, Code: 'T'},
+	}
+	sampling := make(chan struct{})
+	release := make(chan struct{})
+	records := func() []runtime.StackRecord {
+		sampling <- struct{}{}
+		<-release
+		return []runtime.StackRecord{{Stack0: [32]uintptr{0x1010}}}
+	}
+
+	ticker := newFakeTicker()
+	o := newOptions(nil)
+	o.clock = &fakeClock{now: time.Unix(0, 0), ticker: ticker}
+	c := newWallClockCollector(10, newSymbolTable(symbols), o, records)
+
+	c.Start()
+	ticker.c <- time.Unix(0, 0)
+	<-sampling
+
+	stopped := make(chan struct{})
+	go func() {
+		c.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned while a sample was being taken")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	<-stopped
+	c.Lock()
+	defer c.Unlock()
+	if value := counterValue(t, c.timeUsed.WithLabelValues("main.main")); value != 100 {
+		t.Errorf("wall-clock time of main.main = %f, expected the 100 of the last sample", value)
+	}
+}